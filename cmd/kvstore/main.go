@@ -1,11 +1,20 @@
 package main
 
 import (
+	"cloud/internal/auth"
+	"cloud/internal/cluster"
 	"cloud/internal/config"
 	"cloud/internal/core"
 	"cloud/internal/handlers"
+	"cloud/internal/health"
 	"cloud/internal/logger"
+	"cloud/internal/metrics"
+	"cloud/internal/replication"
+	"cloud/internal/resp"
+	"cloud/internal/rpc"
 	"cloud/internal/server"
+	"cloud/internal/storage"
+	"cloud/internal/tracing"
 	"cloud/internal/transaction"
 	"context"
 	"log/slog"
@@ -21,37 +30,206 @@ func main() {
 	cfg := config.MustLoad()
 	log := logger.NewLogger(cfg.Env)
 
-	transactorFactory := transaction.NewTransactorFactory(cfg)
-	transactor, err := transactorFactory.Create(ctx, transaction.TransactorTypePostgres)
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
 	if err != nil {
-		log.Error("failed to create transaction logger", slog.Any("error", err))
+		log.Error("failed to set up tracing", slog.Any("error", err))
 		os.Exit(1)
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Error("failed to flush traces", slog.Any("error", err))
+		}
+	}()
+
+	var (
+		transactor transaction.Transactor
+		node       *cluster.RaftTransactor
+	)
+	if cfg.Cluster.Enabled {
+		opts, err := cluster.NewOptions(cfg.Cluster)
+		if err != nil {
+			log.Error("failed to open raft storage", slog.Any("error", err))
+			os.Exit(1)
+		}
+		node = cluster.NewRaftTransactor(opts, cfg.Cluster.ApplyTimeout, log)
+		transactor = node
+	} else {
+		transactorFactory := transaction.NewTransactorFactory(cfg)
+		t, err := transactorFactory.Create(ctx, transaction.TransactorTypePostgres)
+		if err != nil {
+			log.Error("failed to create transaction logger", slog.Any("error", err))
+			os.Exit(1)
+		}
+		transactor = t
+	}
 	defer func() {
 		if err := transactor.Close(); err != nil {
 			log.Error("failed to close transactor", slog.Any("error", err))
 		}
 	}()
 
-	store, err := core.NewStore(transactor, log)
+	spaces, err := storage.New(cfg.Store)
+	if err != nil {
+		log.Error("failed to open storage engine", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer func() {
+		if err := spaces.Close(); err != nil {
+			log.Error("failed to close storage engine", slog.Any("error", err))
+		}
+	}()
+
+	store, err := core.NewStoreWithKeyspaces(spaces, transactor, log)
 	if err != nil {
 		log.Error("failed to create store", slog.Any("err", err))
 		os.Exit(1)
 	}
+	if cfg.Store.MaxBytes > 0 || cfg.Store.MaxKeys > 0 {
+		limits, err := storeLimits(cfg.Store, cfg.Store.MaxBytes, cfg.Store.MaxKeys)
+		if err == nil {
+			err = store.SetLimits(limits)
+		}
+		if err != nil {
+			log.Error("failed to set store limits", slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+	store.SetSizeLimits(cfg.Store.MaxKeySize, cfg.Store.MaxValueSize)
+	for namespace, quota := range cfg.Store.Quotas {
+		limits, err := storeLimits(cfg.Store, quota.MaxBytes, quota.MaxKeys)
+		if err == nil {
+			err = store.SetQuota(namespace, limits)
+		}
+		if err != nil {
+			log.Error("failed to set namespace quota", slog.String("namespace", namespace), slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+	workersCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+
+	var (
+		follower       *replication.Follower
+		clusterHandler *cluster.Handler
+	)
+	switch {
+	case node != nil:
+		// raft keeps the store read-only unless this node leads
+		if err := node.Start(store); err != nil {
+			log.Error("failed to start raft", slog.Any("error", err))
+			os.Exit(1)
+		}
+		clusterHandler = cluster.NewHandler(node, log)
+		go store.RunReaper(workersCtx, cfg.Store.ReapInterval)
+		if cfg.Store.SnapshotInterval > 0 {
+			go store.RunSnapshotter(workersCtx, cfg.Store.SnapshotInterval)
+		}
+	case cfg.Replication.Role == replication.RoleFollower:
+		// the leader journals every change, including expiries
+		store.SetReadOnly(true)
+		f, err := replication.NewFollower(cfg.Replication, store, log)
+		if err != nil {
+			log.Error("failed to create follower", slog.Any("err", err))
+			os.Exit(1)
+		}
+		follower = f
+		go follower.Run(workersCtx)
+	default:
+		go store.RunReaper(workersCtx, cfg.Store.ReapInterval)
+		if cfg.Store.SnapshotInterval > 0 {
+			go store.RunSnapshotter(workersCtx, cfg.Store.SnapshotInterval)
+		}
+	}
+
 	handler := handlers.NewHandler(store, log)
+	handler.SetSizeLimits(cfg.Store.MaxKeySize, cfg.Store.MaxValueSize)
+	handler.SetBatchLimit(cfg.Store.MaxBatchSize)
+	replicationHandler := replication.NewHandler(store, follower, log)
+
+	// a standalone node restores the journal before it serves, the others
+	// catch up in the background
+	healthHandler := health.NewHandler(store, cfg, log)
+	healthHandler.AddCheck("journal", transactor.Ping)
+	switch {
+	case node != nil:
+		healthHandler.AddCheck("replay", func(context.Context) error { return node.Replayed() })
+	case follower != nil:
+		healthHandler.AddCheck("replay", func(context.Context) error { return follower.Ready() })
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
-	routes := server.NewRouter(handler, log)
-	srv := server.NewServer(cfg.HTTP, log, routes)
+	guard, err := auth.New(cfg.Auth)
+	if err != nil {
+		log.Error("failed to configure auth", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	registry, err := metrics.NewRegistry(store.Collector())
+	if err != nil {
+		log.Error("failed to register metrics", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	routes := server.NewRouter(handler, replicationHandler, clusterHandler, healthHandler, guard, metrics.Handler(registry), log)
+	srv, err := server.NewServer(cfg.HTTP, log, routes)
+	if err != nil {
+		log.Error("failed to create http server", slog.Any("err", err))
+		os.Exit(1)
+	}
 	srv.Start()
+	go srv.RunTLSReloader(workersCtx, cfg.HTTP.TLS.ReloadInterval)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := srv.ReloadTLS(); err != nil {
+				log.Error("failed to reload tls certificates", slog.Any("err", err))
+				continue
+			}
+			log.Info("reloaded tls certificates")
+		}
+	}()
+
+	var (
+		grpcSrv   *rpc.GRPCServer
+		grpcErrCh <-chan error // stays nil when grpc is disabled
+	)
+	if cfg.GRPC.Addr != "" {
+		grpcSrv = rpc.NewServer(cfg.GRPC, guard, log, rpc.NewService(store, log))
+		if err := grpcSrv.Start(); err != nil {
+			log.Error("failed to start grpc server", slog.Any("err", err))
+			os.Exit(1)
+		}
+		grpcErrCh = grpcSrv.ErrChan()
+	}
+
+	var (
+		respSrv   *resp.Server
+		respErrCh <-chan error // stays nil when the redis listener is disabled
+	)
+	if cfg.RESP.Addr != "" {
+		respSrv = resp.NewServer(cfg.RESP, store, guard, log)
+		if err := respSrv.Start(); err != nil {
+			log.Error("failed to start resp server", slog.Any("err", err))
+			os.Exit(1)
+		}
+		respErrCh = respSrv.ErrChan()
+	}
 
 	select {
 	case <-quit:
 		log.Info("got sycall to finish service")
 	case err := <-srv.ErrChan():
 		log.Error("got err from server", slog.Any("err", err))
+	case err := <-grpcErrCh:
+		log.Error("got err from grpc server", slog.Any("err", err))
+	case err := <-respErrCh:
+		log.Error("got err from resp server", slog.Any("err", err))
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -59,4 +237,28 @@ func main() {
 	if err := srv.Stop(ctx); err != nil {
 		log.Error("got err from server shutdown", slog.Any("err", err))
 	}
+	if grpcSrv != nil {
+		if err := grpcSrv.Stop(ctx); err != nil {
+			log.Error("got err from grpc server shutdown", slog.Any("err", err))
+		}
+	}
+	if respSrv != nil {
+		if err := respSrv.Stop(ctx); err != nil {
+			log.Error("got err from resp server shutdown", slog.Any("err", err))
+		}
+	}
+}
+
+// storeLimits bounds a namespace, every namespace gets a policy of its own
+func storeLimits(cfg config.StoreConfig, maxBytes int64, maxKeys int) (core.Limits, error) {
+	policy, err := core.NewEvictionPolicy(cfg.EvictionPolicy)
+	if err != nil {
+		return core.Limits{}, err
+	}
+	return core.Limits{
+		MaxBytes:         maxBytes,
+		MaxKeys:          maxKeys,
+		Policy:           policy,
+		JournalEvictions: cfg.JournalEvictions,
+	}, nil
 }
@@ -0,0 +1,96 @@
+package apierror
+
+import (
+	"cloud/internal/core"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// RequestIDHeader carries the request id to the client and from a proxy that
+// assigned one already
+const RequestIDHeader = "X-Request-ID"
+
+// Codes of failures outside the store, the rest come from core
+const (
+	CodeUnauthenticated  core.Code = "unauthenticated"
+	CodePermissionDenied core.Code = "permission_denied"
+	CodeNotLeader        core.Code = "not_leader"
+	CodeRouteNotFound    core.Code = "route_not_found"
+	CodeMethodNotAllowed core.Code = "method_not_allowed"
+)
+
+var statuses = map[core.Code]int{
+	core.CodeInternal:            http.StatusInternalServerError,
+	core.CodeInvalidArgument:     http.StatusBadRequest,
+	core.CodeNotFound:            http.StatusNotFound,
+	core.CodePreconditionFailed:  http.StatusPreconditionFailed,
+	core.CodeReadOnly:            http.StatusConflict,
+	core.CodeUnavailable:         http.StatusServiceUnavailable,
+	core.CodeInsufficientStorage: http.StatusInsufficientStorage,
+	core.CodeTooLarge:            http.StatusRequestEntityTooLarge,
+	core.CodeGone:                http.StatusGone,
+	CodeUnauthenticated:          http.StatusUnauthorized,
+	CodePermissionDenied:         http.StatusForbidden,
+	CodeNotLeader:                http.StatusMisdirectedRequest,
+	CodeRouteNotFound:            http.StatusNotFound,
+	CodeMethodNotAllowed:         http.StatusMethodNotAllowed,
+}
+
+type envelope struct {
+	Error body `json:"error"`
+}
+
+type body struct {
+	Code      core.Code `json:"code"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the id of the request ctx belongs to, empty if it has none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Status returns the HTTP status of code, 500 for unknown codes
+func Status(code core.Code) int {
+	if status, ok := statuses[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Write sends the error envelope with the status of code
+func Write(w http.ResponseWriter, r *http.Request, code core.Code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(Status(code))
+	_ = json.NewEncoder(w).Encode(envelope{Error: body{
+		Code:      code,
+		Message:   message,
+		RequestID: RequestID(r.Context()),
+	}})
+}
+
+// WriteError sends err classified by its code, errors without one are internal
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	Write(w, r, CodeOf(err), err.Error())
+}
+
+// CodeOf is core.CodeOf knowing the errors of net/http as well
+func CodeOf(err error) core.Code {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return core.CodeTooLarge
+	}
+	return core.CodeOf(err)
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"cloud/internal/config"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Permission uint8
+
+const (
+	PermRead Permission = 1 << iota
+	PermWrite
+	PermDelete
+	// PermAdmin guards the operational endpoints: replication, cluster
+	// membership and watches over every namespace
+	PermAdmin
+)
+
+// AnyNamespace in a rule matches every namespace
+const AnyNamespace = "*"
+
+var ErrUnknownPermission = errors.New("unknown permission")
+
+var permissionNames = map[string]Permission{
+	"read":   PermRead,
+	"write":  PermWrite,
+	"delete": PermDelete,
+	"admin":  PermAdmin,
+}
+
+func ParsePermission(name string) (Permission, error) {
+	p, ok := permissionNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownPermission, name)
+	}
+	return p, nil
+}
+
+func (p Permission) String() string {
+	var names []string
+	for _, name := range []string{"read", "write", "delete", "admin"} {
+		if p&permissionNames[name] != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Rule grants permissions on the keys of a namespace starting with Prefix
+type Rule struct {
+	Namespace   string
+	Prefix      string
+	Permissions Permission
+}
+
+// covers reports whether every key starting with prefix in namespace falls under the rule
+func (r Rule) covers(namespace, prefix string) bool {
+	if r.Namespace != AnyNamespace && r.Namespace != namespace {
+		return false
+	}
+	return strings.HasPrefix(prefix, r.Prefix)
+}
+
+// ACL maps principals to the rules granted to them, anything not granted is denied
+type ACL map[string][]Rule
+
+func NewACL(cfg map[string][]config.ACLRuleConfig) (ACL, error) {
+	acl := make(ACL, len(cfg))
+	for principal, rules := range cfg {
+		for i, rc := range rules {
+			r := Rule{Namespace: rc.Namespace, Prefix: rc.Prefix}
+			for _, name := range rc.Permissions {
+				p, err := ParsePermission(name)
+				if err != nil {
+					return nil, fmt.Errorf("principal %s rule %d: %w", principal, i, err)
+				}
+				r.Permissions |= p
+			}
+			acl[principal] = append(acl[principal], r)
+		}
+	}
+	return acl, nil
+}
+
+// Allowed reports whether principal holds perm on key in namespace. The key
+// may stand for a prefix, it is then allowed only if every key starting with
+// it is. AnyNamespace asks for the permission in every namespace.
+func (a ACL) Allowed(principal string, perm Permission, namespace, key string) bool {
+	for _, r := range a[principal] {
+		if r.Permissions&perm == perm && r.covers(namespace, key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"cloud/internal/config"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+func signJWT(t *testing.T, alg, kid string, claims map[string]any, sign func([]byte) []byte) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	return signed + "." + b64.EncodeToString(sign([]byte(signed)))
+}
+
+func TestGuard(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hs", "k": b64.EncodeToString(secret)},
+		{
+			"kty": "RSA", "kid": "rs",
+			"n": b64.EncodeToString(rsaKey.N.Bytes()),
+			"e": b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	guard, err := New(config.AuthConfig{
+		Enabled: true,
+		Tokens:  []config.TokenConfig{{Token: "static-token", Principal: "ci"}},
+		JWT:     config.JWTConfig{JWKSFile: path, Issuer: "issuer", Audience: "kvstore", PrincipalClaim: "sub"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs256 := func(data []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+	rs256 := func(data []byte) []byte {
+		digest := sha256.Sum256(data)
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		return sig
+	}
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{"sub": "alice", "iss": "issuer", "aud": []string{"kvstore"}, "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		principal string
+		err       error
+	}{
+		{"Static Token", "static-token", "ci", nil},
+		{"Unknown Token", "other-token", "", ErrInvalidToken},
+		{"No Token", "", "", ErrNoCredentials},
+		{"HS256", signJWT(t, algHS256, "hs", claims(nil), hs256), "alice", nil},
+		{"RS256", signJWT(t, algRS256, "rs", claims(nil), rs256), "alice", nil},
+		{"RS256 Without Kid", signJWT(t, algRS256, "", claims(nil), rs256), "alice", nil},
+		{"Expired", signJWT(t, algHS256, "hs", claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}), hs256), "", ErrInvalidToken},
+		{"Not Yet Valid", signJWT(t, algHS256, "hs", claims(map[string]any{"nbf": time.Now().Add(time.Hour).Unix()}), hs256), "", ErrInvalidToken},
+		{"Wrong Issuer", signJWT(t, algHS256, "hs", claims(map[string]any{"iss": "other"}), hs256), "", ErrInvalidToken},
+		{"Wrong Audience", signJWT(t, algHS256, "hs", claims(map[string]any{"aud": "other"}), hs256), "", ErrInvalidToken},
+		{"Wrong Key", signJWT(t, algHS256, "rs", claims(nil), hs256), "", ErrInvalidToken},
+		{"Alg None", signJWT(t, "none", "", claims(nil), func([]byte) []byte { return nil }), "", ErrInvalidToken},
+		{"Tampered", strings.Replace(signJWT(t, algHS256, "hs", claims(nil), hs256), ".", ".e30", 1), "", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := guard.Authenticate(tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if principal != tt.principal {
+				t.Errorf("got principal %q, want %q", principal, tt.principal)
+			}
+		})
+	}
+}
+
+func TestACL(t *testing.T) {
+	acl, err := NewACL(map[string][]config.ACLRuleConfig{
+		"ci": {
+			{Prefix: "app/", Permissions: []string{"read", "write"}},
+			{Namespace: "team", Permissions: []string{"read"}},
+		},
+		"ops": {{Namespace: AnyNamespace, Permissions: []string{"read", "admin"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		principal string
+		perm      Permission
+		namespace string
+		key       string
+		allowed   bool
+	}{
+		{"ci", PermRead, "", "app/config", true},
+		{"ci", PermWrite, "", "app/config", true},
+		{"ci", PermDelete, "", "app/config", false},
+		{"ci", PermRead, "", "other", false},
+		{"ci", PermRead, "", "app", false}, // a list of "app" would reach past app/
+		{"ci", PermRead, "team", "anything", true},
+		{"ci", PermWrite, "team", "anything", false},
+		{"ci", PermRead, AnyNamespace, "", false},
+		{"ops", PermRead, AnyNamespace, "", true},
+		{"ops", PermAdmin, "team", "", true},
+		{"nobody", PermRead, "", "app/config", false},
+	}
+	for _, tt := range tests {
+		if got := acl.Allowed(tt.principal, tt.perm, tt.namespace, tt.key); got != tt.allowed {
+			t.Errorf("%s %s %q/%q: got %v, want %v", tt.principal, tt.perm, tt.namespace, tt.key, got, tt.allowed)
+		}
+	}
+
+	if _, err := NewACL(map[string][]config.ACLRuleConfig{"ci": {{Permissions: []string{"own"}}}}); !errors.Is(err, ErrUnknownPermission) {
+		t.Errorf("expected error %v, got %v", ErrUnknownPermission, err)
+	}
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	algHS256 = "HS256"
+	algRS256 = "RS256"
+)
+
+var ErrInvalidJWKS = errors.New("invalid jwks")
+
+// jwk is a JSON Web Key, only symmetric (oct) and RSA keys are used
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	K   string `json:"k"` // oct secret
+	N   string `json:"n"` // RSA modulus
+	E   string `json:"e"` // RSA exponent
+}
+
+type verificationKey struct {
+	kid    string
+	alg    string
+	secret []byte         // HS256
+	public *rsa.PublicKey // RS256
+}
+
+type jwtVerifier struct {
+	keys     []verificationKey
+	issuer   string
+	audience string
+	claim    string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// loadJWKS reads the keys of a local JWKS file
+func loadJWKS(path string) ([]verificationKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read jwks file: %w", err)
+	}
+	return parseJWKS(data)
+}
+
+func parseJWKS(data []byte) ([]verificationKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWKS, err)
+	}
+
+	keys := make([]verificationKey, 0, len(set.Keys))
+	for i, k := range set.Keys {
+		key := verificationKey{kid: k.Kid, alg: k.Alg}
+
+		switch k.Kty {
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return nil, fmt.Errorf("%w: key %d has an invalid secret", ErrInvalidJWKS, i)
+			}
+			key.secret = secret
+			if key.alg == "" {
+				key.alg = algHS256
+			}
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("%w: key %d has an invalid modulus or exponent", ErrInvalidJWKS, i)
+			}
+			key.public = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+			if key.alg == "" {
+				key.alg = algRS256
+			}
+		default:
+			continue // other key types are not used for verification
+		}
+
+		if key.alg != algHS256 && key.alg != algRS256 {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no HS256 or RS256 key", ErrInvalidJWKS)
+	}
+	return keys, nil
+}
+
+// verify checks the signature and the registered claims of a compact JWT and
+// returns the principal it names
+func (v *jwtVerifier) verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: malformed jwt", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", fmt.Errorf("%w: invalid jwt header", ErrInvalidToken)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid jwt signature", ErrInvalidToken)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if !v.signatureValid(header.Alg, header.Kid, signed, signature) {
+		return "", fmt.Errorf("%w: jwt signature mismatch", ErrInvalidToken)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("%w: invalid jwt claims", ErrInvalidToken)
+	}
+	return v.principal(claims)
+}
+
+// signatureValid tries the keys of the algorithm, only those with the kid if
+// the token names one. The algorithm comes from the key, never from the token
+// alone, so "none" and HS256 signed with an RSA public key are rejected.
+func (v *jwtVerifier) signatureValid(alg, kid string, signed, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+
+	for _, key := range v.keys {
+		if key.alg != alg || (kid != "" && key.kid != kid) {
+			continue
+		}
+
+		switch key.alg {
+		case algHS256:
+			mac := hmac.New(sha256.New, key.secret)
+			mac.Write(signed)
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return true
+			}
+		case algRS256:
+			if rsa.VerifyPKCS1v15(key.public, crypto.SHA256, digest[:], signature) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *jwtVerifier) principal(claims map[string]any) (string, error) {
+	now := v.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return "", fmt.Errorf("%w: jwt without exp", ErrInvalidToken)
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.leeway)) {
+		return "", fmt.Errorf("%w: jwt expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return "", fmt.Errorf("%w: jwt not valid yet", ErrInvalidToken)
+	}
+
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return "", fmt.Errorf("%w: unexpected jwt issuer", ErrInvalidToken)
+	}
+	if v.audience != "" && !hasAudience(claims["aud"], v.audience) {
+		return "", fmt.Errorf("%w: unexpected jwt audience", ErrInvalidToken)
+	}
+
+	principal, _ := claims[v.claim].(string)
+	if principal == "" {
+		return "", fmt.Errorf("%w: jwt without %s claim", ErrInvalidToken, v.claim)
+	}
+	return principal, nil
+}
+
+// hasAudience accepts aud as a single string or an array of strings
+func hasAudience(aud any, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
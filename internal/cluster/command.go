@@ -0,0 +1,96 @@
+package cluster
+
+import (
+	"cloud/internal/transaction"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// command is the payload of a raft log entry, its events are applied atomically
+type command struct {
+	Events []commandEvent `json:"events"`
+}
+
+type commandEvent struct {
+	Sequence  uint64                `json:"sequence,omitempty"` // zero lets the state machine number it
+	Type      transaction.EventType `json:"type"`
+	Namespace string                `json:"namespace,omitempty"`
+	Key       string                `json:"key"`
+	Data      []byte                `json:"data,omitempty"`  // the value, base64 keeps binary values intact
+	Value     string                `json:"value,omitempty"` // the value of entries written before data
+	ExpiresAt *time.Time            `json:"expires_at,omitempty"`
+
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time        `json:"updated_at,omitempty"`
+}
+
+func encodeCommand(events ...transaction.Event) ([]byte, error) {
+	cmd := command{Events: make([]commandEvent, 0, len(events))}
+	for _, e := range events {
+		ce := commandEvent{
+			Sequence:    e.Sequence,
+			Type:        e.EventType,
+			Namespace:   e.Namespace,
+			Key:         e.Key,
+			Data:        []byte(e.Value),
+			ExpiresAt:   timeOrNil(e.ExpiresAt),
+			ContentType: e.Metadata.ContentType,
+			Headers:     e.Metadata.Headers,
+			CreatedAt:   timeOrNil(e.Metadata.CreatedAt),
+			UpdatedAt:   timeOrNil(e.Metadata.UpdatedAt),
+		}
+		cmd.Events = append(cmd.Events, ce)
+	}
+	return json.Marshal(cmd)
+}
+
+func decodeCommand(data []byte) (command, error) {
+	var cmd command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return command{}, fmt.Errorf("command decoding failure: %w", err)
+	}
+	return cmd, nil
+}
+
+// events returns the journal events of the command with the sequences they were proposed with
+func (c command) events() []transaction.Event {
+	events := make([]transaction.Event, 0, len(c.Events))
+	for _, ce := range c.Events {
+		if ce.Data != nil {
+			ce.Value = string(ce.Data)
+		}
+		e := transaction.Event{
+			Sequence:  ce.Sequence,
+			EventType: ce.Type,
+			Namespace: ce.Namespace,
+			Key:       ce.Key,
+			Value:     ce.Value,
+			ExpiresAt: timeOrZero(ce.ExpiresAt),
+			Metadata: transaction.Metadata{
+				ContentType: ce.ContentType,
+				Headers:     ce.Headers,
+				CreatedAt:   timeOrZero(ce.CreatedAt),
+				UpdatedAt:   timeOrZero(ce.UpdatedAt),
+			},
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
@@ -0,0 +1,193 @@
+package cluster
+
+import (
+	"cloud/internal/transaction"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// historySize is the number of applied events kept for watch replay
+const historySize = 4096
+
+// snapshotFormat marks snapshots encoded by transaction.EncodeSnapshot, older
+// snapshots encoded transaction.Snapshot as is, losing binary values
+const snapshotFormat = 2
+
+type snapshotEnvelope struct {
+	Format   int             `json:"format"`
+	Snapshot json.RawMessage `json:"snapshot"`
+}
+
+// StateMachine is the store the committed log is applied to
+type StateMachine interface {
+	Apply(event transaction.Event) error
+	ApplySnapshot(snapshot transaction.Snapshot) error
+	ExportSnapshot(ctx context.Context) (transaction.Snapshot, error)
+	SetReadOnly(readOnly bool)
+}
+
+// fsm applies committed entries to the state machine. Every event keeps the
+// sequence it was proposed with or gets the next one, both derive from the
+// log, so they are the same on every node.
+type fsm struct {
+	sm   StateMachine
+	feed *transaction.Feed
+	log  *slog.Logger
+
+	mu       sync.Mutex
+	sequence uint64
+	dropped  uint64              // newest sequence no longer in history
+	history  []transaction.Event // the last applied events, oldest first
+}
+
+func newFSM(feed *transaction.Feed, log *slog.Logger) *fsm {
+	return &fsm{feed: feed, log: log}
+}
+
+func (f *fsm) Apply(entry *raft.Log) interface{} {
+	const op = "cluster.fsm.Apply"
+
+	log := f.log.With(
+		slog.String("op", op),
+	)
+
+	cmd, err := decodeCommand(entry.Data)
+	if err != nil {
+		log.Error("skipping entry", slog.Uint64("index", entry.Index), slog.Any("error", err))
+		return err
+	}
+
+	f.mu.Lock()
+	events := make([]transaction.Event, 0, len(cmd.Events))
+	for _, e := range cmd.events() {
+		if e.Sequence == 0 {
+			e.Sequence = f.sequence + 1
+		}
+		f.sequence = max(f.sequence, e.Sequence)
+		events = append(events, e)
+	}
+	f.history = append(f.history, events...)
+	if over := len(f.history) - historySize; over > 0 {
+		for _, e := range f.history[:over] {
+			f.dropped = max(f.dropped, e.Sequence)
+		}
+		f.history = append(f.history[:0], f.history[over:]...)
+	}
+	f.mu.Unlock()
+
+	// every node applies the entry here, the leader included, so the state
+	// only ever holds committed writes in log order
+	for _, e := range events {
+		if err := f.sm.Apply(e); err != nil {
+			log.Error("apply failed", slog.Uint64("sequence", e.Sequence), slog.Any("error", err))
+			return err
+		}
+	}
+
+	f.feed.Publish(events...)
+	return nil
+}
+
+// Snapshot exports the state on the apply goroutine, so it holds exactly the
+// entries up to the index raft takes the snapshot at. Persist only encodes it.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	snapshot, err := f.sm.ExportSnapshot(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to export snapshot: %w", err)
+	}
+	snapshot.Sequence = f.lastSequence()
+	return &fsmSnapshot{snapshot: snapshot}, nil
+}
+
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	snapshot, err := decodeSnapshot(rc)
+	if err != nil {
+		return err
+	}
+
+	if err := f.sm.ApplySnapshot(snapshot); err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	f.mu.Lock()
+	f.sequence = snapshot.Sequence
+	f.dropped = snapshot.Sequence
+	f.history = nil
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fsm) lastSequence() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.sequence
+}
+
+// replay returns the applied events with a sequence greater than after
+func (f *fsm) replay(after uint64) ([]transaction.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if after < f.dropped {
+		return nil, transaction.ErrCompacted
+	}
+
+	var events []transaction.Event
+	for _, e := range f.history {
+		if e.Sequence > after {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+type fsmSnapshot struct {
+	snapshot transaction.Snapshot
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	data, err := transaction.EncodeSnapshot(s.snapshot)
+	if err == nil {
+		err = json.NewEncoder(sink).Encode(snapshotEnvelope{Format: snapshotFormat, Snapshot: data})
+	}
+	if err != nil {
+		_ = sink.Cancel()
+		return fmt.Errorf("snapshot encoding failure: %w", err)
+	}
+	return sink.Close()
+}
+
+// decodeSnapshot reads a snapshot written by Persist or by an older version
+func decodeSnapshot(r io.Reader) (transaction.Snapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return transaction.Snapshot{}, fmt.Errorf("snapshot read failure: %w", err)
+	}
+
+	var envelope snapshotEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return transaction.Snapshot{}, fmt.Errorf("snapshot decoding failure: %w", err)
+	}
+	if envelope.Format == snapshotFormat {
+		return transaction.DecodeSnapshot(envelope.Snapshot)
+	}
+
+	var snapshot transaction.Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return transaction.Snapshot{}, fmt.Errorf("snapshot decoding failure: %w", err)
+	}
+	return snapshot, nil
+}
+
+func (s *fsmSnapshot) Release() {}
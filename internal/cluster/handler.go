@@ -0,0 +1,123 @@
+package cluster
+
+import (
+	"cloud/internal/apierror"
+	"cloud/internal/core"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Membership is the cluster node managed over HTTP
+type Membership interface {
+	Join(id, address string) error
+	Remove(id string) error
+	Members() ([]Member, error)
+	Leader() string
+}
+
+type joinRequest struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+type membersResponse struct {
+	Leader  string   `json:"leader"`
+	Members []Member `json:"members"`
+}
+
+type Handler struct {
+	node Membership
+	log  *slog.Logger
+}
+
+func NewHandler(node Membership, log *slog.Logger) *Handler {
+	return &Handler{
+		node: node,
+		log:  log,
+	}
+}
+
+// MembersHandler lists the cluster members and the current leader
+func (h *Handler) MembersHandler(w http.ResponseWriter, r *http.Request) {
+	const op = "cluster.Handler.MembersHandler"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	members, err := h.node.Members()
+	if err != nil {
+		log.Error("read members failed", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(membersResponse{Leader: h.node.Leader(), Members: members})
+}
+
+// JoinHandler adds the node from the body {"id", "address"} as a voter
+func (h *Handler) JoinHandler(w http.ResponseWriter, r *http.Request) {
+	const op = "cluster.Handler.JoinHandler"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	var req joinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, core.CodeInvalidArgument, "invalid join request: "+err.Error())
+		return
+	}
+	if req.ID == "" || req.Address == "" {
+		apierror.Write(w, r, core.CodeInvalidArgument, "id and address are required")
+		return
+	}
+
+	if err := h.node.Join(req.ID, req.Address); err != nil {
+		h.membershipError(w, r, log, "join failed", err)
+		return
+	}
+
+	log.Info("member joined", slog.String("id", req.ID), slog.String("address", req.Address))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveHandler removes the member named in the path
+func (h *Handler) RemoveHandler(w http.ResponseWriter, r *http.Request) {
+	const op = "cluster.Handler.RemoveHandler"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		apierror.Write(w, r, core.CodeInvalidArgument, "empty member id")
+		return
+	}
+
+	if err := h.node.Remove(id); err != nil {
+		h.membershipError(w, r, log, "remove failed", err)
+		return
+	}
+
+	log.Info("member removed", slog.String("id", id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// membershipError reports a change rejected by a follower as misdirected, with the leader to retry on
+func (h *Handler) membershipError(w http.ResponseWriter, r *http.Request, log *slog.Logger, msg string, err error) {
+	if errors.Is(err, ErrNotLeader) {
+		log.Warn(msg, slog.Any("error", err))
+		apierror.Write(w, r, apierror.CodeNotLeader, err.Error()+", leader is "+h.node.Leader())
+		return
+	}
+
+	log.Error(msg, slog.Any("error", err))
+	apierror.WriteError(w, r, err)
+}
@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+)
+
+type Member struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Voter   bool   `json:"voter"`
+	Leader  bool   `json:"leader"`
+}
+
+// Join adds a voting member, it must be called on the leader
+func (t *RaftTransactor) Join(id, address string) error {
+	if t.raft == nil {
+		return ErrNotStarted
+	}
+
+	err := t.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(address), 0, t.applyTimeout).Error()
+	if errors.Is(err, raft.ErrNotLeader) {
+		return ErrNotLeader
+	}
+	if err != nil {
+		return fmt.Errorf("cannot add member: %w", err)
+	}
+	return nil
+}
+
+// Remove drops a member from the cluster, it must be called on the leader
+func (t *RaftTransactor) Remove(id string) error {
+	if t.raft == nil {
+		return ErrNotStarted
+	}
+
+	err := t.raft.RemoveServer(raft.ServerID(id), 0, t.applyTimeout).Error()
+	if errors.Is(err, raft.ErrNotLeader) {
+		return ErrNotLeader
+	}
+	if err != nil {
+		return fmt.Errorf("cannot remove member: %w", err)
+	}
+	return nil
+}
+
+// Members returns the current cluster configuration
+func (t *RaftTransactor) Members() ([]Member, error) {
+	if t.raft == nil {
+		return nil, ErrNotStarted
+	}
+
+	future := t.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, fmt.Errorf("cannot read cluster configuration: %w", err)
+	}
+
+	_, leader := t.raft.LeaderWithID()
+
+	servers := future.Configuration().Servers
+	members := make([]Member, 0, len(servers))
+	for _, s := range servers {
+		members = append(members, Member{
+			ID:      string(s.ID),
+			Address: string(s.Address),
+			Voter:   s.Suffrage == raft.Voter,
+			Leader:  s.ID == leader,
+		})
+	}
+	return members, nil
+}
+
+// Leader returns the id of the current leader, empty if there is none
+func (t *RaftTransactor) Leader() string {
+	if t.raft == nil {
+		return ""
+	}
+
+	_, id := t.raft.LeaderWithID()
+	return string(id)
+}
@@ -0,0 +1,352 @@
+package cluster
+
+import (
+	"bytes"
+	"cloud/internal/core"
+	"cloud/internal/transaction"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+type testNode struct {
+	id         string
+	transactor *RaftTransactor
+	store      core.Store
+}
+
+// newTestCluster starts size nodes connected by in-memory transports,
+// the first one bootstraps a configuration with all of them
+func newTestCluster(t *testing.T, size int) []*testNode {
+	t.Helper()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	transports := make([]*raft.InmemTransport, size)
+	servers := make([]raft.Server, size)
+	for i := range transports {
+		id := fmt.Sprintf("node%d", i+1)
+		addr, transport := raft.NewInmemTransport(raft.ServerAddress(id))
+		transports[i] = transport
+		servers[i] = raft.Server{ID: raft.ServerID(id), Address: addr}
+	}
+	for _, a := range transports {
+		for _, b := range transports {
+			a.Connect(b.LocalAddr(), b)
+		}
+	}
+
+	nodes := make([]*testNode, size)
+	for i, transport := range transports {
+		conf := raft.DefaultConfig()
+		conf.LocalID = servers[i].ID
+		conf.HeartbeatTimeout = 50 * time.Millisecond
+		conf.ElectionTimeout = 50 * time.Millisecond
+		conf.LeaderLeaseTimeout = 50 * time.Millisecond
+		conf.CommitTimeout = 5 * time.Millisecond
+		conf.LogOutput = io.Discard
+
+		logs := raft.NewInmemStore()
+		opts := Options{
+			Raft:      conf,
+			Logs:      logs,
+			Stable:    logs,
+			Snapshots: raft.NewInmemSnapshotStore(),
+			Transport: transport,
+		}
+		if i == 0 {
+			if err := raft.BootstrapCluster(conf, logs, logs, opts.Snapshots, transport,
+				raft.Configuration{Servers: servers}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		transactor := NewRaftTransactor(opts, time.Second, log)
+		store, err := core.NewStore(transactor, log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := transactor.Start(store); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = &testNode{id: string(servers[i].ID), transactor: transactor, store: store}
+	}
+
+	t.Cleanup(func() {
+		for _, n := range nodes {
+			_ = n.transactor.Close()
+		}
+	})
+	return nodes
+}
+
+// waitLeader returns the leader among the running nodes once it accepts writes
+func waitLeader(t *testing.T, nodes []*testNode) *testNode {
+	t.Helper()
+
+	var leader *testNode
+	waitFor(t, func() bool {
+		for _, n := range nodes {
+			if n.transactor.raft.State() == raft.Leader &&
+				n.store.Put(context.Background(), "_probe", n.id) == nil {
+				leader = n
+				return true
+			}
+		}
+		return false
+	})
+	return leader
+}
+
+func TestClusterReplicates(t *testing.T) {
+	ctx := context.Background()
+	nodes := newTestCluster(t, 3)
+	leader := waitLeader(t, nodes)
+
+	if err := leader.store.Put(ctx, "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range nodes {
+		waitFor(t, func() bool {
+			value, err := n.store.Get(ctx, "key")
+			return err == nil && value == "value"
+		})
+	}
+
+	for _, n := range nodes {
+		if n == leader {
+			continue
+		}
+		if err := n.store.Put(ctx, "key", "other"); err != core.ErrReadOnly {
+			t.Errorf("%s: expected error %v, got %v", n.id, core.ErrReadOnly, err)
+		}
+	}
+
+	if err := leader.store.Batch(ctx, []core.Op{
+		{Type: core.OpPut, Key: "a", Value: "1"},
+		{Type: core.OpDelete, Key: "key"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range nodes {
+		waitFor(t, func() bool {
+			value, err := n.store.Get(ctx, "a")
+			_, gone := n.store.Get(ctx, "key")
+			return err == nil && value == "1" && gone != nil
+		})
+	}
+
+	sequences := make(map[uint64]bool)
+	versions := make(map[uint64]bool)
+	for _, n := range nodes {
+		sequence, _ := n.transactor.LastSequence()
+		sequences[sequence] = true
+		version, _ := n.store.Version(ctx, "a")
+		versions[version] = true
+	}
+	if len(sequences) != 1 {
+		t.Errorf("nodes applied different sequences: %v", sequences)
+	}
+	if len(versions) != 1 {
+		t.Errorf("nodes hold different versions: %v", versions)
+	}
+
+	for _, n := range nodes {
+		if err := n.transactor.Ping(ctx); err != nil {
+			t.Errorf("%s: ping failed: %v", n.id, err)
+		}
+		if err := n.transactor.Replayed(); err != nil {
+			t.Errorf("%s: replay not finished: %v", n.id, err)
+		}
+	}
+}
+
+func TestClusterSnapshot(t *testing.T) {
+	ctx := context.Background()
+	nodes := newTestCluster(t, 1)
+	leader := waitLeader(t, nodes)
+
+	// a binary value survives the command and the snapshot encoding
+	binary := "\xff\x00"
+	if err := leader.store.Put(ctx, "before", binary); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := leader.store.Get(ctx, "before"); value != binary {
+		t.Errorf("got applied value %q, want %q", value, binary)
+	}
+	snapshot, err := leader.transactor.fsm.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sequence := leader.transactor.fsm.lastSequence()
+
+	// the snapshot holds the state it was taken at, not the one it is persisted at
+	if err := leader.store.Put(ctx, "after", "2"); err != nil {
+		t.Fatal(err)
+	}
+	sink := &bufferSink{}
+	if err := snapshot.Persist(sink); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Clone(sink.Bytes())
+
+	persisted, err := decodeSnapshot(sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if persisted.Sequence != sequence {
+		t.Errorf("got snapshot sequence %d, want %d", persisted.Sequence, sequence)
+	}
+	for _, e := range persisted.Entries {
+		if e.Key == "after" {
+			t.Errorf("snapshot holds a write applied after it was taken")
+		}
+	}
+
+	if err := leader.transactor.fsm.Restore(io.NopCloser(bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := leader.store.Get(ctx, "before"); value != binary {
+		t.Errorf("got restored value %q, want %q", value, binary)
+	}
+	if _, err := leader.store.Get(ctx, "after"); err == nil {
+		t.Errorf("restore kept a write applied after the snapshot")
+	}
+}
+
+func TestRestoreFailure(t *testing.T) {
+	f := newFSM(&transaction.Feed{}, slog.Default())
+	f.sm = failingStateMachine{}
+
+	data, err := transaction.EncodeSnapshot(transaction.Snapshot{Sequence: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(snapshotEnvelope{Format: snapshotFormat, Snapshot: data}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Restore(io.NopCloser(&buf)); err == nil {
+		t.Fatal("restore succeeded although the snapshot was not applied")
+	}
+	if sequence := f.lastSequence(); sequence != 0 {
+		t.Errorf("got sequence %d after a failed restore, want 0", sequence)
+	}
+}
+
+// failingStateMachine fails every snapshot it is given
+type failingStateMachine struct {
+	StateMachine
+}
+
+func (failingStateMachine) ApplySnapshot(transaction.Snapshot) error {
+	return errors.New("disk full")
+}
+
+// bufferSink keeps a persisted snapshot in memory
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (s *bufferSink) ID() string    { return "test" }
+func (s *bufferSink) Cancel() error { return nil }
+func (s *bufferSink) Close() error  { return nil }
+
+func TestClusterFailover(t *testing.T) {
+	ctx := context.Background()
+	nodes := newTestCluster(t, 3)
+	leader := waitLeader(t, nodes)
+
+	if err := leader.store.Put(ctx, "key", "before"); err != nil {
+		t.Fatal(err)
+	}
+
+	var rest []*testNode
+	for _, n := range nodes {
+		if n != leader {
+			rest = append(rest, n)
+		}
+	}
+	if err := leader.transactor.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	next := waitLeader(t, rest)
+	if err := next.store.Put(ctx, "key", "after"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range rest {
+		waitFor(t, func() bool {
+			value, err := n.store.Get(ctx, "key")
+			return err == nil && value == "after"
+		})
+	}
+}
+
+func TestClusterMembership(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	leader := waitLeader(t, nodes)
+
+	var follower *testNode
+	for _, n := range nodes {
+		if n != leader {
+			follower = n
+			break
+		}
+	}
+
+	if err := follower.transactor.Remove(leader.id); err != ErrNotLeader {
+		t.Errorf("expected error %v, got %v", ErrNotLeader, err)
+	}
+
+	if err := leader.transactor.Remove(follower.id); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := leader.transactor.Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("got %d members, want 2", len(members))
+	}
+	for _, m := range members {
+		if m.ID == follower.id {
+			t.Errorf("removed member %s still listed", m.ID)
+		}
+		if m.Leader != (m.ID == leader.id) {
+			t.Errorf("member %s reported leader=%v", m.ID, m.Leader)
+		}
+	}
+
+	if err := leader.transactor.Join(follower.id, follower.id); err != nil {
+		t.Fatal(err)
+	}
+	members, _ = leader.transactor.Members()
+	if len(members) != 3 {
+		t.Errorf("got %d members, want 3", len(members))
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
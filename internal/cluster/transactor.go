@@ -0,0 +1,368 @@
+package cluster
+
+import (
+	"cloud/internal/config"
+	"cloud/internal/tracing"
+	"cloud/internal/transaction"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ transaction.Applier = &RaftTransactor{}
+
+var tracer = otel.Tracer("cloud/internal/cluster")
+
+var (
+	ErrNotStarted = errors.New("raft transactor is not started")
+	ErrNotLeader  = errors.New("node is not the cluster leader")
+	ErrNoLeader   = errors.New("cluster has no leader")
+	ErrReplaying  = errors.New("raft log is still being replayed")
+)
+
+// Options holds the raft dependencies, tests replace them with in-memory ones
+type Options struct {
+	Raft      *raft.Config
+	Logs      raft.LogStore
+	Stable    raft.StableStore
+	Snapshots raft.SnapshotStore
+	Transport raft.Transport
+	Bootstrap bool
+
+	// closers release the storage opened by NewOptions
+	closers []func() error
+}
+
+// NewOptions opens the on-disk raft storage and the TCP transport described by cfg
+func NewOptions(cfg config.ClusterConfig) (Options, error) {
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		return Options{}, fmt.Errorf("cannot create raft data dir: %w", err)
+	}
+
+	store, err := raftboltdb.NewBoltStore(filepath.Join(cfg.DataDir, "raft.db"))
+	if err != nil {
+		return Options{}, fmt.Errorf("cannot open raft log store: %w", err)
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(cfg.DataDir, 2, os.Stderr)
+	if err != nil {
+		_ = store.Close()
+		return Options{}, fmt.Errorf("cannot open raft snapshot store: %w", err)
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", cfg.BindAddr)
+	if err != nil {
+		_ = store.Close()
+		return Options{}, fmt.Errorf("invalid raft bind address: %w", err)
+	}
+	transport, err := raft.NewTCPTransport(cfg.BindAddr, addr, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		_ = store.Close()
+		return Options{}, fmt.Errorf("cannot start raft transport: %w", err)
+	}
+
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(cfg.NodeID)
+
+	return Options{
+		Raft:      conf,
+		Logs:      store,
+		Stable:    store,
+		Snapshots: snapshots,
+		Transport: transport,
+		Bootstrap: cfg.Bootstrap,
+		closers:   []func() error{transport.Close, store.Close},
+	}, nil
+}
+
+// RaftTransactor journals events to a raft log. A write returns once the entry
+// is committed by a quorum and applied to the state machine, on the leader as
+// on every other node. Only the leader accepts writes, the state machine is
+// kept read-only on every other node.
+type RaftTransactor struct {
+	opts         Options
+	applyTimeout time.Duration
+	raft         *raft.Raft
+	fsm          *fsm
+	feed         transaction.Feed
+	replayIndex  uint64 // last log index on disk when the node started
+	closed       uint32
+	done         chan struct{}
+	log          *slog.Logger
+}
+
+func NewRaftTransactor(opts Options, applyTimeout time.Duration, log *slog.Logger) *RaftTransactor {
+	t := &RaftTransactor{
+		opts:         opts,
+		applyTimeout: applyTimeout,
+		done:         make(chan struct{}),
+		log:          log,
+	}
+	t.fsm = newFSM(&t.feed, log)
+	return t
+}
+
+// Start joins the raft group and applies the committed log to sm. It must be
+// called once sm is created, sm is read-only until the node becomes leader.
+func (t *RaftTransactor) Start(sm StateMachine) error {
+	const op = "cluster.RaftTransactor.Start"
+
+	log := t.log.With(
+		slog.String("op", op),
+	)
+
+	sm.SetReadOnly(true)
+	t.fsm.sm = sm
+
+	leaderCh := make(chan bool, 1)
+	conf := *t.opts.Raft
+	conf.NotifyCh = leaderCh
+
+	r, err := raft.NewRaft(&conf, t.fsm, t.opts.Logs, t.opts.Stable, t.opts.Snapshots, t.opts.Transport)
+	if err != nil {
+		return fmt.Errorf("cannot start raft: %w", err)
+	}
+	t.raft = r
+	t.replayIndex = r.LastIndex()
+
+	if t.opts.Bootstrap {
+		hasState, err := raft.HasExistingState(t.opts.Logs, t.opts.Stable, t.opts.Snapshots)
+		if err != nil {
+			return fmt.Errorf("cannot read raft state: %w", err)
+		}
+		if !hasState {
+			err := r.BootstrapCluster(raft.Configuration{Servers: []raft.Server{{
+				ID:      conf.LocalID,
+				Address: t.opts.Transport.LocalAddr(),
+			}}}).Error()
+			if err != nil {
+				return fmt.Errorf("cannot bootstrap cluster: %w", err)
+			}
+			log.Info("cluster bootstrapped", slog.String("node", string(conf.LocalID)))
+		}
+	}
+
+	go t.watchLeadership(sm, leaderCh)
+	return nil
+}
+
+// watchLeadership opens the state machine for writes while the node leads
+func (t *RaftTransactor) watchLeadership(sm StateMachine, leaderCh <-chan bool) {
+	const op = "cluster.RaftTransactor.watchLeadership"
+
+	log := t.log.With(
+		slog.String("op", op),
+	)
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case leader := <-leaderCh:
+			if !leader {
+				sm.SetReadOnly(true)
+				log.Info("leadership lost")
+				continue
+			}
+
+			// every entry of the previous terms must be applied before serving writes
+			if err := t.raft.Barrier(t.applyTimeout).Error(); err != nil {
+				log.Error("barrier failed", slog.Any("error", err))
+				continue
+			}
+			sm.SetReadOnly(false)
+			log.Info("leadership acquired")
+		}
+	}
+}
+
+func (t *RaftTransactor) Close() error {
+	if !atomic.CompareAndSwapUint32(&t.closed, 0, 1) {
+		return transaction.ErrTransactorClosed
+	}
+	close(t.done)
+	defer t.feed.CloseAll()
+
+	if t.raft != nil {
+		if err := t.raft.Shutdown().Error(); err != nil {
+			return fmt.Errorf("raft shutdown error: %w", err)
+		}
+	}
+	for _, closer := range t.opts.closers {
+		if err := closer(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppliesCommitted marks the transactor as the one applying writes to the
+// store, through the state machine on every node
+func (t *RaftTransactor) AppliesCommitted() {}
+
+// Append proposes the events as a single log entry. The wait returns once it
+// is committed by a quorum and applied to the state machine, which is the
+// only place the write reaches the store.
+func (t *RaftTransactor) Append(ctx context.Context, events []transaction.Event) (_ func() error, err error) {
+	_, span := tracer.Start(ctx, "RaftTransactor.apply", trace.WithAttributes(
+		attribute.Int("journal.events", len(events)),
+	))
+	defer func() {
+		if err != nil {
+			tracing.End(span, err)
+		}
+	}()
+
+	if atomic.LoadUint32(&t.closed) == 1 {
+		return nil, transaction.ErrTransactorClosed
+	}
+	if t.raft == nil {
+		return nil, ErrNotStarted
+	}
+
+	data, err := encodeCommand(events...)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := t.applyTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline))
+	}
+
+	future := t.raft.Apply(data, timeout)
+	return func() (err error) {
+		defer func() {
+			tracing.End(span, err)
+		}()
+
+		if err := future.Error(); err != nil {
+			if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+				return ErrNotLeader
+			}
+			return fmt.Errorf("raft apply failure: %w", err)
+		}
+		if err, ok := future.Response().(error); ok {
+			return err
+		}
+		return nil
+	}, nil
+}
+
+// WriteSnapshot asks raft for a snapshot, which compacts its log. The given
+// snapshot is ignored, the raft snapshot exports the state machine itself.
+func (t *RaftTransactor) WriteSnapshot(ctx context.Context, _ transaction.Snapshot) error {
+	if atomic.LoadUint32(&t.closed) == 1 {
+		return transaction.ErrTransactorClosed
+	}
+	if t.raft == nil {
+		return ErrNotStarted
+	}
+
+	err := t.raft.Snapshot().Error()
+	if errors.Is(err, raft.ErrNothingNewToSnapshot) {
+		return nil
+	}
+	return err
+}
+
+// ReadSnapshot returns nothing, the state is restored by raft once started
+func (t *RaftTransactor) ReadSnapshot() (transaction.Snapshot, error) {
+	return transaction.Snapshot{}, nil
+}
+
+// ReadEvents returns nothing, the committed log is replayed by raft once started
+func (t *RaftTransactor) ReadEvents() (<-chan transaction.Event, <-chan error) {
+	outEvent := make(chan transaction.Event)
+	outError := make(chan error, 1)
+	close(outEvent)
+	close(outError)
+
+	return outEvent, outError
+}
+
+// ReplayEvents yields the recently applied events with a sequence greater than
+// after, ErrCompacted is returned if they are no longer held in memory
+func (t *RaftTransactor) ReplayEvents(ctx context.Context, after uint64) (<-chan transaction.Event, <-chan error) {
+	outEvent := make(chan transaction.Event)
+	outError := make(chan error, 1)
+
+	go func() {
+		defer close(outEvent)
+		defer close(outError)
+
+		events, err := t.fsm.replay(after)
+		if err != nil {
+			outError <- err
+			return
+		}
+
+		for _, e := range events {
+			select {
+			case outEvent <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return outEvent, outError
+}
+
+// LastSequence returns the sequence of the last applied event
+func (t *RaftTransactor) LastSequence() (uint64, error) {
+	return t.fsm.lastSequence(), nil
+}
+
+// Subscribe streams every event once it is committed and applied
+func (t *RaftTransactor) Subscribe(buffer int) (<-chan transaction.Event, func()) {
+	return t.feed.Subscribe(buffer)
+}
+
+// Health reports the journal as degraded while no leader is known, a quorum
+// must be reachable for any write to commit
+func (t *RaftTransactor) Health() transaction.Health {
+	switch {
+	case atomic.LoadUint32(&t.closed) == 1:
+		return transaction.Health{LastError: transaction.ErrTransactorClosed}
+	case t.raft == nil:
+		return transaction.Health{LastError: ErrNotStarted}
+	}
+
+	if addr, _ := t.raft.LeaderWithID(); addr == "" {
+		return transaction.Health{LastError: ErrNoLeader}
+	}
+	return transaction.Health{Healthy: true}
+}
+
+// Ping fails while the node is not started, closed or knows no leader
+func (t *RaftTransactor) Ping(context.Context) error {
+	if health := t.Health(); !health.Healthy {
+		return health.LastError
+	}
+	return nil
+}
+
+// Replayed fails until the log found on disk at start is applied to the state
+// machine, the store misses those writes before
+func (t *RaftTransactor) Replayed() error {
+	if t.raft == nil {
+		return ErrNotStarted
+	}
+	if applied := t.raft.AppliedIndex(); applied < t.replayIndex {
+		return fmt.Errorf("%w: applied %d of %d", ErrReplaying, applied, t.replayIndex)
+	}
+	return nil
+}
@@ -9,9 +9,17 @@ import (
 )
 
 type Config struct {
-	Env      string         `yaml:"env"`
-	Postgres PostgresConfig `yaml:"postgres"`
-	HTTP     ServerConfig   `yaml:"http"`
+	Env         string            `yaml:"env"`
+	Postgres    PostgresConfig    `yaml:"postgres"`
+	HTTP        ServerConfig      `yaml:"http"`
+	GRPC        GRPCConfig        `yaml:"grpc"`
+	RESP        RESPConfig        `yaml:"resp"`
+	Store       StoreConfig       `yaml:"store"`
+	Journal     JournalConfig     `yaml:"journal"`
+	Replication ReplicationConfig `yaml:"replication"`
+	Cluster     ClusterConfig     `yaml:"cluster"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Tracing     TracingConfig     `yaml:"tracing"`
 }
 
 type PostgresConfig struct {
@@ -35,6 +43,113 @@ type ServerConfig struct {
 	ReadTimeout  time.Duration `yaml:"read_timeout"`
 	WriteTimeout time.Duration `yaml:"write_timeout"`
 	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	TLS          TLSConfig     `yaml:"tls"`
+}
+
+type TLSConfig struct {
+	CertFile          string        `yaml:"cert_file" env:"HTTP_TLS_CERT_FILE"` // empty serves plaintext
+	KeyFile           string        `yaml:"key_file" env:"HTTP_TLS_KEY_FILE"`
+	MinVersion        string        `yaml:"min_version" env-default:"1.2"`           // 1.2 or 1.3
+	ClientCAFile      string        `yaml:"client_ca_file" env:"HTTP_TLS_CLIENT_CA"` // verifies client certificates, empty disables mtls
+	RequireClientCert bool          `yaml:"require_client_cert"`                     // reject clients without a verified certificate
+	ReloadInterval    time.Duration `yaml:"reload_interval" env-default:"30s"`       // how often the files are checked for changes, zero only reloads on SIGHUP
+}
+
+type GRPCConfig struct {
+	Addr              string        `yaml:"addr" env:"GRPC_ADDR"` // empty disables the grpc server
+	ConnectionTimeout time.Duration `yaml:"connection_timeout" env-default:"10s"`
+}
+
+type RESPConfig struct {
+	Addr        string        `yaml:"addr" env:"RESP_ADDR"` // empty disables the redis listener
+	IdleTimeout time.Duration `yaml:"idle_timeout"`         // zero keeps idle connections open
+}
+
+type StoreConfig struct {
+	Engine           string        `yaml:"engine" env:"STORE_ENGINE" env-default:"memory"`      // memory or bolt
+	Path             string        `yaml:"path" env:"STORE_PATH" env-default:"./data/store.db"` // file of the bolt engine
+	ReapInterval     time.Duration `yaml:"reap_interval" env-default:"1s"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`                                                    // zero disables snapshots
+	MaxBytes         int64         `yaml:"max_bytes" env:"STORE_MAX_BYTES"`                                      // keys and values, zero is unlimited
+	MaxKeys          int           `yaml:"max_keys" env:"STORE_MAX_KEYS"`                                        // zero is unlimited
+	MaxKeySize       int           `yaml:"max_key_size" env:"STORE_MAX_KEY_SIZE" env-default:"1024"`             // bytes of a single key, zero is unlimited
+	MaxValueSize     int64         `yaml:"max_value_size" env:"STORE_MAX_VALUE_SIZE" env-default:"16777216"`     // bytes of a single value, zero is unlimited
+	EvictionPolicy   string        `yaml:"eviction_policy" env:"STORE_EVICTION_POLICY" env-default:"noeviction"` // lru, lfu, random or noeviction
+	JournalEvictions bool          `yaml:"journal_evictions"`                                                    // journal evicted keys as deletes
+
+	MaxBatchSize int64 `yaml:"max_batch_size" env:"STORE_MAX_BATCH_SIZE" env-default:"67108864"` // bytes of a batch body, zero uses the default
+	// Quotas bound single namespaces, they evict with EvictionPolicy
+	Quotas map[string]QuotaConfig `yaml:"quotas"`
+}
+
+type QuotaConfig struct {
+	MaxBytes int64 `yaml:"max_bytes"` // zero is unlimited
+	MaxKeys  int   `yaml:"max_keys"`  // zero is unlimited
+}
+
+type JournalConfig struct {
+	Durability       string        `yaml:"durability" env:"JOURNAL_DURABILITY" env-default:"group_commit"` // async, group_commit or sync
+	GroupCommitSize  int           `yaml:"group_commit_size" env-default:"256"`                            // most writes sharing a commit
+	GroupCommitDelay time.Duration `yaml:"group_commit_delay"`                                             // wait for more writes, zero commits what is queued
+}
+
+type ReplicationConfig struct {
+	Role          string        `yaml:"role" env:"REPLICATION_ROLE" env-default:"leader"`
+	LeaderURL     string        `yaml:"leader_url" env:"REPLICATION_LEADER_URL"`
+	RetryInterval time.Duration `yaml:"retry_interval" env-default:"1s"`
+	PollInterval  time.Duration `yaml:"poll_interval" env-default:"5s"`
+	Token         string        `yaml:"token" env:"REPLICATION_TOKEN"` // bearer token presented to an auth enabled leader
+	CAFile        string        `yaml:"ca_file"`                       // verifies a https leader, empty uses the system roots
+	CertFile      string        `yaml:"cert_file"`                     // client certificate presented to a mtls leader
+	KeyFile       string        `yaml:"key_file"`
+}
+
+type ClusterConfig struct {
+	Enabled      bool          `yaml:"enabled" env:"CLUSTER_ENABLED"`
+	NodeID       string        `yaml:"node_id" env:"CLUSTER_NODE_ID"`
+	BindAddr     string        `yaml:"bind_addr" env:"CLUSTER_BIND_ADDR" env-default:"127.0.0.1:7000"`
+	DataDir      string        `yaml:"data_dir" env:"CLUSTER_DATA_DIR" env-default:"./raft"`
+	Bootstrap    bool          `yaml:"bootstrap" env:"CLUSTER_BOOTSTRAP"`
+	ApplyTimeout time.Duration `yaml:"apply_timeout" env-default:"5s"`
+}
+
+type AuthConfig struct {
+	Enabled bool          `yaml:"enabled" env:"AUTH_ENABLED"` // false leaves the HTTP API open
+	Tokens  []TokenConfig `yaml:"tokens"`
+	JWT     JWTConfig     `yaml:"jwt"`
+	// CertPrincipals maps the subject of a verified client certificate to a
+	// principal, other subjects authenticate as their common name
+	CertPrincipals map[string]string `yaml:"cert_principals"`
+	// ACL grants each principal permissions on key prefixes
+	ACL map[string][]ACLRuleConfig `yaml:"acl"`
+}
+
+// TokenConfig is a static API token standing for a principal
+type TokenConfig struct {
+	Token     string `yaml:"token"`
+	Principal string `yaml:"principal"`
+}
+
+type JWTConfig struct {
+	JWKSFile       string        `yaml:"jwks_file" env:"AUTH_JWKS_FILE"`    // empty disables jwt
+	Issuer         string        `yaml:"issuer"`                            // empty accepts any issuer
+	Audience       string        `yaml:"audience"`                          // empty accepts any audience
+	PrincipalClaim string        `yaml:"principal_claim" env-default:"sub"` // claim naming the principal
+	Leeway         time.Duration `yaml:"leeway" env-default:"30s"`          // clock skew allowed on exp and nbf
+}
+
+type ACLRuleConfig struct {
+	Namespace   string   `yaml:"namespace"`   // empty is the default namespace, * is every namespace
+	Prefix      string   `yaml:"prefix"`      // empty is every key
+	Permissions []string `yaml:"permissions"` // read, write, delete or admin
+}
+
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" env-default:"none"`           // none, stdout or otlp
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT" env-default:"localhost:4318"` // host:port of the otlp http receiver
+	Insecure    bool    `yaml:"insecure" env:"TRACING_INSECURE"`                              // plain http to the otlp receiver
+	ServiceName string  `yaml:"service_name" env-default:"kvstore"`
+	SampleRatio float64 `yaml:"sample_ratio" env-default:"1"` // share of traces started here that are recorded
 }
 
 func MustLoad() *Config {
@@ -66,6 +181,14 @@ func MustLoadPath(configPath string) *Config {
 		panic("cannot read env vars: " + err.Error())
 	}
 
+	if cfg.Replication.Role == "follower" && cfg.Replication.LeaderURL == "" {
+		panic("replication leader_url cannot be empty for a follower")
+	}
+
+	if cfg.Cluster.Enabled && cfg.Cluster.NodeID == "" {
+		panic("cluster node_id cannot be empty")
+	}
+
 	return &cfg
 }
 
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"cloud/internal/storage"
+	"cloud/internal/transaction"
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var ErrInvalidOp = newError(CodeInvalidArgument, "invalid batch operation")
+
+type OpType string
+
+const (
+	OpPut    OpType = "put"
+	OpDelete OpType = "delete"
+)
+
+// Op is a single write of a batch, TTL is only used by puts and zero means no expiry
+type Op struct {
+	Type  OpType
+	Key   string
+	Value string
+	TTL   time.Duration
+}
+
+// Batch applies ops all-or-nothing under one lock and journals them as a single unit
+func (s *inMemoryStore) Batch(ctx context.Context, ops []Op) (err error) {
+	const op = "inMemoryStore.Batch"
+
+	ctx, span := s.startSpan(ctx, op, "")
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	if err := s.isWritable(); err != nil {
+		log.Warn("write rejected", slog.Any("error", err))
+		return err
+	}
+
+	for i, o := range ops {
+		if err := s.isKeyValid(o.Key); err != nil {
+			log.Error("empty key", slog.Int("index", i), slog.Any("error", err))
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+		if (o.Type != OpPut && o.Type != OpDelete) || o.TTL < 0 {
+			log.Error("invalid operation", slog.Int("index", i), slog.String("type", string(o.Type)))
+			return fmt.Errorf("operation %d: %w", i, ErrInvalidOp)
+		}
+		if o.Type == OpPut {
+			if err := s.isSizeValid(o.Key, len(o.Value)); err != nil {
+				log.Warn("entry too large", slog.Int("index", i), slog.Any("error", err))
+				return fmt.Errorf("operation %d: %w", i, err)
+			}
+		}
+	}
+
+	if len(ops) == 0 {
+		return nil
+	}
+
+	defer s.serialize()()
+
+	var (
+		now     = time.Now()
+		events  = make([]transaction.Event, 0, len(ops))
+		created = make(map[string]time.Time, len(ops)) // creation time a put of the key gets, zero once deleted
+	)
+
+	s.Lock()
+	for _, o := range ops {
+		if _, ok := created[o.Key]; !ok {
+			p, err := s.prior(o.Key)
+			if err != nil {
+				s.Unlock()
+				log.Error("storage read failed", slog.Any("error", err))
+				return err
+			}
+			created[o.Key] = stamp(transaction.Metadata{}, p, now).CreatedAt
+		}
+
+		switch o.Type {
+		case OpPut:
+			var expiresAt time.Time
+			if o.TTL > 0 {
+				expiresAt = now.Add(o.TTL)
+			}
+			if created[o.Key].IsZero() {
+				created[o.Key] = now
+			}
+			events = append(events, transaction.Event{
+				EventType: transaction.EventPut,
+				Key:       o.Key,
+				Value:     o.Value,
+				ExpiresAt: expiresAt,
+				Metadata:  transaction.Metadata{CreatedAt: created[o.Key], UpdatedAt: now},
+			})
+		case OpDelete:
+			created[o.Key] = time.Time{}
+			events = append(events, transaction.Event{
+				EventType: transaction.EventDelete,
+				Key:       o.Key,
+			})
+		}
+	}
+
+	changes := make([]storage.Change, len(events))
+	for i, e := range events {
+		changes[i] = change(e)
+	}
+	unit, err := s.makeRoom(changes...)
+	var wait func() error
+	if err == nil {
+		wait, err = s.commit(ctx, append(unit, events...)...)
+	}
+	s.Unlock()
+	if err != nil {
+		log.Error("storage write failed", slog.Any("error", err))
+		return fmt.Errorf("failed to store batch: %w", err)
+	}
+
+	if err := wait(); err != nil {
+		log.Error("journal write failed, rollback", slog.Any("error", err))
+		return fmt.Errorf("failed to log batch: %w", err)
+	}
+
+	log.Info("batch succeeded", slog.Int("operations", len(ops)))
+	return nil
+}
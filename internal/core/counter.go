@@ -0,0 +1,119 @@
+package core
+
+import (
+	"cloud/internal/storage"
+	"cloud/internal/transaction"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNotInteger = newError(CodeInvalidArgument, "value is not an integer")
+	ErrOverflow   = newError(CodeInvalidArgument, "increment would overflow")
+)
+
+// Incr adds delta to the integer value of the key and returns the result with
+// the version it was written at, a missing key counts from zero. The deadline
+// and metadata of the key are kept.
+func (s *inMemoryStore) Incr(ctx context.Context, key string, delta int64) (_ int64, _ uint64, err error) {
+	const op = "inMemoryStore.Incr"
+
+	ctx, span := s.startSpan(ctx, op, key)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	if err := s.isKeyValid(key); err != nil {
+		log.Error("empty key", slog.Any("error", ErrEmptyKey))
+		return 0, 0, err
+	}
+
+	if err := s.isSizeValid(key, 0); err != nil {
+		log.Warn("entry too large", slog.Any("error", err))
+		return 0, 0, err
+	}
+
+	if err := s.isWritable(); err != nil {
+		log.Warn("write rejected", slog.Any("error", err))
+		return 0, 0, err
+	}
+
+	defer s.serialize()()
+
+	// the result is journaled, so replay sets the value instead of adding to
+	// it again
+	s.Lock()
+
+	now := time.Now()
+	prior, err := s.prior(key)
+	if err != nil {
+		s.Unlock()
+		log.Error("storage read failed", slog.Any("error", err))
+		return 0, 0, err
+	}
+	entry, result, err := increment(prior, delta, now)
+	if err != nil {
+		s.Unlock()
+		log.Warn("increment rejected", slog.Any("error", err))
+		return 0, 0, err
+	}
+
+	incr := transaction.Event{
+		EventType: transaction.EventIncr,
+		Key:       key,
+		Value:     entry.Value,
+		ExpiresAt: entry.ExpiresAt,
+		Metadata:  stamp(entry.Metadata, prior, now),
+	}
+	unit, err := s.makeRoom(change(incr))
+	var wait func() error
+	if err == nil {
+		unit = append(unit, incr)
+		wait, err = s.commit(ctx, unit...)
+	}
+	s.Unlock()
+	if err != nil {
+		log.Error("storage write failed", slog.Any("error", err))
+		return 0, 0, err
+	}
+
+	if err := wait(); err != nil {
+		log.Error("journal write failed, rollback", slog.Any("error", err))
+		return 0, 0, fmt.Errorf("failed to log increment operation: %w", err)
+	}
+
+	version := unit[len(unit)-1].Sequence
+	log.Info("increment succeeded", slog.Int64("delta", delta), slog.Int64("value", result), slog.Uint64("version", version))
+	return result, version, nil
+}
+
+// increment returns the entry holding the value of prior plus delta with the
+// deadline and metadata of prior, an absent or expired prior counts as zero
+func increment(prior storage.Change, delta int64, now time.Time) (storage.Entry, int64, error) {
+	var (
+		base  int64
+		entry storage.Entry
+	)
+	if !prior.Delete && !prior.Entry.Expired(now) {
+		var err error
+		if base, err = strconv.ParseInt(prior.Entry.Value, 10, 64); err != nil {
+			return storage.Entry{}, 0, ErrNotInteger
+		}
+		entry.ExpiresAt = prior.Entry.ExpiresAt
+		entry.Metadata = prior.Entry.Metadata
+	}
+
+	if (delta > 0 && base > math.MaxInt64-delta) || (delta < 0 && base < math.MinInt64-delta) {
+		return storage.Entry{}, 0, ErrOverflow
+	}
+	entry.Value = strconv.FormatInt(base+delta, 10)
+	return entry, base + delta, nil
+}
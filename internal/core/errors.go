@@ -0,0 +1,43 @@
+package core
+
+import "errors"
+
+// Code classifies an error for clients, every API maps it to a status of its own
+type Code string
+
+const (
+	CodeInternal            Code = "internal"
+	CodeInvalidArgument     Code = "invalid_argument"
+	CodeNotFound            Code = "not_found"
+	CodePreconditionFailed  Code = "precondition_failed"
+	CodeReadOnly            Code = "read_only"
+	CodeUnavailable         Code = "unavailable"
+	CodeInsufficientStorage Code = "insufficient_storage"
+	CodeTooLarge            Code = "too_large"
+	CodeGone                Code = "gone"
+)
+
+// Error is an error of a known class, the sentinel errors of the store are
+// Errors so wrapping them keeps the class
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(code Code, message string) error {
+	return &Error{Code: code, Message: message}
+}
+
+// CodeOf returns the code of the first Error wrapped by err, CodeInternal if
+// there is none
+func CodeOf(err error) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return CodeInternal
+}
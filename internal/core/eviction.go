@@ -0,0 +1,377 @@
+package core
+
+import (
+	"cloud/internal/storage"
+	"container/heap"
+	"container/list"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	EvictionLRU    = "lru"
+	EvictionLFU    = "lfu"
+	EvictionRandom = "random"
+	EvictionNone   = "noeviction"
+)
+
+var (
+	// ErrInsufficientStorage is returned by writes that do not fit the limits
+	// and cannot be made to fit by evicting other keys
+	ErrInsufficientStorage = newError(CodeInsufficientStorage, "store is full")
+	ErrUnknownPolicy       = errors.New("unknown eviction policy")
+)
+
+// EvictionPolicy picks the keys to evict once the store is full. It is not
+// safe for concurrent use, the store serializes every call.
+type EvictionPolicy interface {
+	// Added is called when key is stored for the first time
+	Added(key string)
+	// Accessed is called when key is read or overwritten
+	Accessed(key string)
+	Removed(key string)
+	// Victim returns the key to evict next, skipping the keys skip reports
+	Victim(skip func(key string) bool) (string, bool)
+}
+
+// NewEvictionPolicy returns the policy registered under name
+func NewEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch name {
+	case EvictionLRU:
+		return newLRUPolicy(), nil
+	case EvictionLFU:
+		return newLFUPolicy(), nil
+	case EvictionRandom:
+		return newRandomPolicy(), nil
+	case EvictionNone, "":
+		return noEviction{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPolicy, name)
+	}
+}
+
+// Limits bound the store, zero MaxBytes or MaxKeys leaves that dimension unbounded
+type Limits struct {
+	MaxBytes int64
+	MaxKeys  int
+	Policy   EvictionPolicy
+	// JournalEvictions records evicted keys as deletes, so they stay gone after
+	// a restart and on replicas
+	JournalEvictions bool
+}
+
+// Usage reports how much of the limits the store takes
+type Usage struct {
+	Keys      int
+	Bytes     int64
+	MaxKeys   int
+	MaxBytes  int64
+	Evictions uint64
+}
+
+// bounds does the size accounting of a limited store. Reads touch the policy
+// under the store read lock, so it has a lock of its own.
+type bounds struct {
+	limits    Limits
+	mu        sync.Mutex
+	sizes     map[string]int64
+	bytes     int64
+	evictions uint64 // atomic
+}
+
+func newBounds(limits Limits) *bounds {
+	if limits.Policy == nil {
+		limits.Policy = noEviction{}
+	}
+	return &bounds{limits: limits, sizes: make(map[string]int64)}
+}
+
+// entrySize is what an entry counts against MaxBytes
+func entrySize(key, value string) int64 {
+	return int64(len(key) + len(value))
+}
+
+func (b *bounds) stored(key string, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old, ok := b.sizes[key]
+	if ok {
+		b.limits.Policy.Accessed(key)
+	} else {
+		b.limits.Policy.Added(key)
+	}
+	b.sizes[key] = size
+	b.bytes += size - old
+}
+
+func (b *bounds) removed(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old, ok := b.sizes[key]
+	if !ok {
+		return
+	}
+	b.limits.Policy.Removed(key)
+	delete(b.sizes, key)
+	b.bytes -= old
+}
+
+func (b *bounds) accessed(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.sizes[key]; ok {
+		b.limits.Policy.Accessed(key)
+	}
+}
+
+// fits reports whether the limits hold once changes are applied and, if not,
+// whether evicting other keys can make them hold
+func (b *bounds) fits(changes []storage.Change) (fits, possible bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// pending holds the size each touched key ends up with, -1 once deleted
+	pending := make(map[string]int64, len(changes))
+	for _, c := range changes {
+		if c.Delete {
+			pending[c.Key] = -1
+			continue
+		}
+		pending[c.Key] = entrySize(c.Key, c.Entry.Value)
+	}
+
+	keys, bytes := int64(len(b.sizes)), b.bytes
+	var ownKeys, ownBytes int64
+	for key, size := range pending {
+		if old, ok := b.sizes[key]; ok {
+			keys--
+			bytes -= old
+		}
+		if size >= 0 {
+			ownKeys++
+			ownBytes += size
+		}
+	}
+
+	within := func(keys, bytes int64) bool {
+		return (b.limits.MaxKeys <= 0 || keys <= int64(b.limits.MaxKeys)) &&
+			(b.limits.MaxBytes <= 0 || bytes <= b.limits.MaxBytes)
+	}
+	return within(keys+ownKeys, bytes+ownBytes), within(ownKeys, ownBytes)
+}
+
+func (b *bounds) victim(skip func(key string) bool) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.limits.Policy.Victim(skip)
+}
+
+func (b *bounds) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key := range b.sizes {
+		b.limits.Policy.Removed(key)
+	}
+	b.sizes = make(map[string]int64)
+	b.bytes = 0
+}
+
+func (b *bounds) usage() Usage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return Usage{
+		Keys:      len(b.sizes),
+		Bytes:     b.bytes,
+		MaxKeys:   b.limits.MaxKeys,
+		MaxBytes:  b.limits.MaxBytes,
+		Evictions: atomic.LoadUint64(&b.evictions),
+	}
+}
+
+// noEviction never frees room, writes beyond the limits fail
+type noEviction struct{}
+
+func (noEviction) Added(string)                                {}
+func (noEviction) Accessed(string)                             {}
+func (noEviction) Removed(string)                              {}
+func (noEviction) Victim(func(key string) bool) (string, bool) { return "", false }
+
+// lruPolicy evicts the key that was used least recently
+type lruPolicy struct {
+	order *list.List // front is the most recently used key
+	elems map[string]*list.Element
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{order: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (p *lruPolicy) Added(key string) {
+	p.elems[key] = p.order.PushFront(key)
+}
+
+func (p *lruPolicy) Accessed(key string) {
+	if e, ok := p.elems[key]; ok {
+		p.order.MoveToFront(e)
+	}
+}
+
+func (p *lruPolicy) Removed(key string) {
+	if e, ok := p.elems[key]; ok {
+		p.order.Remove(e)
+		delete(p.elems, key)
+	}
+}
+
+func (p *lruPolicy) Victim(skip func(key string) bool) (string, bool) {
+	for e := p.order.Back(); e != nil; e = e.Prev() {
+		if key := e.Value.(string); !skip(key) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// lfuPolicy evicts the key that was used least often, the older one on a tie
+type lfuPolicy struct {
+	entries lfuHeap
+	byKey   map[string]*lfuEntry
+	tick    uint64
+}
+
+type lfuEntry struct {
+	key   string
+	hits  uint64
+	tick  uint64 // last use, breaks ties between equal hits
+	index int
+}
+
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{byKey: make(map[string]*lfuEntry)}
+}
+
+func (p *lfuPolicy) Added(key string) {
+	p.tick++
+	e := &lfuEntry{key: key, hits: 1, tick: p.tick}
+	p.byKey[key] = e
+	heap.Push(&p.entries, e)
+}
+
+func (p *lfuPolicy) Accessed(key string) {
+	e, ok := p.byKey[key]
+	if !ok {
+		return
+	}
+	p.tick++
+	e.hits++
+	e.tick = p.tick
+	heap.Fix(&p.entries, e.index)
+}
+
+func (p *lfuPolicy) Removed(key string) {
+	if e, ok := p.byKey[key]; ok {
+		heap.Remove(&p.entries, e.index)
+		delete(p.byKey, key)
+	}
+}
+
+func (p *lfuPolicy) Victim(skip func(key string) bool) (string, bool) {
+	if len(p.entries) > 0 && !skip(p.entries[0].key) {
+		return p.entries[0].key, true
+	}
+
+	// the least used key is being written, fall back to a scan
+	var victim *lfuEntry
+	for _, e := range p.entries {
+		if !skip(e.key) && (victim == nil || p.entries.less(e, victim)) {
+			victim = e
+		}
+	}
+	if victim == nil {
+		return "", false
+	}
+	return victim.key, true
+}
+
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) less(a, b *lfuEntry) bool {
+	if a.hits != b.hits {
+		return a.hits < b.hits
+	}
+	return a.tick < b.tick
+}
+
+func (h lfuHeap) Len() int           { return len(h) }
+func (h lfuHeap) Less(i, j int) bool { return h.less(h[i], h[j]) }
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x any) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// randomPolicy evicts a key picked uniformly at random
+type randomPolicy struct {
+	keys  []string
+	index map[string]int
+}
+
+func newRandomPolicy() *randomPolicy {
+	return &randomPolicy{index: make(map[string]int)}
+}
+
+func (p *randomPolicy) Added(key string) {
+	p.index[key] = len(p.keys)
+	p.keys = append(p.keys, key)
+}
+
+func (p *randomPolicy) Accessed(string) {}
+
+func (p *randomPolicy) Removed(key string) {
+	i, ok := p.index[key]
+	if !ok {
+		return
+	}
+	last := p.keys[len(p.keys)-1]
+	p.keys[i] = last
+	p.index[last] = i
+	p.keys = p.keys[:len(p.keys)-1]
+	delete(p.index, key)
+}
+
+func (p *randomPolicy) Victim(skip func(key string) bool) (string, bool) {
+	if len(p.keys) == 0 {
+		return "", false
+	}
+
+	start := rand.IntN(len(p.keys))
+	for i := range p.keys {
+		if key := p.keys[(start+i)%len(p.keys)]; !skip(key) {
+			return key, true
+		}
+	}
+	return "", false
+}
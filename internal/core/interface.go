@@ -1,9 +1,52 @@
 package core
 
-import "context"
+import (
+	"cloud/internal/transaction"
+	"context"
+	"time"
+)
 
 type Store interface {
 	Put(ctx context.Context, key, value string) error
+	// PutWithTTL stores the pair and expires it after ttl
+	PutWithTTL(ctx context.Context, key, value string, ttl time.Duration) error
 	Get(ctx context.Context, key string) (string, error)
+	// Write stores the pair with the expiry, metadata and precondition of opts
+	// and returns its new version, Put and the other writes of a pair wrap it
+	Write(ctx context.Context, key string, value Value, opts WriteOptions) (uint64, error)
+	// Lookup returns the value of the key with its metadata, version and deadline
+	Lookup(ctx context.Context, key string) (Item, error)
+	// TTL returns the remaining time to live of the key, zero if the key never expires
+	TTL(ctx context.Context, key string) (time.Duration, error)
 	Delete(ctx context.Context, key string) error
+
+	// Version returns the current version of the key, used as its ETag
+	Version(ctx context.Context, key string) (uint64, error)
+	// CompareAndSwap stores value only if the key is at expected (zero: absent) and returns the new version
+	CompareAndSwap(ctx context.Context, key, value string, expected uint64) (uint64, error)
+	// CompareAndDelete removes the key only if it is at expected
+	CompareAndDelete(ctx context.Context, key string, expected uint64) error
+
+	// Incr atomically adds delta to the integer value of the key, a missing key
+	// counts from zero. It returns the result and the version it was written at.
+	Incr(ctx context.Context, key string, delta int64) (value int64, version uint64, err error)
+
+	// Batch applies all ops atomically or none of them
+	Batch(ctx context.Context, ops []Op) error
+
+	// List returns a page of keys in ascending order
+	List(ctx context.Context, opts ListOptions) (ListResult, error)
+
+	// Watch streams journaled changes, optionally replaying from a sequence first
+	Watch(ctx context.Context, opts WatchOptions) (<-chan WatchEvent, error)
+
+	// Namespace returns the store of another namespace, its keys are apart
+	// from the keys of every other namespace
+	Namespace(name string) (Store, error)
+	// DropNamespace removes the namespace with all its keys
+	DropNamespace(ctx context.Context, name string) error
+
+	// JournalHealth reports whether the journal accepts writes, writes fail
+	// with ErrJournalUnavailable while it does not
+	JournalHealth() transaction.Health
 }
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"cloud/internal/storage"
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ListOptions selects a page of keys in ascending order
+type ListOptions struct {
+	Prefix string // only keys starting with Prefix
+	Start  string // first key to consider, inclusive
+	After  string // continue after this key, taken from ListResult.Next
+	Limit  int    // page size, must be positive
+}
+
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+type ListResult struct {
+	Items []KeyValue
+	Next  string // last key of the page if more keys follow, empty otherwise
+}
+
+// List returns up to opts.Limit live keys with their values in key order
+func (s *inMemoryStore) List(ctx context.Context, opts ListOptions) (_ ListResult, err error) {
+	const op = "inMemoryStore.List"
+
+	_, span := s.startSpan(ctx, op, opts.Prefix)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	if opts.Limit <= 0 {
+		log.Error("invalid limit", slog.Int("limit", opts.Limit))
+		return ListResult{}, ErrInvalidLimit
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	var (
+		res  ListResult
+		more bool
+		now  = time.Now()
+	)
+
+	ks, err := s.space()
+	if err != nil {
+		log.Error("storage read failed", slog.Any("error", err))
+		return ListResult{}, err
+	}
+
+	err = ks.engine.Ascend(opts.Prefix, opts.Start, opts.After, func(key string, entry storage.Entry) bool {
+		if entry.Expired(now) {
+			return true
+		}
+		if len(res.Items) == opts.Limit {
+			more = true
+			return false
+		}
+		res.Items = append(res.Items, KeyValue{Key: key, Value: entry.Value})
+		return true
+	})
+	if err != nil {
+		log.Error("storage read failed", slog.Any("error", err))
+		return ListResult{}, fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	if more {
+		res.Next = res.Items[len(res.Items)-1].Key
+	}
+
+	log.Info("list succeeded", slog.Int("count", len(res.Items)))
+	return res, nil
+}
@@ -0,0 +1,54 @@
+package core
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	keysDesc = prometheus.NewDesc("kvstore_store_keys",
+		"Keys stored by namespace, expired keys not reaped yet included.",
+		[]string{"namespace"}, nil)
+	bytesDesc = prometheus.NewDesc("kvstore_store_bytes",
+		"Size of the stored keys and values by namespace.",
+		[]string{"namespace"}, nil)
+	maxKeysDesc = prometheus.NewDesc("kvstore_store_max_keys",
+		"Key limit of the namespace, unset while it has none.",
+		[]string{"namespace"}, nil)
+	maxBytesDesc = prometheus.NewDesc("kvstore_store_max_bytes",
+		"Byte limit of the namespace, unset while it has none.",
+		[]string{"namespace"}, nil)
+	evictionsDesc = prometheus.NewDesc("kvstore_store_evictions_total",
+		"Keys evicted to make room by namespace.",
+		[]string{"namespace"}, nil)
+)
+
+// usageCollector exports the usage of every opened namespace on each scrape
+type usageCollector struct {
+	store *inMemoryStore
+}
+
+// Collector returns the prometheus collector of the store size, the default
+// namespace is labelled with the empty name
+func (s *inMemoryStore) Collector() prometheus.Collector {
+	return usageCollector{store: s}
+}
+
+func (c usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- keysDesc
+	ch <- bytesDesc
+	ch <- maxKeysDesc
+	ch <- maxBytesDesc
+	ch <- evictionsDesc
+}
+
+func (c usageCollector) Collect(ch chan<- prometheus.Metric) {
+	for namespace, u := range c.store.Usages() {
+		ch <- prometheus.MustNewConstMetric(keysDesc, prometheus.GaugeValue, float64(u.Keys), namespace)
+		ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.GaugeValue, float64(u.Bytes), namespace)
+		ch <- prometheus.MustNewConstMetric(evictionsDesc, prometheus.CounterValue, float64(u.Evictions), namespace)
+		if u.MaxKeys > 0 {
+			ch <- prometheus.MustNewConstMetric(maxKeysDesc, prometheus.GaugeValue, float64(u.MaxKeys), namespace)
+		}
+		if u.MaxBytes > 0 {
+			ch <- prometheus.MustNewConstMetric(maxBytesDesc, prometheus.GaugeValue, float64(u.MaxBytes), namespace)
+		}
+	}
+}
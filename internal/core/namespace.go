@@ -0,0 +1,259 @@
+package core
+
+import (
+	"cloud/internal/storage"
+	"cloud/internal/transaction"
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sync/atomic"
+)
+
+// ErrInvalidNamespace is returned for names outside [A-Za-z0-9_.-]{1,64} and
+// when dropping the default namespace
+var ErrInvalidNamespace = newError(CodeInvalidArgument, "invalid namespace")
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// keyspace is the storage of one namespace
+type keyspace struct {
+	engine storage.Engine
+	bounds *bounds // nil while the namespace is unlimited
+	bytes  int64   // size of the entries as counted by entrySize, atomic
+}
+
+// usage reports the size of the keyspace and, if it is bounded, its limits
+func (ks *keyspace) usage() Usage {
+	u := Usage{Keys: ks.engine.Len(), Bytes: atomic.LoadInt64(&ks.bytes)}
+	if ks.bounds != nil {
+		b := ks.bounds.usage()
+		u.MaxKeys, u.MaxBytes, u.Evictions = b.MaxKeys, b.MaxBytes, b.Evictions
+	}
+	return u
+}
+
+func validNamespace(name string) error {
+	if name != storage.DefaultNamespace && !namespacePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidNamespace, name)
+	}
+	return nil
+}
+
+// Namespace returns the store of the named namespace, the empty name is the
+// default one. A namespace springs into existence with its first write.
+func (s *inMemoryStore) Namespace(name string) (Store, error) {
+	if err := validNamespace(name); err != nil {
+		return nil, err
+	}
+	return s.in(name), nil
+}
+
+// in returns the store of the namespace without validating its name
+func (s *inMemoryStore) in(namespace string) *inMemoryStore {
+	return &inMemoryStore{shared: s.shared, namespace: namespace}
+}
+
+// space returns the storage of the namespace, opening it on first use
+func (s *inMemoryStore) space() (*keyspace, error) {
+	s.spacesMu.Lock()
+	defer s.spacesMu.Unlock()
+
+	if ks, ok := s.keyspaces[s.namespace]; ok {
+		return ks, nil
+	}
+
+	engine, err := s.spaces.Open(s.namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open namespace: %w", err)
+	}
+	ks := &keyspace{engine: engine}
+	if ks.bytes, err = sizeOf(engine); err != nil {
+		return nil, err
+	}
+	if limits, ok := s.quotas[s.namespace]; ok {
+		if ks.bounds, err = measure(engine, limits); err != nil {
+			return nil, err
+		}
+	}
+	s.keyspaces[s.namespace] = ks
+	return ks, nil
+}
+
+// opened returns the stores of every opened namespace in name order
+func (s *inMemoryStore) opened() []*inMemoryStore {
+	s.spacesMu.Lock()
+	defer s.spacesMu.Unlock()
+
+	names := make([]string, 0, len(s.keyspaces))
+	for name := range s.keyspaces {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	stores := make([]*inMemoryStore, len(names))
+	for i, name := range names {
+		stores[i] = s.in(name)
+	}
+	return stores
+}
+
+// sizeOf adds up the entries already stored in engine
+func sizeOf(engine storage.Engine) (int64, error) {
+	var size int64
+	if engine.Len() == 0 {
+		return 0, nil
+	}
+	err := engine.Ascend("", "", "", func(key string, entry storage.Entry) bool {
+		size += entrySize(key, entry.Value)
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read entries: %w", err)
+	}
+	return size, nil
+}
+
+// Usages reports the size of every opened namespace by name
+func (s *inMemoryStore) Usages() map[string]Usage {
+	s.RLock()
+	defer s.RUnlock()
+	s.spacesMu.Lock()
+	defer s.spacesMu.Unlock()
+
+	usages := make(map[string]Usage, len(s.keyspaces))
+	for name, ks := range s.keyspaces {
+		usages[name] = ks.usage()
+	}
+	return usages
+}
+
+// measure bounds engine by limits, entries already stored count against them
+func measure(engine storage.Engine, limits Limits) (*bounds, error) {
+	b := newBounds(limits)
+	err := engine.Ascend("", "", "", func(key string, entry storage.Entry) bool {
+		b.stored(key, entrySize(key, entry.Value))
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read entries: %w", err)
+	}
+	return b, nil
+}
+
+// SetQuota bounds the namespace, keys already stored count against the limits.
+// The quota outlives a drop of the namespace and applies once it is written again.
+func (s *inMemoryStore) SetQuota(namespace string, limits Limits) error {
+	if err := validNamespace(namespace); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.spacesMu.Lock()
+	defer s.spacesMu.Unlock()
+
+	if ks, ok := s.keyspaces[namespace]; ok {
+		b, err := measure(ks.engine, limits)
+		if err != nil {
+			return err
+		}
+		ks.bounds = b
+	}
+	s.quotas[namespace] = limits
+	return nil
+}
+
+// DropNamespace removes the namespace with all its keys. A drop cannot be
+// rolled back, so unlike other writes it is journaled before it is applied.
+func (s *inMemoryStore) DropNamespace(ctx context.Context, name string) (err error) {
+	const op = "inMemoryStore.DropNamespace"
+
+	ctx, span := s.startSpan(ctx, op, "")
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("namespace", name),
+	)
+
+	if name == storage.DefaultNamespace {
+		log.Error("default namespace cannot be dropped")
+		return fmt.Errorf("%w: the default namespace cannot be dropped", ErrInvalidNamespace)
+	}
+	if err := validNamespace(name); err != nil {
+		log.Error("invalid namespace", slog.Any("error", err))
+		return err
+	}
+
+	if err := s.isWritable(); err != nil {
+		log.Warn("write rejected", slog.Any("error", err))
+		return err
+	}
+
+	defer s.serialize()()
+
+	// the lock is held while journaling so no write to the namespace lands
+	// between the journaled drop and the dropped keys
+	s.Lock()
+	drop := []transaction.Event{{EventType: transaction.EventDrop, Namespace: name}}
+	s.number(drop)
+	wait, err := s.transactor.Append(ctx, drop)
+	if s.applied {
+		// the transactor drops the namespace once it applies the drop
+		s.Unlock()
+	} else {
+		defer s.Unlock()
+	}
+	if err == nil {
+		err = wait()
+	}
+	if err != nil {
+		log.Error("journal write failed", slog.Any("error", err))
+		return fmt.Errorf("failed to log drop operation: %w", s.journalFailure(err))
+	}
+
+	if !s.applied {
+		if err := s.drop(name); err != nil {
+			log.Error("storage write failed", slog.Any("error", err))
+			return err
+		}
+	}
+
+	log.Info("namespace dropped")
+	return nil
+}
+
+// drop must be called with the lock held, the default namespace is only emptied
+func (s *shared) drop(namespace string) error {
+	s.spacesMu.Lock()
+	defer s.spacesMu.Unlock()
+
+	if ks, ok := s.keyspaces[namespace]; ok && ks.bounds != nil {
+		// the quota policy is reused once the namespace is written again
+		ks.bounds.reset()
+	}
+	delete(s.keyspaces, namespace)
+
+	if err := s.spaces.Drop(namespace); err != nil {
+		return fmt.Errorf("failed to drop namespace: %w", err)
+	}
+	return nil
+}
+
+// dropAll must be called with the lock held, it empties every namespace
+func (s *shared) dropAll() error {
+	namespaces, err := s.spaces.Namespaces()
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	for _, namespace := range append(namespaces, storage.DefaultNamespace) {
+		if err := s.drop(namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"cloud/internal/transaction"
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ExportSnapshot captures the state for a replica to bootstrap from, it holds
+// exactly the events up to its sequence, so a replica tails the journal after it
+func (s *inMemoryStore) ExportSnapshot(ctx context.Context) (transaction.Snapshot, error) {
+	return s.capture()
+}
+
+// ApplySnapshot replaces the whole state of every namespace with the snapshot.
+// On failure the state is partial and has to be replaced again.
+func (s *inMemoryStore) ApplySnapshot(snapshot transaction.Snapshot) error {
+	s.Lock()
+	err := s.dropAll()
+	s.sequence = 0
+	s.Unlock()
+
+	if err == nil {
+		err = s.loadSnapshot(snapshot, time.Now())
+	}
+	if err != nil {
+		s.log.Error("failed to apply snapshot", slog.Any("error", err))
+		return fmt.Errorf("apply snapshot: %w", err)
+	}
+	s.log.Info("snapshot applied",
+		slog.Uint64("sequence", snapshot.Sequence),
+		slog.Int("keys", len(snapshot.Entries)))
+	return nil
+}
+
+// Apply replays a journal event produced elsewhere through the restore path,
+// it bypasses read-only mode and is not journaled again
+func (s *inMemoryStore) Apply(event transaction.Event) error {
+	return s.applyEvent(event, time.Now())
+}
+
+// LastSequence returns the sequence of the last journaled event
+func (s *inMemoryStore) LastSequence() (uint64, error) {
+	return s.transactor.LastSequence()
+}
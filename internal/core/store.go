@@ -1,32 +1,78 @@
 package core
 
 import (
+	"cloud/internal/storage"
 	"cloud/internal/transaction"
 	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
-	ErrKeyNotFound = errors.New("key not found")
-	ErrEmptyKey    = errors.New("key is empty")
+	ErrKeyNotFound  = newError(CodeNotFound, "key not found")
+	ErrEmptyKey     = newError(CodeInvalidArgument, "key is empty")
+	ErrInvalidTTL   = newError(CodeInvalidArgument, "ttl must be positive")
+	ErrInvalidLimit = newError(CodeInvalidArgument, "limit must be positive")
+	ErrReadOnly     = newError(CodeReadOnly, "store is read-only")
+	// ErrJournalUnavailable is returned by writes while the journal cannot persist them
+	ErrJournalUnavailable = newError(CodeUnavailable, "journal is unavailable")
+	// ErrVersionMismatch is returned when a conditional write finds another version of the key
+	ErrVersionMismatch = newError(CodePreconditionFailed, "version mismatch")
 )
 
+// inMemoryStore serves the keys of one namespace, the stores of all
+// namespaces share the journal, the versions and the lock
 type inMemoryStore struct {
-	m          map[string]string
+	*shared
+	namespace string
+}
+
+type shared struct {
+	spaces     storage.Keyspaces
+	keyspaces  map[string]*keyspace // opened namespaces, guarded by spacesMu
+	quotas     map[string]Limits    // guarded by spacesMu
+	spacesMu   sync.Mutex
+	readOnly   uint32 // 1 rejects writes, e.g. on a replication follower
+	sequence   uint64 // last assigned journal sequence, versions are taken from it
 	log        *slog.Logger
 	transactor transaction.Transactor
+
+	// size limits of single keys and values, zero is unlimited
+	maxKeySize   int
+	maxValueSize int64
 	sync.RWMutex
+
+	// applied is set if the transactor applies committed writes itself,
+	// serial then holds every write until it is applied
+	applied bool
+	serial  sync.Mutex
 }
 
+// NewStore keeps the entries in memory, they are rebuilt from the journal
 func NewStore(transactor transaction.Transactor, logger *slog.Logger) (*inMemoryStore, error) {
+	return NewStoreWithKeyspaces(storage.NewMapKeyspaces(), transactor, logger)
+}
+
+// NewStoreWithKeyspaces keeps the entries of every namespace in spaces, a
+// persistent engine that already holds them is not rebuilt from the journal.
+// The returned store serves the default namespace.
+func NewStoreWithKeyspaces(spaces storage.Keyspaces, transactor transaction.Transactor, logger *slog.Logger) (*inMemoryStore, error) {
 	st := &inMemoryStore{
-		m:          make(map[string]string),
-		log:        logger,
-		transactor: transactor,
+		shared: &shared{
+			spaces:     spaces,
+			keyspaces:  make(map[string]*keyspace),
+			quotas:     make(map[string]Limits),
+			log:        logger,
+			transactor: transactor,
+		},
+		namespace: storage.DefaultNamespace,
 	}
+	_, st.applied = transactor.(transaction.Applier)
 
 	if err := st.restoreState(); err != nil {
 		st.log.Error("failed to restore state", slog.Any("err", err))
@@ -45,8 +91,98 @@ func (s *inMemoryStore) isKeyValid(key string) error {
 	return nil
 }
 
+func (s *inMemoryStore) isWritable() error {
+	if atomic.LoadUint32(&s.readOnly) == 1 {
+		return ErrReadOnly
+	}
+	if health := s.transactor.Health(); !health.Healthy {
+		return fmt.Errorf("%w: %v", ErrJournalUnavailable, health.LastError)
+	}
+	return nil
+}
+
+// journalFailure marks a failed journal write with ErrJournalUnavailable once
+// the journal is degraded, the store then stays read-only until it recovers
+func (s *inMemoryStore) journalFailure(err error) error {
+	if errors.Is(err, transaction.ErrJournalDegraded) || !s.transactor.Health().Healthy {
+		return fmt.Errorf("%w: %w", ErrJournalUnavailable, err)
+	}
+	return err
+}
+
+// JournalHealth reports whether the transactor accepts writes
+func (s *inMemoryStore) JournalHealth() transaction.Health {
+	return s.transactor.Health()
+}
+
+// SetLimits bounds the namespace of the store, keys already stored count
+// against the limits. It must be called before the store serves requests.
+func (s *inMemoryStore) SetLimits(limits Limits) error {
+	return s.SetQuota(s.namespace, limits)
+}
+
+// Usage reports the size of the namespace against its limits
+func (s *inMemoryStore) Usage() Usage {
+	s.RLock()
+	defer s.RUnlock()
+
+	ks, err := s.space()
+	if err != nil {
+		s.log.Error("failed to open namespace", slog.Any("error", err))
+		return Usage{}
+	}
+	return ks.usage()
+}
+
+// SetReadOnly makes every write fail with ErrReadOnly while reads keep working
+func (s *inMemoryStore) SetReadOnly(readOnly bool) {
+	var v uint32
+	if readOnly {
+		v = 1
+	}
+	atomic.StoreUint32(&s.readOnly, v)
+}
+
+// WriteOptions qualify a write, the zero value stores the value without expiry
+// whatever the version of the key
+type WriteOptions struct {
+	TTL      time.Duration        // zero means no expiry
+	Metadata transaction.Metadata // content type and headers, the store sets the timestamps
+	// Expected makes the write conditional on the current version of the key,
+	// pointing to zero requires the key to be absent
+	Expected *uint64
+}
+
 func (s *inMemoryStore) Put(ctx context.Context, key string, value string) error {
-	const op = "inMemoryStore.Put"
+	_, err := s.Write(ctx, key, valueOf(value), WriteOptions{})
+	return err
+}
+
+// PutWithTTL stores the pair and expires it after ttl
+func (s *inMemoryStore) PutWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	_, err := s.Write(ctx, key, valueOf(value), WriteOptions{TTL: ttl})
+	return err
+}
+
+// CompareAndSwap stores value only if the key is at the expected version,
+// expected zero requires the key to be absent. It returns the new version.
+func (s *inMemoryStore) CompareAndSwap(ctx context.Context, key, value string, expected uint64) (uint64, error) {
+	return s.Write(ctx, key, valueOf(value), WriteOptions{Expected: &expected})
+}
+
+// Write stores the pair as opts ask and returns its new version. The
+// timestamps of the metadata are set by the store: the creation time is kept
+// while the key lives, the update time is the time of the write.
+func (s *inMemoryStore) Write(ctx context.Context, key string, value Value, opts WriteOptions) (_ uint64, err error) {
+	const op = "inMemoryStore.Write"
+
+	ctx, span := s.startSpan(ctx, op, key)
+	defer func() {
+		endSpan(span, err)
+	}()
 
 	log := s.log.With(
 		slog.String("op", op),
@@ -54,26 +190,87 @@ func (s *inMemoryStore) Put(ctx context.Context, key string, value string) error
 
 	if err := s.isKeyValid(key); err != nil {
 		log.Error("empty key", slog.Any("error", ErrEmptyKey))
-		return err
+		return 0, err
 	}
 
-	s.put(key, value) // add pair in lock
+	if err := s.isSizeValid(key, len(value)); err != nil {
+		log.Warn("entry too large", slog.Any("error", err))
+		return 0, err
+	}
+
+	if err := s.isWritable(); err != nil {
+		log.Warn("write rejected", slog.Any("error", err))
+		return 0, err
+	}
+
+	if opts.TTL < 0 {
+		log.Error("invalid ttl", slog.Duration("ttl", opts.TTL))
+		return 0, ErrInvalidTTL
+	}
 
-	err := s.transactor.WritePut(context.TODO(), key, value)
+	var expiresAt time.Time
+	if opts.TTL > 0 {
+		expiresAt = time.Now().Add(opts.TTL)
+	}
+	return s.compareAndSwap(ctx, log, key, value.String(), opts.Expected, expiresAt, opts.Metadata)
+}
+
+// compareAndSwap checks the version and stores the pair in one critical section,
+// nil expected skips the check and zero expiresAt clears the deadline
+func (s *inMemoryStore) compareAndSwap(ctx context.Context, log *slog.Logger, key, value string, expected *uint64, expiresAt time.Time, meta transaction.Metadata) (uint64, error) {
+	defer s.serialize()()
+
+	now := time.Now()
+
+	s.Lock()
+	prior, current, err := s.priorVersion(key, now)
 	if err != nil {
-		s.delete(key)
-		log.Error("journal write failed, rollback", slog.Any("error", err))
-		return fmt.Errorf("failed to log put operation: %w", err)
+		s.Unlock()
+		log.Error("storage read failed", slog.Any("error", err))
+		return 0, err
+	}
+	if expected != nil && current != *expected {
+		s.Unlock()
+		log.Warn("version mismatch", slog.Uint64("expected", *expected), slog.Uint64("current", current))
+		return 0, ErrVersionMismatch
+	}
+	put := transaction.Event{
+		EventType: transaction.EventPut,
+		Key:       key,
+		Value:     value,
+		ExpiresAt: expiresAt,
+		Metadata:  stamp(meta, prior, now),
+	}
+	unit, err := s.makeRoom(change(put))
+	var wait func() error
+	if err == nil {
+		unit = append(unit, put)
+		wait, err = s.commit(ctx, unit...)
+	}
+	s.Unlock()
+	if err != nil {
+		log.Error("storage write failed", slog.Any("error", err))
+		return 0, err
 	}
 
-	log.Info("put succeeded")
-	return nil
+	if err := wait(); err != nil {
+		log.Error("journal write failed, rollback", slog.Any("error", err))
+		return 0, fmt.Errorf("failed to log put operation: %w", err)
+	}
 
+	version := unit[len(unit)-1].Sequence
+	log.Info("write succeeded", slog.Uint64("version", version))
+	return version, nil
 }
 
-func (s *inMemoryStore) Delete(ctx context.Context, key string) error {
+func (s *inMemoryStore) Delete(ctx context.Context, key string) (err error) {
 	const op = "inMemoryStore.Delete"
 
+	ctx, span := s.startSpan(ctx, op, key)
+	defer func() {
+		endSpan(span, err)
+	}()
+
 	log := s.log.With(
 		slog.String("op", op),
 	)
@@ -83,11 +280,22 @@ func (s *inMemoryStore) Delete(ctx context.Context, key string) error {
 		return err
 	}
 
-	s.delete(key) // delete pair in lock
+	if err := s.isWritable(); err != nil {
+		log.Warn("write rejected", slog.Any("error", err))
+		return err
+	}
+
+	defer s.serialize()()
 
-	err := s.transactor.WriteDelete(context.TODO(), key)
+	s.Lock()
+	wait, err := s.commit(ctx, transaction.Event{EventType: transaction.EventDelete, Key: key})
+	s.Unlock()
 	if err != nil {
-		s.delete(key)
+		log.Error("storage write failed", slog.Any("error", err))
+		return err
+	}
+
+	if err := wait(); err != nil {
 		log.Error("journal write failed, rollback", slog.Any("error", err))
 		return fmt.Errorf("failed to log delete operation: %w", err)
 	}
@@ -96,9 +304,14 @@ func (s *inMemoryStore) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
-func (s *inMemoryStore) Get(ctx context.Context, key string) (string, error) {
+func (s *inMemoryStore) Get(ctx context.Context, key string) (_ string, err error) {
 	const op = "inMemoryStore.Get"
 
+	_, span := s.startSpan(ctx, op, key)
+	defer func() {
+		endSpan(span, err)
+	}()
+
 	log := s.log.With(
 		slog.String("op", op),
 	)
@@ -111,51 +324,755 @@ func (s *inMemoryStore) Get(ctx context.Context, key string) (string, error) {
 	s.RLock()
 	defer s.RUnlock()
 
-	value, ok := s.m[key]
+	entry, ok, err := s.lookup(key, time.Now())
+	if err != nil {
+		log.Error("storage read failed", slog.Any("error", err))
+		return "", err
+	}
 	if !ok {
 		log.Error("wrong key", slog.Any("error", ErrKeyNotFound))
 		return "", ErrKeyNotFound
 	}
 
 	log.Info("get succeeded")
-	return value, nil
+	return entry.Value, nil
+}
+
+// Item is a stored value with what the store keeps about it
+type Item struct {
+	Value     Value // shares the stored bytes, it must not be modified
+	Metadata  transaction.Metadata
+	Version   uint64
+	ExpiresAt time.Time // zero if the key never expires
+}
+
+// Lookup returns the value of the key together with its metadata, version
+// and deadline, read at once so they belong to the same write
+func (s *inMemoryStore) Lookup(ctx context.Context, key string) (_ Item, err error) {
+	const op = "inMemoryStore.Lookup"
+
+	_, span := s.startSpan(ctx, op, key)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	if err := s.isKeyValid(key); err != nil {
+		log.Error("empty key", slog.Any("error", ErrEmptyKey))
+		return Item{}, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	entry, ok, err := s.lookup(key, time.Now())
+	if err != nil {
+		log.Error("storage read failed", slog.Any("error", err))
+		return Item{}, err
+	}
+	if !ok {
+		return Item{}, ErrKeyNotFound
+	}
+
+	return Item{
+		Value:     valueOf(entry.Value),
+		Metadata:  entry.Metadata,
+		Version:   entry.Version,
+		ExpiresAt: entry.ExpiresAt,
+	}, nil
+}
+
+func (s *inMemoryStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	const op = "inMemoryStore.TTL"
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	if err := s.isKeyValid(key); err != nil {
+		log.Error("empty key", slog.Any("error", ErrEmptyKey))
+		return 0, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	now := time.Now()
+	entry, ok, err := s.lookup(key, now)
+	if err != nil {
+		log.Error("storage read failed", slog.Any("error", err))
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+
+	if entry.ExpiresAt.IsZero() {
+		return 0, nil
+	}
+	return entry.ExpiresAt.Sub(now), nil
+}
+
+// Version returns the current version of the key, the journal sequence of the
+// write that stored it. Versions grow monotonically across all keys.
+func (s *inMemoryStore) Version(ctx context.Context, key string) (uint64, error) {
+	if err := s.isKeyValid(key); err != nil {
+		return 0, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	version, err := s.currentVersion(key, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if version == 0 {
+		return 0, ErrKeyNotFound
+	}
+	return version, nil
 }
 
-// put data in lock
-func (s *inMemoryStore) put(key string, value string) {
+// CompareAndDelete removes the key only if it is at the expected version
+func (s *inMemoryStore) CompareAndDelete(ctx context.Context, key string, expected uint64) (err error) {
+	const op = "inMemoryStore.CompareAndDelete"
+
+	ctx, span := s.startSpan(ctx, op, key)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	if err := s.isKeyValid(key); err != nil {
+		log.Error("empty key", slog.Any("error", ErrEmptyKey))
+		return err
+	}
+
+	if err := s.isWritable(); err != nil {
+		log.Warn("write rejected", slog.Any("error", err))
+		return err
+	}
+
+	defer s.serialize()()
+
+	s.Lock()
+	current, err := s.currentVersion(key, time.Now())
+	if err != nil {
+		s.Unlock()
+		log.Error("storage read failed", slog.Any("error", err))
+		return err
+	}
+	if current != expected {
+		s.Unlock()
+		log.Warn("version mismatch", slog.Uint64("expected", expected), slog.Uint64("current", current))
+		return ErrVersionMismatch
+	}
+	wait, err := s.commit(ctx, transaction.Event{EventType: transaction.EventDelete, Key: key})
+	s.Unlock()
+	if err != nil {
+		log.Error("storage write failed", slog.Any("error", err))
+		return err
+	}
+
+	if err := wait(); err != nil {
+		log.Error("journal write failed, rollback", slog.Any("error", err))
+		return fmt.Errorf("failed to log delete operation: %w", err)
+	}
+
+	log.Info("compare and delete succeeded")
+	return nil
+}
+
+// RunReaper evicts expired keys every interval until ctx is done.
+// Every eviction is journaled so restoreState does not bring the key back.
+func (s *inMemoryStore) RunReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.reapExpired(ctx, now)
+		}
+	}
+}
+
+func (s *inMemoryStore) reapExpired(ctx context.Context, now time.Time) {
+	const op = "inMemoryStore.reapExpired"
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	// a read-only replica leaves expiry to whoever journals its writes
+	if s.isWritable() != nil {
+		return
+	}
+
+	defer s.serialize()()
+
+	// the expiries of a namespace are handed to the journal as one unit under
+	// the lock, so a concurrent Put of the same key is always recorded after
+	// them, and waited for once it is released
+	for _, ns := range s.opened() {
+		s.Lock()
+		ks, err := ns.space()
+		if err != nil {
+			s.Unlock()
+			log.Error("storage read failed", slog.String("namespace", ns.namespace), slog.Any("error", err))
+			continue
+		}
+		keys, err := ks.engine.Expired(now)
+		if err != nil || len(keys) == 0 {
+			s.Unlock()
+			if err != nil {
+				log.Error("storage read failed", slog.String("namespace", ns.namespace), slog.Any("error", err))
+			}
+			continue
+		}
+
+		events := make([]transaction.Event, len(keys))
+		for i, key := range keys {
+			events[i] = transaction.Event{EventType: transaction.EventExpire, Key: key}
+		}
+		wait, err := ns.commit(ctx, events...)
+		s.Unlock()
+		if err == nil {
+			err = wait()
+		}
+		if err != nil {
+			log.Error("expiry failed", slog.String("namespace", ns.namespace), slog.Any("error", err))
+			continue
+		}
+		log.Debug("keys expired", slog.String("namespace", ns.namespace), slog.Int("count", len(keys)))
+	}
+}
+
+// lookup must be called with the lock held, expired entries are not found
+func (s *inMemoryStore) lookup(key string, now time.Time) (storage.Entry, bool, error) {
+	ks, err := s.space()
+	if err != nil {
+		return storage.Entry{}, false, err
+	}
+	entry, ok, err := ks.engine.Get(key)
+	if err != nil {
+		return storage.Entry{}, false, fmt.Errorf("failed to read key: %w", err)
+	}
+	if !ok || entry.Expired(now) {
+		return storage.Entry{}, false, nil
+	}
+	if ks.bounds != nil {
+		ks.bounds.accessed(key)
+	}
+	return entry, true, nil
+}
+
+// stamp sets the timestamps of a write replacing prior: the creation time of
+// a live key is kept, a new or expired key is created now
+func stamp(meta transaction.Metadata, prior storage.Change, now time.Time) transaction.Metadata {
+	meta.CreatedAt = now
+	if !prior.Delete && !prior.Entry.Expired(now) && !prior.Entry.Metadata.CreatedAt.IsZero() {
+		meta.CreatedAt = prior.Entry.Metadata.CreatedAt
+	}
+	meta.UpdatedAt = now
+	return meta
+}
+
+// serialize holds the writes of a store whose transactor applies them, each
+// one is checked against the state only once the previous one is applied. It
+// returns the function releasing the write.
+func (s *inMemoryStore) serialize() func() {
+	if !s.applied {
+		return func() {}
+	}
+	s.serial.Lock()
+	return s.serial.Unlock
+}
+
+// number must be called with the lock held, it gives the events the next
+// journal sequences, which are also the versions of the pairs they store. A
+// transactor applying the writes advances the sequence as it applies them.
+func (s *inMemoryStore) number(events []transaction.Event) {
+	next := s.sequence
+	for i := range events {
+		next++
+		events[i].Sequence = next
+	}
+	if !s.applied {
+		s.sequence = next
+	}
+}
+
+// commit must be called with the lock held. It numbers the events, applies
+// them to the namespace and hands them to the journal as one unit. The wait
+// blocks until the unit is durable and rolls the namespace back if it is not.
+// A transactor applying the writes only gets the events, the wait then
+// returns once it applied them.
+func (s *inMemoryStore) commit(ctx context.Context, events ...transaction.Event) (func() error, error) {
+	s.number(events)
+
+	var (
+		changes = make([]storage.Change, len(events))
+		prior   = make([]storage.Change, 0, len(events))
+		seen    = make(map[string]struct{}, len(events))
+	)
+	for i := range events {
+		events[i].Namespace = s.namespace
+		changes[i] = change(events[i])
+		if _, ok := seen[events[i].Key]; ok || s.applied {
+			continue
+		}
+		seen[events[i].Key] = struct{}{}
+		p, err := s.prior(events[i].Key)
+		if err != nil {
+			return nil, err
+		}
+		prior = append(prior, p)
+	}
+
+	if s.applied {
+		wait, err := s.transactor.Append(ctx, events)
+		if err != nil {
+			return func() error { return s.journalFailure(err) }, nil
+		}
+		return func() error {
+			if err := wait(); err != nil {
+				return s.journalFailure(err)
+			}
+			return nil
+		}, nil
+	}
+
+	// the engine applies the whole unit or none of it
+	if err := s.write(changes...); err != nil {
+		return nil, err
+	}
+	wait, err := s.transactor.Append(ctx, events)
+	if err != nil {
+		if err := s.write(prior...); err != nil {
+			s.log.Error("rollback failed", slog.Any("error", err))
+		}
+		return func() error { return s.journalFailure(err) }, nil
+	}
+	return func() error {
+		if err := wait(); err != nil {
+			s.undo(prior...)
+			return s.journalFailure(err)
+		}
+		return nil
+	}, nil
+}
+
+// change returns the storage change a journal event makes
+func change(e transaction.Event) storage.Change {
+	if e.EventType == transaction.EventDelete || e.EventType == transaction.EventExpire {
+		return storage.Change{Key: e.Key, Delete: true}
+	}
+	return storage.Change{
+		Key:   e.Key,
+		Entry: storage.Entry{Value: e.Value, ExpiresAt: e.ExpiresAt, Version: e.Sequence, Metadata: e.Metadata},
+	}
+}
+
+// restore data in lock with the version and metadata taken from the journal
+func (s *inMemoryStore) restore(key string, value string, expiresAt time.Time, version uint64, meta transaction.Metadata) error {
 	s.Lock()
 	defer s.Unlock()
 
-	s.m[key] = value
+	s.sequence = max(s.sequence, version)
+	return s.set(key, value, expiresAt, version, meta)
 }
 
-// delete data in lock
-func (s *inMemoryStore) delete(key string) {
+// set must be called with the lock held
+func (s *inMemoryStore) set(key string, value string, expiresAt time.Time, version uint64, meta transaction.Metadata) error {
+	err := s.write(storage.Change{
+		Key:   key,
+		Entry: storage.Entry{Value: value, ExpiresAt: expiresAt, Version: version, Metadata: meta},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store key: %w", err)
+	}
+	return nil
+}
+
+// write must be called with the lock held, it keeps the size and the limits
+// accounting in step with the engine
+func (s *inMemoryStore) write(changes ...storage.Change) error {
+	ks, err := s.space()
+	if err != nil {
+		return err
+	}
+	delta, err := sizeDelta(ks.engine, changes)
+	if err != nil {
+		return err
+	}
+	if err := ks.engine.Write(changes...); err != nil {
+		return err
+	}
+	atomic.AddInt64(&ks.bytes, delta)
+	if ks.bounds == nil {
+		return nil
+	}
+	for _, c := range changes {
+		if c.Delete {
+			ks.bounds.removed(c.Key)
+			continue
+		}
+		ks.bounds.stored(c.Key, entrySize(c.Key, c.Entry.Value))
+	}
+	return nil
+}
+
+// sizeDelta returns how much the entries grow once changes are applied, a
+// key changed more than once counts with its last change
+func sizeDelta(engine storage.Engine, changes []storage.Change) (int64, error) {
+	final := make(map[string]int64, len(changes))
+	for _, c := range changes {
+		final[c.Key] = 0
+		if !c.Delete {
+			final[c.Key] = entrySize(c.Key, c.Entry.Value)
+		}
+	}
+
+	var delta int64
+	for key, size := range final {
+		old, ok, err := engine.Get(key)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read key: %w", err)
+		}
+		if ok {
+			delta -= entrySize(key, old.Value)
+		}
+		delta += size
+	}
+	return delta, nil
+}
+
+// makeRoom must be called with the lock held, it picks keys other than the
+// written ones to evict until changes fit the limits. The evictions are
+// returned as deletes to commit ahead of the write if they are journaled,
+// otherwise they are applied at once.
+func (s *inMemoryStore) makeRoom(changes ...storage.Change) ([]transaction.Event, error) {
+	ks, err := s.space()
+	if err != nil || ks.bounds == nil {
+		return nil, err
+	}
+
+	fits, possible := ks.bounds.fits(changes)
+	if !possible {
+		return nil, ErrInsufficientStorage
+	}
+
+	picked := make(map[string]struct{}, len(changes))
+	for _, c := range changes {
+		picked[c.Key] = struct{}{}
+	}
+	skip := func(key string) bool {
+		_, ok := picked[key]
+		return ok
+	}
+
+	var evictions []storage.Change
+	for !fits {
+		victim, ok := ks.bounds.victim(skip)
+		if !ok {
+			return nil, ErrInsufficientStorage
+		}
+		picked[victim] = struct{}{}
+		evictions = append(evictions, storage.Change{Key: victim, Delete: true})
+
+		fits, _ = ks.bounds.fits(slices.Concat(evictions, changes))
+	}
+	if len(evictions) == 0 {
+		return nil, nil
+	}
+	atomic.AddUint64(&ks.bounds.evictions, uint64(len(evictions)))
+	s.log.Debug("keys evicted", slog.String("namespace", s.namespace), slog.Int("count", len(evictions)))
+
+	// a transactor applying the writes evicts only what it is handed
+	if !ks.bounds.limits.JournalEvictions && !s.applied {
+		return nil, s.write(evictions...)
+	}
+	events := make([]transaction.Event, len(evictions))
+	for i, c := range evictions {
+		events[i] = transaction.Event{EventType: transaction.EventDelete, Key: c.Key}
+	}
+	return events, nil
+}
+
+// delete data in lock, it returns the change that undoes the delete
+func (s *inMemoryStore) delete(key string) (storage.Change, error) {
 	s.Lock()
 	defer s.Unlock()
 
-	delete(s.m, key)
+	prior, err := s.prior(key)
+	if err != nil {
+		return storage.Change{}, err
+	}
+	return prior, s.remove(key)
+}
+
+// undo restores the entries replaced by a write the journal rejected
+func (s *inMemoryStore) undo(prior ...storage.Change) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.write(prior...); err != nil {
+		s.log.Error("rollback failed", slog.Any("error", err))
+	}
+}
+
+// prior must be called with the lock held, it returns the change that puts
+// the key back into its current state
+func (s *inMemoryStore) prior(key string) (storage.Change, error) {
+	ks, err := s.space()
+	if err != nil {
+		return storage.Change{}, err
+	}
+	entry, ok, err := ks.engine.Get(key)
+	if err != nil {
+		return storage.Change{}, fmt.Errorf("failed to read key: %w", err)
+	}
+	return storage.Change{Key: key, Entry: entry, Delete: !ok}, nil
+}
+
+// priorVersion must be called with the lock held, it returns the prior state
+// and the current version, zero if the key is absent
+func (s *inMemoryStore) priorVersion(key string, now time.Time) (storage.Change, uint64, error) {
+	prior, err := s.prior(key)
+	if err != nil || prior.Delete || prior.Entry.Expired(now) {
+		return prior, 0, err
+	}
+	return prior, prior.Entry.Version, nil
+}
+
+// remove must be called with the lock held
+func (s *inMemoryStore) remove(key string) error {
+	if err := s.write(storage.Change{Key: key, Delete: true}); err != nil {
+		return fmt.Errorf("failed to remove key: %w", err)
+	}
+	return nil
+}
+
+// currentVersion must be called with the lock held, zero means the key is absent
+func (s *inMemoryStore) currentVersion(key string, now time.Time) (uint64, error) {
+	_, version, err := s.priorVersion(key, now)
+	return version, err
+}
+
+// entries must be called with the lock held, it returns the live entries of
+// every namespace as put events carrying their versions
+func (s *inMemoryStore) entries(now time.Time) ([]transaction.Event, error) {
+	var entries []transaction.Event
+
+	for _, ns := range s.opened() {
+		ks, err := ns.space()
+		if err != nil {
+			return nil, err
+		}
+		err = ks.engine.Ascend("", "", "", func(key string, entry storage.Entry) bool {
+			if entry.Expired(now) {
+				return true
+			}
+			entries = append(entries, transaction.Event{
+				Sequence:  entry.Version,
+				EventType: transaction.EventPut,
+				Namespace: ns.namespace,
+				Key:       key,
+				Value:     entry.Value,
+				ExpiresAt: entry.ExpiresAt,
+				Metadata:  entry.Metadata,
+			})
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read entries: %w", err)
+		}
+	}
+	return entries, nil
+}
+
+// capture returns the live entries together with the sequence of the last
+// write they contain, both read under the same lock. Writes are numbered and
+// handed to the journal under the lock, so the journal already has every event
+// up to the sequence and none after it is part of the entries.
+func (s *inMemoryStore) capture() (transaction.Snapshot, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	entries, err := s.entries(time.Now())
+	if err != nil {
+		return transaction.Snapshot{}, err
+	}
+	return transaction.Snapshot{Sequence: s.sequence, Entries: entries}, nil
+}
+
+// Snapshot hands the current state to the transactor, which persists it
+// and compacts the journal up to the snapshot sequence.
+func (s *inMemoryStore) Snapshot(ctx context.Context) error {
+	const op = "inMemoryStore.Snapshot"
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	snapshot, err := s.capture()
+	if err != nil {
+		log.Error("snapshot failed", slog.Any("error", err))
+		return err
+	}
+
+	if err := s.transactor.WriteSnapshot(ctx, snapshot); err != nil {
+		log.Error("snapshot failed", slog.Any("error", err))
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	log.Info("snapshot written", slog.Uint64("sequence", snapshot.Sequence), slog.Int("keys", len(snapshot.Entries)))
+	return nil
+}
+
+// RunSnapshotter writes a snapshot every interval until ctx is done
+func (s *inMemoryStore) RunSnapshotter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = s.Snapshot(ctx)
+		}
+	}
 }
 
 func (s *inMemoryStore) restoreState() error {
+	snapshot, err := s.transactor.ReadSnapshot()
+	if err != nil {
+		return err
+	}
+
+	// open the namespaces a persistent engine holds so they are served and
+	// take part in snapshots
+	namespaces, err := s.spaces.Namespaces()
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	var (
+		persistent bool
+		sequence   uint64
+		keys       int
+	)
+	for _, name := range append([]string{storage.DefaultNamespace}, namespaces...) {
+		ks, err := s.in(name).space()
+		if err != nil {
+			return err
+		}
+		persistent = ks.engine.Persistent()
+		sequence = max(sequence, ks.engine.Sequence())
+		keys += ks.engine.Len()
+	}
+
+	// a persistent engine already holds every acknowledged write, the journal
+	// is still read through so the transactor finds its end
+	kept := persistent && sequence > 0
+
+	now := time.Now()
+	if kept {
+		s.sequence = max(sequence, snapshot.Sequence)
+		s.log.Debug("storage engine state kept",
+			slog.Uint64("sequence", s.sequence),
+			slog.Int("namespaces", len(namespaces)),
+			slog.Int("keys", keys))
+	} else {
+		if err := s.loadSnapshot(snapshot, now); err != nil {
+			return err
+		}
+		s.log.Debug("snapshot loaded",
+			slog.Uint64("sequence", snapshot.Sequence),
+			slog.Int("keys", len(snapshot.Entries)))
+	}
+
 	eventsCh, errCh := s.transactor.ReadEvents()
 	if eventsCh == nil || errCh == nil {
 		return transaction.ErrEmptyJournal
 	}
 
 	for event := range eventsCh {
-		switch event.EventType {
-		case transaction.EventDelete:
-			s.delete(event.Key)
-		case transaction.EventPut:
-			s.put(event.Key, event.Value)
-		default:
-			return errors.New("unknown event to restore")
+		if kept {
+			s.sequence = max(s.sequence, event.Sequence)
+			continue
+		}
+		if err := s.applyEvent(event, now); err != nil {
+			return err
 		}
 	}
 
-	if err := <-errCh; err != nil {
-		return err
+	err = <-errCh
+	if errors.Is(err, transaction.ErrTornRecord) {
+		// only the record being written when the process died can be torn
+		s.log.Warn("torn journal tail discarded", slog.Any("error", err))
+		return nil
+	}
+	return err
+}
+
+// loadSnapshot restores the snapshot entries on top of the current state
+func (s *inMemoryStore) loadSnapshot(snapshot transaction.Snapshot, now time.Time) error {
+	for _, entry := range snapshot.Entries {
+		if entry.Expired(now) {
+			continue
+		}
+		version := entry.Sequence
+		if version == 0 {
+			version = snapshot.Sequence
+		}
+		if err := s.in(entry.Namespace).restore(entry.Key, entry.Value, entry.ExpiresAt, version, entry.Metadata); err != nil {
+			return err
+		}
 	}
+
+	s.Lock()
+	s.sequence = max(s.sequence, snapshot.Sequence)
+	s.Unlock()
 	return nil
 }
+
+// applyEvent replays a single journal event into the namespace it names,
+// used on restore and by replicas
+func (s *inMemoryStore) applyEvent(event transaction.Event, now time.Time) error {
+	var (
+		ns  = s.in(event.Namespace)
+		err error
+	)
+
+	s.Lock()
+	s.sequence = max(s.sequence, event.Sequence)
+	s.Unlock()
+
+	switch event.EventType {
+	case transaction.EventDelete, transaction.EventExpire:
+		_, err = ns.delete(event.Key)
+	case transaction.EventPut, transaction.EventIncr:
+		if event.Expired(now) {
+			_, err = ns.delete(event.Key)
+			break
+		}
+		err = ns.restore(event.Key, event.Value, event.ExpiresAt, event.Sequence, event.Metadata)
+	case transaction.EventDrop:
+		s.Lock()
+		err = s.drop(event.Namespace)
+		s.Unlock()
+	default:
+		return errors.New("unknown event to restore")
+	}
+	return err
+}
@@ -2,12 +2,32 @@ package core
 
 import (
 	"cloud/internal/mocks"
+	"cloud/internal/storage"
+	"cloud/internal/transaction"
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+// stored reads the entry of key from the engine, expired entries included
+func stored(store *inMemoryStore, key string) (storage.Entry, bool) {
+	ks, err := store.space()
+	if err != nil {
+		return storage.Entry{}, false
+	}
+	entry, ok, _ := ks.engine.Get(key)
+	return entry, ok
+}
+
 func TestPut(t *testing.T) {
 	var (
 		ctx      = context.Background()
@@ -18,7 +38,7 @@ func TestPut(t *testing.T) {
 	const value = "create-value-put"
 
 	t.Run("Successful Put", func(t *testing.T) {
-		_, contains := store.m[key]
+		_, contains := stored(store, key)
 		if contains {
 			t.Error("key/value already exists")
 		}
@@ -29,12 +49,12 @@ func TestPut(t *testing.T) {
 		}
 		defer store.delete(key)
 
-		val, contains := store.m[key]
+		entry, contains := stored(store, key)
 		if !contains {
 			t.Error("create failed")
 		}
 
-		if val != value {
+		if entry.Value != value {
 			t.Error("val/value mismatch")
 		}
 	})
@@ -52,15 +72,34 @@ func TestPut(t *testing.T) {
 			t.Error(err)
 		}
 
-		val, contains := store.m[key]
+		entry, contains := stored(store, key)
 		if !contains {
 			t.Error("create failed for empty value")
 		}
 
-		if val != "" {
+		if entry.Value != "" {
 			t.Error("val/value mismatch for empty value")
 		}
 	})
+
+	t.Run("Size Limits", func(t *testing.T) {
+		limited, _ := NewStore(&mocks.MockTransactor{}, slog.Default())
+		limited.SetSizeLimits(4, 8)
+
+		if err := limited.Put(ctx, "long-key", "v"); !errors.Is(err, ErrKeyTooLarge) {
+			t.Errorf("expected error %v, got %v", ErrKeyTooLarge, err)
+		}
+		if _, err := limited.Write(ctx, "key", Value("123456789"), WriteOptions{}); !errors.Is(err, ErrValueTooLarge) {
+			t.Errorf("expected error %v, got %v", ErrValueTooLarge, err)
+		}
+		err := limited.Batch(ctx, []Op{{Type: OpPut, Key: "a", Value: "1"}, {Type: OpPut, Key: "b", Value: "123456789"}})
+		if !errors.Is(err, ErrValueTooLarge) || CodeOf(err) != CodeTooLarge {
+			t.Errorf("expected error %v, got %v", ErrValueTooLarge, err)
+		}
+		if err := limited.Put(ctx, "key", "12345678"); err != nil {
+			t.Error(err)
+		}
+	})
 }
 
 func TestGet(t *testing.T) {
@@ -124,7 +163,7 @@ func TestDelete(t *testing.T) {
 			t.Error(err)
 		}
 
-		_, contains := store.m[key]
+		_, contains := stored(store, key)
 		if contains {
 			t.Error("key still exists after deletion")
 		}
@@ -144,3 +183,830 @@ func TestDelete(t *testing.T) {
 		}
 	})
 }
+
+func TestPutWithTTL(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		store, _ = NewStore(&mocks.MockTransactor{}, slog.Default())
+	)
+
+	const key = "create-key-ttl"
+	const value = "create-value-ttl"
+
+	t.Run("Remaining TTL", func(t *testing.T) {
+		err := store.PutWithTTL(ctx, key, value, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.delete(key)
+
+		ttl, err := store.TTL(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl <= 0 || ttl > time.Minute {
+			t.Errorf("unexpected ttl %v", ttl)
+		}
+	})
+
+	t.Run("Put Clears TTL", func(t *testing.T) {
+		_ = store.PutWithTTL(ctx, key, value, time.Minute)
+		_ = store.Put(ctx, key, value)
+		defer store.delete(key)
+
+		ttl, err := store.TTL(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl != 0 {
+			t.Errorf("expected no ttl, got %v", ttl)
+		}
+	})
+
+	t.Run("Expired Key Not Found", func(t *testing.T) {
+		err := store.PutWithTTL(ctx, key, value, time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		_, err = store.Get(ctx, key)
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected error %v, got %v", ErrKeyNotFound, err)
+		}
+
+		store.reapExpired(ctx, time.Now())
+		if _, contains := stored(store, key); contains {
+			t.Error("expired key was not reaped")
+		}
+		ks, _ := store.space()
+		if keys, _ := ks.engine.Expired(time.Now().Add(time.Hour)); len(keys) != 0 {
+			t.Error("expiry was not reaped")
+		}
+	})
+
+	t.Run("Invalid TTL", func(t *testing.T) {
+		err := store.PutWithTTL(ctx, key, value, 0)
+		if !errors.Is(err, ErrInvalidTTL) {
+			t.Errorf("expected error %v, got %v", ErrInvalidTTL, err)
+		}
+	})
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		store, _ = NewStore(&mocks.MockTransactor{}, slog.Default())
+	)
+
+	const key = "create-key-cas"
+
+	t.Run("Create If Absent", func(t *testing.T) {
+		version, err := store.CompareAndSwap(ctx, key, "v1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		current, err := store.Version(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current != version {
+			t.Errorf("version mismatch: got %d, want %d", current, version)
+		}
+
+		if _, err := store.CompareAndSwap(ctx, key, "v1", 0); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("expected error %v, got %v", ErrVersionMismatch, err)
+		}
+	})
+
+	t.Run("Stale Version", func(t *testing.T) {
+		stale, _ := store.Version(ctx, key)
+
+		fresh, err := store.CompareAndSwap(ctx, key, "v2", stale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fresh <= stale {
+			t.Errorf("version did not grow: %d <= %d", fresh, stale)
+		}
+
+		if _, err := store.CompareAndSwap(ctx, key, "v3", stale); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("expected error %v, got %v", ErrVersionMismatch, err)
+		}
+		if err := store.CompareAndDelete(ctx, key, stale); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("expected error %v, got %v", ErrVersionMismatch, err)
+		}
+
+		if err := store.CompareAndDelete(ctx, key, fresh); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Version(ctx, key); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected error %v, got %v", ErrKeyNotFound, err)
+		}
+	})
+
+	t.Run("With TTL", func(t *testing.T) {
+		if _, err := store.Write(ctx, key, Value("v4"), WriteOptions{TTL: time.Minute, Expected: new(uint64)}); err != nil {
+			t.Fatal(err)
+		}
+
+		ttl, err := store.TTL(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl <= 0 || ttl > time.Minute {
+			t.Errorf("unexpected ttl %v", ttl)
+		}
+
+		if _, err := store.Write(ctx, key, Value("v5"), WriteOptions{TTL: time.Minute, Expected: new(uint64)}); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("expected error %v, got %v", ErrVersionMismatch, err)
+		}
+	})
+
+	t.Run("Versions Survive Replay", func(t *testing.T) {
+		transactor := &mocks.MockTransactor{}
+		store, _ := NewStore(transactor, slog.Default())
+
+		_ = store.Put(ctx, "a", "1")
+		_ = store.Delete(ctx, "a")
+		version, err := store.CompareAndSwap(ctx, "b", "2", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		replica, _ := NewStore(&mocks.MockTransactor{}, slog.Default())
+		events, errs := transactor.ReplayEvents(ctx, 0)
+		for e := range events {
+			if err := replica.Apply(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+
+		if got, _ := replica.Version(ctx, "b"); got != version {
+			t.Errorf("replayed version %d, want %d", got, version)
+		}
+		if last, _ := transactor.LastSequence(); last != version {
+			t.Errorf("version %d is not the journal sequence %d", version, last)
+		}
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		transactor = &mocks.MockTransactor{}
+		store, _   = NewStore(transactor, slog.Default())
+		meta       = transaction.Metadata{ContentType: "application/json", Headers: map[string]string{"owner": "ops"}}
+	)
+
+	if _, err := store.Write(ctx, "doc", Value(`{"a":1}`), WriteOptions{Metadata: meta}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.Lookup(ctx, "doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Metadata.ContentType != meta.ContentType || first.Metadata.Headers["owner"] != "ops" {
+		t.Errorf("got metadata %+v", first.Metadata)
+	}
+	if first.Metadata.CreatedAt.IsZero() || !first.Metadata.UpdatedAt.Equal(first.Metadata.CreatedAt) {
+		t.Errorf("got timestamps %+v", first.Metadata)
+	}
+
+	t.Run("Overwrite Keeps Creation Time", func(t *testing.T) {
+		if err := store.Put(ctx, "doc", "plain"); err != nil {
+			t.Fatal(err)
+		}
+		item, err := store.Lookup(ctx, "doc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Metadata.ContentType != "" || item.Metadata.Headers != nil {
+			t.Errorf("a plain put kept metadata %+v", item.Metadata)
+		}
+		if !item.Metadata.CreatedAt.Equal(first.Metadata.CreatedAt) || item.Metadata.UpdatedAt.Before(first.Metadata.UpdatedAt) {
+			t.Errorf("got timestamps %+v after %+v", item.Metadata, first.Metadata)
+		}
+		if item.Version <= first.Version {
+			t.Errorf("version did not grow: %d <= %d", item.Version, first.Version)
+		}
+	})
+
+	t.Run("Compare And Swap", func(t *testing.T) {
+		current, _ := store.Version(ctx, "doc")
+		if _, err := store.Write(ctx, "doc", Value("<p/>"), WriteOptions{TTL: time.Minute, Metadata: transaction.Metadata{ContentType: "text/html"}, Expected: &current}); err != nil {
+			t.Fatal(err)
+		}
+		item, _ := store.Lookup(ctx, "doc")
+		if item.Metadata.ContentType != "text/html" || item.ExpiresAt.IsZero() {
+			t.Errorf("got %+v", item)
+		}
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		replica, _ := NewStore(&mocks.MockTransactor{}, slog.Default())
+		events, errs := transactor.ReplayEvents(ctx, 0)
+		for e := range events {
+			if err := replica.Apply(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+
+		want, _ := store.Lookup(ctx, "doc")
+		got, err := replica.Lookup(ctx, "doc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Metadata.ContentType != want.Metadata.ContentType || got.Version != want.Version ||
+			!got.Metadata.CreatedAt.Equal(want.Metadata.CreatedAt) || !got.Metadata.UpdatedAt.Equal(want.Metadata.UpdatedAt) {
+			t.Errorf("replayed %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestIncr(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		transactor = &mocks.MockTransactor{}
+		store, _   = NewStore(transactor, slog.Default())
+	)
+
+	t.Run("Concurrent Increments", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, _, err := store.Incr(ctx, "hits", 2); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		got, _, err := store.Incr(ctx, "hits", -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != 99 {
+			t.Errorf("got %d, want 99", got)
+		}
+	})
+
+	t.Run("Keeps TTL And Metadata", func(t *testing.T) {
+		meta := transaction.Metadata{ContentType: "text/plain"}
+		if _, err := store.Write(ctx, "views", Value("7"), WriteOptions{TTL: time.Minute, Metadata: meta}); err != nil {
+			t.Fatal(err)
+		}
+		got, version, err := store.Incr(ctx, "views", 3)
+		if err != nil || got != 10 {
+			t.Fatalf("got %d, %v", got, err)
+		}
+		item, _ := store.Lookup(ctx, "views")
+		if item.Value.String() != "10" || item.ExpiresAt.IsZero() || item.Metadata.ContentType != "text/plain" {
+			t.Errorf("got %+v", item)
+		}
+		if item.Version != version {
+			t.Errorf("got version %d, want %d", version, item.Version)
+		}
+	})
+
+	t.Run("Not An Integer", func(t *testing.T) {
+		_ = store.Put(ctx, "name", "alice")
+		if _, _, err := store.Incr(ctx, "name", 1); !errors.Is(err, ErrNotInteger) {
+			t.Errorf("got %v, want %v", err, ErrNotInteger)
+		}
+		if got, _ := store.Get(ctx, "name"); got != "alice" {
+			t.Errorf("value changed to %q", got)
+		}
+	})
+
+	t.Run("Overflow", func(t *testing.T) {
+		_ = store.Put(ctx, "max", strconv.FormatInt(math.MaxInt64, 10))
+		if _, _, err := store.Incr(ctx, "max", 1); !errors.Is(err, ErrOverflow) {
+			t.Errorf("got %v, want %v", err, ErrOverflow)
+		}
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		// the second pass replays every increment on top of its own result,
+		// like a replica tailing the journal from before its snapshot
+		replica, _ := NewStore(&mocks.MockTransactor{}, slog.Default())
+		for range 2 {
+			events, errs := transactor.ReplayEvents(ctx, 0)
+			for e := range events {
+				if err := replica.Apply(e); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, key := range []string{"hits", "views"} {
+			want, _ := store.Lookup(ctx, key)
+			got, err := replica.Lookup(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Value.String() != want.Value.String() || got.Version != want.Version || !got.ExpiresAt.Equal(want.ExpiresAt) {
+				t.Errorf("replayed %+v, want %+v", got, want)
+			}
+		}
+	})
+}
+
+func TestBatch(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		store, _ = NewStore(&mocks.MockTransactor{}, slog.Default())
+	)
+
+	_ = store.Put(ctx, "batch-a", "old")
+
+	t.Run("Successful Batch", func(t *testing.T) {
+		err := store.Batch(ctx, []Op{
+			{Type: OpPut, Key: "batch-b", Value: "b"},
+			{Type: OpDelete, Key: "batch-a"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, contains := stored(store, "batch-a"); contains {
+			t.Error("deleted key still exists")
+		}
+		if entry, _ := stored(store, "batch-b"); entry.Value != "b" {
+			t.Error("put key is missing")
+		}
+	})
+
+	t.Run("Invalid Batch Is Not Applied", func(t *testing.T) {
+		err := store.Batch(ctx, []Op{
+			{Type: OpPut, Key: "batch-c", Value: "c"},
+			{Type: OpPut, Key: "", Value: "empty"},
+		})
+		if !errors.Is(err, ErrEmptyKey) {
+			t.Errorf("expected error %v, got %v", ErrEmptyKey, err)
+		}
+		if _, contains := stored(store, "batch-c"); contains {
+			t.Error("half of the batch was applied")
+		}
+
+		err = store.Batch(ctx, []Op{{Type: "upsert", Key: "batch-c"}})
+		if !errors.Is(err, ErrInvalidOp) {
+			t.Errorf("expected error %v, got %v", ErrInvalidOp, err)
+		}
+	})
+}
+
+func TestList(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		store, _ = NewStore(&mocks.MockTransactor{}, slog.Default())
+	)
+
+	for _, key := range []string{"user:3", "order:1", "user:1", "user:2", "users"} {
+		_ = store.Put(ctx, key, "value-"+key)
+	}
+	_ = store.Delete(ctx, "user:2")
+
+	t.Run("Prefix Pages", func(t *testing.T) {
+		var keys []string
+
+		opts := ListOptions{Prefix: "user:", Limit: 1}
+		for {
+			res, err := store.List(ctx, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, item := range res.Items {
+				keys = append(keys, item.Key)
+			}
+			if res.Next == "" {
+				break
+			}
+			opts.After = res.Next
+		}
+
+		if len(keys) != 2 || keys[0] != "user:1" || keys[1] != "user:3" {
+			t.Errorf("unexpected keys %v", keys)
+		}
+	})
+
+	t.Run("Start Key", func(t *testing.T) {
+		res, err := store.List(ctx, ListOptions{Start: "user:2", Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Items) != 2 || res.Items[0].Key != "user:3" || res.Items[0].Value != "value-user:3" {
+			t.Errorf("unexpected items %v", res.Items)
+		}
+	})
+
+	t.Run("Invalid Limit", func(t *testing.T) {
+		_, err := store.List(ctx, ListOptions{})
+		if !errors.Is(err, ErrInvalidLimit) {
+			t.Errorf("expected error %v, got %v", ErrInvalidLimit, err)
+		}
+	})
+}
+
+func TestWatch(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		store, _    = NewStore(&mocks.MockTransactor{}, slog.Default())
+	)
+	defer cancel()
+
+	_ = store.Put(ctx, "user:1", "a")  // sequence 1
+	_ = store.Put(ctx, "order:1", "b") // sequence 2
+	_ = store.Delete(ctx, "user:1")    // sequence 3
+
+	events, err := store.Watch(ctx, WatchOptions{Prefix: "user:", From: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = store.Put(ctx, "user:2", "c") // sequence 4, live
+
+	want := []WatchEvent{
+		{Sequence: 1, Type: WatchPut, Key: "user:1", Value: "a"},
+		{Sequence: 3, Type: WatchDelete, Key: "user:1"},
+		{Sequence: 4, Type: WatchPut, Key: "user:2", Value: "c"},
+	}
+	for _, w := range want {
+		select {
+		case got := <-events:
+			if got.Type == WatchPut && got.Metadata.UpdatedAt.IsZero() {
+				t.Errorf("put %d carries no update time", got.Sequence)
+			}
+			got.Metadata = transaction.Metadata{}
+			if !reflect.DeepEqual(got, w) {
+				t.Errorf("got %+v, want %+v", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %+v", w)
+		}
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+// rejectingTransactor fails writes while reporting itself healthy, like a
+// journal that has not noticed the failure yet
+type rejectingTransactor struct {
+	*mocks.MockTransactor
+	reject bool
+}
+
+func (t *rejectingTransactor) Append(ctx context.Context, events []transaction.Event) (func() error, error) {
+	if t.reject {
+		return func() error { return errors.New("write rejected") }, nil
+	}
+	return t.MockTransactor.Append(ctx, events)
+}
+
+func TestJournalFailure(t *testing.T) {
+	ctx := context.Background()
+
+	const key = "journal-failure"
+
+	t.Run("Failed Write Is Rolled Back", func(t *testing.T) {
+		transactor := &rejectingTransactor{MockTransactor: &mocks.MockTransactor{}}
+		store, _ := NewStore(transactor, slog.Default())
+
+		_ = store.Put(ctx, key, "v1")
+		version, _ := store.Version(ctx, key)
+
+		transactor.reject = true
+		if err := store.Put(ctx, key, "v2"); err == nil {
+			t.Error("expected put error, got nil")
+		}
+		if err := store.Delete(ctx, key); err == nil {
+			t.Error("expected delete error, got nil")
+		}
+		if _, err := store.CompareAndSwap(ctx, key, "v3", version); err == nil {
+			t.Error("expected compare and swap error, got nil")
+		}
+
+		if entry, _ := stored(store, key); entry.Value != "v1" || entry.Version != version {
+			t.Errorf("prior value was not restored: %q at version %d", entry.Value, entry.Version)
+		}
+	})
+
+	t.Run("Degraded Journal", func(t *testing.T) {
+		transactor := &mocks.MockTransactor{}
+		store, _ := NewStore(transactor, slog.Default())
+
+		_ = store.Put(ctx, key, "v1")
+
+		transactor.SetFailure(errors.New("no space left on device"))
+		if store.JournalHealth().Healthy {
+			t.Error("journal reported healthy")
+		}
+		if err := store.Put(ctx, key, "v2"); !errors.Is(err, ErrJournalUnavailable) {
+			t.Errorf("expected error %v, got %v", ErrJournalUnavailable, err)
+		}
+		if value, err := store.Get(ctx, key); err != nil || value != "v1" {
+			t.Errorf("read failed while degraded: %q, %v", value, err)
+		}
+
+		transactor.SetFailure(nil)
+		if err := store.Put(ctx, key, "v2"); err != nil {
+			t.Errorf("write failed after recovery: %v", err)
+		}
+	})
+}
+
+func TestPersistentEngine(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.db")
+
+	spaces, err := storage.NewBoltKeyspaces(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, _ := NewStoreWithKeyspaces(spaces, &mocks.MockTransactor{}, slog.Default())
+	_ = store.Put(ctx, "kept", "value")
+	team, _ := store.Namespace("team")
+	_ = team.Put(ctx, "kept", "team value")
+	version, _ := team.Version(ctx, "kept")
+	_ = spaces.Close()
+
+	// an empty journal, the engine alone brings the state back
+	spaces, err = storage.NewBoltKeyspaces(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spaces.Close()
+	store, err = NewStoreWithKeyspaces(spaces, &mocks.MockTransactor{}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := store.Get(ctx, "kept"); err != nil || value != "value" {
+		t.Errorf("state was not kept: %q, %v", value, err)
+	}
+	team, _ = store.Namespace("team")
+	if value, err := team.Get(ctx, "kept"); err != nil || value != "team value" {
+		t.Errorf("namespace was not kept: %q, %v", value, err)
+	}
+	if next, _ := store.CompareAndSwap(ctx, "other", "value", 0); next <= version {
+		t.Errorf("version did not continue: %d <= %d", next, version)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	ctx := context.Background()
+
+	limited := func(t *testing.T, policy string, limits Limits) (*inMemoryStore, *mocks.MockTransactor) {
+		t.Helper()
+
+		transactor := &mocks.MockTransactor{}
+		store, _ := NewStore(transactor, slog.Default())
+
+		p, err := NewEvictionPolicy(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		limits.Policy = p
+		if err := store.SetLimits(limits); err != nil {
+			t.Fatal(err)
+		}
+		return store, transactor
+	}
+
+	t.Run("LRU", func(t *testing.T) {
+		store, _ := limited(t, EvictionLRU, Limits{MaxKeys: 2})
+
+		_ = store.Put(ctx, "a", "1")
+		_ = store.Put(ctx, "b", "2")
+		_, _ = store.Get(ctx, "a") // b is now the least recently used
+		if err := store.Put(ctx, "c", "3"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.Get(ctx, "b"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected b to be evicted, got %v", err)
+		}
+		if usage := store.Usage(); usage.Keys != 2 || usage.Evictions != 1 {
+			t.Errorf("unexpected usage %+v", usage)
+		}
+	})
+
+	t.Run("LFU", func(t *testing.T) {
+		store, _ := limited(t, EvictionLFU, Limits{MaxKeys: 2})
+
+		_ = store.Put(ctx, "a", "1")
+		_ = store.Put(ctx, "b", "2")
+		_, _ = store.Get(ctx, "a")
+		_, _ = store.Get(ctx, "b")
+		_, _ = store.Get(ctx, "b") // a is now the least frequently used
+		_ = store.Put(ctx, "c", "3")
+
+		if _, err := store.Get(ctx, "a"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected a to be evicted, got %v", err)
+		}
+	})
+
+	t.Run("Max Bytes", func(t *testing.T) {
+		store, transactor := limited(t, EvictionRandom, Limits{MaxBytes: 10, JournalEvictions: true})
+
+		_ = store.Put(ctx, "a", "1234")
+		_ = store.Put(ctx, "b", "1234")
+		if err := store.Put(ctx, "c", "1234"); err != nil {
+			t.Fatal(err)
+		}
+		if usage := store.Usage(); usage.Bytes > 10 || usage.Keys != 2 {
+			t.Errorf("unexpected usage %+v", usage)
+		}
+
+		// the eviction is journaled, so a restart does not bring the key back
+		var deletes int
+		events, _ := transactor.ReplayEvents(ctx, 0)
+		for e := range events {
+			if e.EventType == transaction.EventDelete {
+				deletes++
+			}
+		}
+		if deletes != 1 {
+			t.Errorf("got %d journaled evictions, want 1", deletes)
+		}
+
+		if err := store.Put(ctx, "d", "larger than ten bytes"); !errors.Is(err, ErrInsufficientStorage) {
+			t.Errorf("expected error %v, got %v", ErrInsufficientStorage, err)
+		}
+		if usage := store.Usage(); usage.Keys != 2 {
+			t.Errorf("an oversized value evicted keys: %+v", usage)
+		}
+	})
+
+	t.Run("No Eviction", func(t *testing.T) {
+		store, _ := limited(t, EvictionNone, Limits{MaxKeys: 1})
+
+		_ = store.Put(ctx, "a", "1")
+		if err := store.Put(ctx, "b", "2"); !errors.Is(err, ErrInsufficientStorage) {
+			t.Errorf("expected error %v, got %v", ErrInsufficientStorage, err)
+		}
+		if err := store.Put(ctx, "a", "overwrite"); err != nil {
+			t.Errorf("overwrite of a stored key failed: %v", err)
+		}
+		err := store.Batch(ctx, []Op{{Type: OpDelete, Key: "a"}, {Type: OpPut, Key: "b", Value: "2"}})
+		if err != nil {
+			t.Errorf("batch freeing its own room failed: %v", err)
+		}
+	})
+}
+
+func TestUsage(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewStore(&mocks.MockTransactor{}, slog.Default())
+
+	_ = store.Put(ctx, "a", "12345")
+	_ = store.Put(ctx, "b", "1")
+	_ = store.Put(ctx, "a", "123") // overwrites count with their new size
+	_ = store.Batch(ctx, []Op{{Type: OpPut, Key: "c", Value: "1"}, {Type: OpDelete, Key: "c"}})
+	_ = store.Delete(ctx, "b")
+
+	if usage := store.Usage(); usage.Keys != 1 || usage.Bytes != 4 {
+		t.Errorf("got %+v, want 1 key of 4 bytes", usage)
+	}
+
+	team, _ := store.Namespace("team")
+	_ = team.Put(ctx, "k", "v")
+	usages := store.Usages()
+	if len(usages) != 2 || usages["team"].Bytes != 2 || usages[""].Bytes != 4 {
+		t.Errorf("got %+v", usages)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Isolation", func(t *testing.T) {
+		transactor := &mocks.MockTransactor{}
+		store, _ := NewStore(transactor, slog.Default())
+		team, err := store.Namespace("team-a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_ = store.Put(ctx, "key", "default")
+		_ = team.Put(ctx, "key", "team")
+		_ = team.Put(ctx, "only", "team")
+
+		if value, _ := store.Get(ctx, "key"); value != "default" {
+			t.Errorf("default namespace got %q", value)
+		}
+		if value, _ := team.Get(ctx, "key"); value != "team" {
+			t.Errorf("namespace got %q", value)
+		}
+		if _, err := store.Get(ctx, "only"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected error %v, got %v", ErrKeyNotFound, err)
+		}
+		if res, _ := team.List(ctx, ListOptions{Limit: 10}); len(res.Items) != 2 {
+			t.Errorf("namespace lists %v", res.Items)
+		}
+
+		events, _ := transactor.ReplayEvents(ctx, 0)
+		var spaces []string
+		for e := range events {
+			spaces = append(spaces, e.Namespace)
+		}
+		if fmt.Sprint(spaces) != "[ team-a team-a]" {
+			t.Errorf("journaled namespaces %q", spaces)
+		}
+	})
+
+	t.Run("Invalid Name", func(t *testing.T) {
+		store, _ := NewStore(&mocks.MockTransactor{}, slog.Default())
+
+		for _, name := range []string{"a/b", "with space", strings.Repeat("n", 65)} {
+			if _, err := store.Namespace(name); !errors.Is(err, ErrInvalidNamespace) {
+				t.Errorf("%q: expected error %v, got %v", name, ErrInvalidNamespace, err)
+			}
+		}
+		if err := store.DropNamespace(ctx, ""); !errors.Is(err, ErrInvalidNamespace) {
+			t.Errorf("expected error %v, got %v", ErrInvalidNamespace, err)
+		}
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		transactor := &mocks.MockTransactor{}
+		store, _ := NewStore(transactor, slog.Default())
+		team, _ := store.Namespace("team")
+
+		_ = store.Put(ctx, "key", "default")
+		_ = team.Put(ctx, "key", "team")
+
+		watch, err := team.Watch(ctx, WatchOptions{From: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.DropNamespace(ctx, "team"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := team.Get(ctx, "key"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected error %v, got %v", ErrKeyNotFound, err)
+		}
+		if value, _ := store.Get(ctx, "key"); value != "default" {
+			t.Errorf("drop touched the default namespace, got %q", value)
+		}
+
+		// the watch of the namespace sees its put and its drop only
+		for _, want := range []WatchEventType{WatchPut, WatchDrop} {
+			select {
+			case got := <-watch:
+				if got.Type != want || got.Namespace != "team" {
+					t.Errorf("got %+v, want a %s in team", got, want)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timeout waiting for %s", want)
+			}
+		}
+
+		// a replica applies the journaled drop the same way
+		replica, _ := NewStore(&mocks.MockTransactor{}, slog.Default())
+		_ = replica.Apply(transaction.Event{Sequence: 1, EventType: transaction.EventPut, Namespace: "team", Key: "key", Value: "team"})
+		_ = replica.Apply(transaction.Event{Sequence: 2, EventType: transaction.EventDrop, Namespace: "team"})
+		replicaTeam, _ := replica.Namespace("team")
+		if _, err := replicaTeam.Get(ctx, "key"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected error %v, got %v", ErrKeyNotFound, err)
+		}
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		store, _ := NewStore(&mocks.MockTransactor{}, slog.Default())
+		if err := store.SetQuota("small", Limits{MaxKeys: 1}); err != nil {
+			t.Fatal(err)
+		}
+		small, _ := store.Namespace("small")
+
+		_ = small.Put(ctx, "a", "1")
+		if err := small.Put(ctx, "b", "2"); !errors.Is(err, ErrInsufficientStorage) {
+			t.Errorf("expected error %v, got %v", ErrInsufficientStorage, err)
+		}
+		if err := store.Put(ctx, "b", "2"); err != nil {
+			t.Errorf("quota bounds other namespaces: %v", err)
+		}
+
+		// the quota outlives a drop
+		_ = store.DropNamespace(ctx, "small")
+		_ = small.Put(ctx, "c", "3")
+		if err := small.Put(ctx, "d", "4"); !errors.Is(err, ErrInsufficientStorage) {
+			t.Errorf("expected error %v after drop, got %v", ErrInsufficientStorage, err)
+		}
+	})
+}
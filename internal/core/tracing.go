@@ -0,0 +1,34 @@
+package core
+
+import (
+	"cloud/internal/tracing"
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("cloud/internal/core")
+
+// startSpan starts the span of an operation on key, empty for operations on
+// the whole namespace. Keys may hold user data and traces leave the node, so
+// only the key length is recorded.
+func (s *inMemoryStore) startSpan(ctx context.Context, op, key string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("kv.namespace", s.namespace)}
+	if key != "" {
+		attrs = append(attrs, attribute.Int("kv.key.length", len(key)))
+	}
+	return tracer.Start(ctx, op, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span of an operation, a missing key or a version mismatch
+// is an answer rather than a failure
+func endSpan(span trace.Span, err error) {
+	if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrVersionMismatch) {
+		span.SetAttributes(attribute.String("kv.outcome", err.Error()))
+		err = nil
+	}
+	tracing.End(span, err)
+}
@@ -0,0 +1,48 @@
+package core
+
+import "unsafe"
+
+var (
+	ErrKeyTooLarge   = newError(CodeTooLarge, "key is too large")
+	ErrValueTooLarge = newError(CodeTooLarge, "value is too large")
+)
+
+// Value is a value passed to and from the store without copying it. The
+// store keeps the bytes a write hands over and a read returns the stored
+// bytes, so neither side may modify a Value once it was handed over.
+type Value []byte
+
+// String returns the value as a string sharing its bytes
+func (v Value) String() string {
+	if len(v) == 0 {
+		return ""
+	}
+	return unsafe.String(unsafe.SliceData(v), len(v))
+}
+
+// valueOf returns s as a Value sharing its bytes
+func valueOf(s string) Value {
+	if s == "" {
+		return nil
+	}
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+// SetSizeLimits bounds the size of single keys and values in every
+// namespace, zero is unlimited. It must be called before the store serves
+// requests.
+func (s *inMemoryStore) SetSizeLimits(maxKeySize int, maxValueSize int64) {
+	s.maxKeySize = maxKeySize
+	s.maxValueSize = maxValueSize
+}
+
+// isSizeValid checks a written pair against the size limits
+func (s *inMemoryStore) isSizeValid(key string, valueSize int) error {
+	if s.maxKeySize > 0 && len(key) > s.maxKeySize {
+		return ErrKeyTooLarge
+	}
+	if s.maxValueSize > 0 && int64(valueSize) > s.maxValueSize {
+		return ErrValueTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"cloud/internal/transaction"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// ErrHistoryCompacted is returned when a watch asks for events that were
+// already folded into a snapshot
+var ErrHistoryCompacted = newError(CodeGone, "requested sequence is no longer in the journal")
+
+// watchBuffer is how far a watcher may fall behind the writer before it is dropped
+const watchBuffer = 256
+
+type WatchEventType string
+
+const (
+	WatchPut    WatchEventType = "put"
+	WatchDelete WatchEventType = "delete"
+	WatchExpire WatchEventType = "expire"
+	WatchDrop   WatchEventType = "drop" // the whole namespace was dropped, Key is empty
+	WatchIncr   WatchEventType = "incr" // Value holds the result of the increment
+)
+
+type WatchEvent struct {
+	Sequence  uint64
+	Type      WatchEventType
+	Namespace string
+	Key       string
+	Value     string
+	ExpiresAt time.Time // zero if the key never expires
+	Metadata  transaction.Metadata
+}
+
+type WatchOptions struct {
+	Prefix string // only keys starting with Prefix
+	From   uint64 // first journal sequence to deliver, zero for live events only
+	// AllNamespaces delivers the changes of every namespace instead of only
+	// those of the namespace of the store
+	AllNamespaces bool
+}
+
+// Watch streams journaled changes. With opts.From set it first replays the
+// journal from that sequence, then switches to live events without gaps or
+// duplicates. The channel is closed when ctx is done or the watcher falls too
+// far behind, in which case it should resume from the last sequence it saw.
+func (s *inMemoryStore) Watch(ctx context.Context, opts WatchOptions) (<-chan WatchEvent, error) {
+	const op = "inMemoryStore.Watch"
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	// subscribe before replaying so nothing written meanwhile is missed
+	live, cancel := s.transactor.Subscribe(watchBuffer)
+
+	var (
+		replay    <-chan transaction.Event
+		replayErr <-chan error
+		first     transaction.Event
+		hasFirst  bool
+	)
+
+	if opts.From > 0 {
+		replay, replayErr = s.transactor.ReplayEvents(ctx, opts.From-1)
+
+		// wait for the first event so a compacted history is reported to the caller
+		first, hasFirst = <-replay
+		if !hasFirst {
+			if err := <-replayErr; err != nil {
+				cancel()
+				if errors.Is(err, transaction.ErrCompacted) {
+					return nil, ErrHistoryCompacted
+				}
+				log.Error("replay failed", slog.Any("error", err))
+				return nil, fmt.Errorf("failed to replay journal: %w", err)
+			}
+		}
+	}
+
+	out := make(chan WatchEvent)
+
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		var last uint64
+		if opts.From > 0 {
+			last = opts.From - 1
+		}
+
+		send := func(e transaction.Event) bool {
+			last = e.Sequence
+			if !opts.AllNamespaces && e.Namespace != s.namespace {
+				return true
+			}
+			if e.EventType != transaction.EventDrop && !strings.HasPrefix(e.Key, opts.Prefix) {
+				return true
+			}
+
+			select {
+			case out <- toWatchEvent(e):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if hasFirst {
+			if !send(first) {
+				return
+			}
+			for e := range replay {
+				if !send(e) {
+					return
+				}
+			}
+			if err := <-replayErr; err != nil {
+				log.Error("replay failed", slog.Any("error", err))
+				return
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-live:
+				if !ok {
+					log.Warn("watcher dropped", slog.Uint64("sequence", last))
+					return
+				}
+				if e.Sequence <= last {
+					continue // already replayed
+				}
+				if !send(e) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func toWatchEvent(e transaction.Event) WatchEvent {
+	we := WatchEvent{
+		Sequence:  e.Sequence,
+		Namespace: e.Namespace,
+		Key:       e.Key,
+		Value:     e.Value,
+		ExpiresAt: e.ExpiresAt,
+		Metadata:  e.Metadata,
+	}
+
+	switch e.EventType {
+	case transaction.EventPut:
+		we.Type = WatchPut
+	case transaction.EventExpire:
+		we.Type = WatchExpire
+	case transaction.EventDrop:
+		we.Type = WatchDrop
+	case transaction.EventIncr:
+		we.Type = WatchIncr
+	default:
+		we.Type = WatchDelete
+	}
+	return we
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"cloud/internal/apierror"
+	"cloud/internal/core"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type batchRequest struct {
+	Operations []batchOperation `json:"operations"`
+}
+
+type batchOperation struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	TTL   int64  `json:"ttl,omitempty"` // seconds
+}
+
+type batchResponse struct {
+	Applied int `json:"applied"`
+}
+
+func (h *Handler) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.BatchHandler"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	var req batchRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.maxBatchSize)).Decode(&req)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		log.Warn("batch too large", slog.Int64("limit", tooLarge.Limit))
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Warn("decode body failed", slog.Any("error", err))
+		apierror.Write(w, r, core.CodeInvalidArgument, "invalid batch body: "+err.Error())
+		return
+	}
+
+	ops := make([]core.Op, 0, len(req.Operations))
+	for _, o := range req.Operations {
+		if err := h.checkSize(o.Key, o.Value); err != nil {
+			log.Warn("operation too large", slog.String("key", o.Key), slog.Any("error", err))
+			apierror.WriteError(w, r, err)
+			return
+		}
+		ops = append(ops, core.Op{
+			Type:  core.OpType(o.Op),
+			Key:   o.Key,
+			Value: o.Value,
+			TTL:   time.Duration(o.TTL) * time.Second,
+		})
+	}
+
+	err = h.store.Batch(r.Context(), ops)
+	if core.CodeOf(err) == core.CodeInvalidArgument {
+		log.Warn("invalid batch", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Error("batch failed", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+
+	log.Info("batch applied", slog.Int("operations", len(ops)))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(batchResponse{Applied: len(ops)})
+}
+
+// checkSize checks an operation of a batch against the size limits
+func (h *Handler) checkSize(key, value string) error {
+	if h.maxKeySize > 0 && len(key) > h.maxKeySize {
+		return core.ErrKeyTooLarge
+	}
+	if h.maxValueSize > 0 && int64(len(value)) > h.maxValueSize {
+		return core.ErrValueTooLarge
+	}
+	return nil
+}
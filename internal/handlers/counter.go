@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"cloud/internal/apierror"
+	"cloud/internal/core"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// IncrHandler adds the by query parameter, 1 if absent, to the integer value
+// of the key and answers with the result
+func (h *Handler) IncrHandler(w http.ResponseWriter, r *http.Request) {
+	h.incr(w, r, "Handler.IncrHandler", 1)
+}
+
+// DecrHandler subtracts the by query parameter, 1 if absent, from the integer
+// value of the key and answers with the result
+func (h *Handler) DecrHandler(w http.ResponseWriter, r *http.Request) {
+	h.incr(w, r, "Handler.DecrHandler", -1)
+}
+
+func (h *Handler) incr(w http.ResponseWriter, r *http.Request, op string, sign int64) {
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	key := mux.Vars(r)["key"]
+
+	if key == "" {
+		log.Warn("empty key")
+		apierror.WriteError(w, r, core.ErrEmptyKey)
+		return
+	}
+
+	delta, err := parseDelta(r)
+	if err != nil {
+		log.Warn("invalid delta", slog.Any("error", err))
+		apierror.Write(w, r, core.CodeInvalidArgument, err.Error())
+		return
+	}
+
+	result, version, err := h.store.Incr(r.Context(), key, sign*delta)
+	if errors.Is(err, core.ErrNotInteger) || errors.Is(err, core.ErrOverflow) {
+		log.Info("increment rejected", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Error("increment failed", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+
+	log.Info("value incremented", slog.Int64("value", result))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("ETag", formatETag(version))
+	_, _ = fmt.Fprintln(w, result)
+}
+
+// parseDelta reads the by query parameter, its negation has to fit an int64
+// so decrements cannot overflow on it
+func parseDelta(r *http.Request) (int64, error) {
+	raw := r.URL.Query().Get("by")
+	if raw == "" {
+		return 1, nil
+	}
+
+	delta, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || delta == math.MinInt64 {
+		return 0, fmt.Errorf("invalid by %q: want an integer", raw)
+	}
+	return delta, nil
+}
@@ -1,28 +1,66 @@
 package handlers
 
 import (
+	"bytes"
+	"cloud/internal/apierror"
 	"cloud/internal/core"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+const ttlHeader = "X-TTL"
+
+// maxPreallocation bounds the buffer allocated up front for a body of
+// declared length while values are unlimited
+const maxPreallocation = 16 << 20
+
 type Handler struct {
-	store core.Store
-	log   *slog.Logger
+	store        core.Store
+	log          *slog.Logger
+	maxValueSize int64 // zero is unlimited
+
+	maxKeySize   int   // zero is unlimited
+	maxBatchSize int64 // always set, batch bodies are never unbounded
 }
 
+// DefaultMaxBatchSize bounds batch bodies unless SetBatchLimit sets another limit
+const DefaultMaxBatchSize int64 = 64 << 20
+
 func NewHandler(store core.Store, log *slog.Logger) *Handler {
 	return &Handler{
-		store: store,
-		log:   log,
+		store:        store,
+		log:          log,
+		maxBatchSize: DefaultMaxBatchSize,
+	}
+}
+
+// SetSizeLimits rejects bodies of puts above maxValueSize and batch operations
+// above either limit with 413, zero is unlimited. It must be called before the
+// handler serves requests.
+func (h *Handler) SetSizeLimits(maxKeySize int, maxValueSize int64) {
+	h.maxKeySize = maxKeySize
+	h.maxValueSize = maxValueSize
+}
+
+// SetBatchLimit rejects batch bodies above maxBatchSize with 413, zero keeps
+// DefaultMaxBatchSize. It must be called before the handler serves requests.
+func (h *Handler) SetBatchLimit(maxBatchSize int64) {
+	if maxBatchSize > 0 {
+		h.maxBatchSize = maxBatchSize
 	}
 }
 
+// MaxBatchSize returns the limit of batch bodies
+func (h *Handler) MaxBatchSize() int64 {
+	return h.maxBatchSize
+}
+
 func (h *Handler) HelloGoHandler(w http.ResponseWriter, r *http.Request) {
 	_, _ = fmt.Fprintln(w, "Hello World!")
 }
@@ -39,28 +77,87 @@ func (h *Handler) PutHandler(w http.ResponseWriter, r *http.Request) {
 
 	if key == "" {
 		log.Warn("empty key")
-		http.Error(w, "empty key", http.StatusBadRequest)
+		apierror.WriteError(w, r, core.ErrEmptyKey)
+		return
+	}
+
+	ttl, err := parseTTL(r)
+	if err != nil {
+		log.Warn("invalid ttl", slog.Any("error", err))
+		apierror.Write(w, r, core.CodeInvalidArgument, err.Error())
 		return
 	}
 
-	value, err := io.ReadAll(r.Body)
+	value, err := h.readValue(w, r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		log.Warn("value too large", slog.Int64("limit", tooLarge.Limit))
+		apierror.WriteError(w, r, err)
+		return
+	}
 	if err != nil {
 		log.Error("read body failed", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.WriteError(w, r, err)
 		return
 	}
 
-	err = h.store.Put(r.Context(), key, string(value))
+	if hasPrecondition(r) {
+		h.conditionalPut(w, r, key, value, ttl)
+		return
+	}
+
+	version, err := h.store.Write(r.Context(), key, value, core.WriteOptions{TTL: ttl, Metadata: requestMetadata(r)})
 	if err != nil {
 		log.Error("put failed", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.WriteError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", formatETag(version))
+
 	log.Info("value stored", slog.Int("size", len(value)))
 	w.WriteHeader(http.StatusCreated)
 }
 
+// conditionalPut swaps the value only if If-Match / If-None-Match hold for the
+// current version, a nonzero ttl expires the new value
+func (h *Handler) conditionalPut(w http.ResponseWriter, r *http.Request, key string, value core.Value, ttl time.Duration) {
+	const op = "Handler.conditionalPut"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	current, err := currentVersion(r.Context(), h.store, key)
+	if err != nil {
+		log.Error("version lookup failed", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+
+	if !preconditionHolds(r, current) {
+		log.Info("precondition failed", slog.Uint64("version", current))
+		apierror.Write(w, r, core.CodePreconditionFailed, "precondition failed")
+		return
+	}
+
+	version, err := h.store.Write(r.Context(), key, value, core.WriteOptions{TTL: ttl, Metadata: requestMetadata(r), Expected: &current})
+	if errors.Is(err, core.ErrVersionMismatch) {
+		log.Info("concurrent update", slog.Uint64("version", current))
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Error("compare and swap failed", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+
+	log.Info("value stored", slog.Int("size", len(value)), slog.Uint64("version", version))
+	w.Header().Set("ETag", formatETag(version))
+	w.WriteHeader(http.StatusCreated)
+}
+
 func (h *Handler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	const op = "Handler.DeleteHandler"
 
@@ -71,10 +168,58 @@ func (h *Handler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["key"]
 
+	if key == "" {
+		log.Warn("empty key")
+		apierror.WriteError(w, r, core.ErrEmptyKey)
+		return
+	}
+
+	if hasPrecondition(r) {
+		h.conditionalDelete(w, r, key)
+		return
+	}
+
 	err := h.store.Delete(r.Context(), key)
 	if err != nil {
 		log.Error("delete failed", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.WriteError(w, r, err)
+		return
+	}
+
+	log.Info("value deleted")
+	w.WriteHeader(http.StatusOK)
+}
+
+// conditionalDelete removes the key only if If-Match / If-None-Match hold for the current version
+func (h *Handler) conditionalDelete(w http.ResponseWriter, r *http.Request, key string) {
+	const op = "Handler.conditionalDelete"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	current, err := currentVersion(r.Context(), h.store, key)
+	if err != nil {
+		log.Error("version lookup failed", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+
+	if !preconditionHolds(r, current) {
+		log.Info("precondition failed", slog.Uint64("version", current))
+		apierror.Write(w, r, core.CodePreconditionFailed, "precondition failed")
+		return
+	}
+
+	err = h.store.CompareAndDelete(r.Context(), key, current)
+	if errors.Is(err, core.ErrVersionMismatch) {
+		log.Info("concurrent update", slog.Uint64("version", current))
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Error("compare and delete failed", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
 		return
 	}
 
@@ -89,23 +234,107 @@ func (h *Handler) GetHandler(w http.ResponseWriter, r *http.Request) {
 		slog.String("op", op),
 	)
 
-	vars := mux.Vars(r)
-	key := vars["key"]
+	item, ok := h.lookup(w, r, log)
+	if !ok {
+		return
+	}
+
+	log.Info("value retrieved")
+	serveItem(w, r, item)
+}
+
+// HeadHandler answers with the headers GetHandler would send, without the value
+func (h *Handler) HeadHandler(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.HeadHandler"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	item, ok := h.lookup(w, r, log)
+	if !ok {
+		return
+	}
+
+	log.Info("metadata retrieved")
+	serveItem(w, r, item)
+}
+
+// lookup reads the item of the {key} route variable, a failure is written to w
+func (h *Handler) lookup(w http.ResponseWriter, r *http.Request, log *slog.Logger) (core.Item, bool) {
+	key := mux.Vars(r)["key"]
 
 	if key == "" {
 		log.Warn("empty key")
-		http.Error(w, "empty key", http.StatusBadRequest)
-		return
+		apierror.WriteError(w, r, core.ErrEmptyKey)
+		return core.Item{}, false
 	}
 
-	value, err := h.store.Get(r.Context(), key)
+	item, err := h.store.Lookup(r.Context(), key)
 	if errors.Is(err, core.ErrKeyNotFound) {
+		log.Info("key not found")
+		apierror.WriteError(w, r, err)
+		return core.Item{}, false
+	}
+	if err != nil {
 		log.Error("get failed", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		apierror.WriteError(w, r, err)
+		return core.Item{}, false
 	}
+	return item, true
+}
 
-	log.Info("value retrieved")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(value))
+// readValue reads the body of a put into a buffer of its final size if the
+// length is declared, a chunked upload grows the buffer as it streams in.
+// A body above the size limit fails with *http.MaxBytesError.
+func (h *Handler) readValue(w http.ResponseWriter, r *http.Request) (core.Value, error) {
+	body, size := r.Body, r.ContentLength
+	if h.maxValueSize > 0 {
+		if size > h.maxValueSize {
+			return nil, &http.MaxBytesError{Limit: h.maxValueSize}
+		}
+		body = http.MaxBytesReader(w, r.Body, h.maxValueSize)
+	} else {
+		size = min(size, maxPreallocation)
+	}
+
+	var buf bytes.Buffer
+	if size > 0 {
+		// the spare MinRead bytes let ReadFrom see EOF without growing
+		buf.Grow(int(size) + bytes.MinRead)
+	}
+	if _, err := buf.ReadFrom(body); err != nil {
+		return nil, err
+	}
+
+	value := buf.Bytes()
+	if cap(value)-len(value) > len(value)/4+bytes.MinRead {
+		// the store keeps the bytes, do not keep the slack of a grown buffer
+		value = bytes.Clone(value)
+	}
+	return value, nil
+}
+
+// parseTTL reads the ttl from the X-TTL header or the ttl query parameter.
+// Both accept whole seconds ("30") or a Go duration ("1m30s"); zero means no ttl.
+func parseTTL(r *http.Request) (time.Duration, error) {
+	raw := r.Header.Get(ttlHeader)
+	if raw == "" {
+		raw = r.URL.Query().Get("ttl")
+	}
+	if raw == "" {
+		return 0, nil
+	}
+
+	var ttl time.Duration
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		ttl = time.Duration(seconds) * time.Second
+	} else if ttl, err = time.ParseDuration(raw); err != nil {
+		return 0, fmt.Errorf("invalid ttl %q", raw)
+	}
+
+	if ttl <= 0 {
+		return 0, core.ErrInvalidTTL
+	}
+	return ttl, nil
 }
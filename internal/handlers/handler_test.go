@@ -1,14 +1,20 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
 	"cloud/internal/core"
 	"cloud/internal/mocks"
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -87,3 +93,538 @@ func TestGetHandler(t *testing.T) {
 		t.Errorf("handler got, %v want %v", bodyValue, value)
 	}
 }
+
+func TestErrorResponses(t *testing.T) {
+	transactor := &mocks.MockTransactor{}
+	store, _ := core.NewStore(transactor, slog.Default())
+	handler := NewHandler(store, slog.Default())
+
+	serve := func(method string, next http.HandlerFunc, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/{key}", strings.NewReader("value"))
+		req = mux.SetURLVars(req, map[string]string{"key": key})
+		rr := httptest.NewRecorder()
+		next(rr, req)
+		return rr
+	}
+
+	for _, tc := range []struct {
+		name    string
+		method  string
+		handler http.HandlerFunc
+		key     string
+		failure error
+		status  int
+		code    core.Code
+	}{
+		{"MissingKey", "GET", handler.GetHandler, "missing", nil, http.StatusNotFound, core.CodeNotFound},
+		{"EmptyGet", "GET", handler.GetHandler, "", nil, http.StatusBadRequest, core.CodeInvalidArgument},
+		{"EmptyDelete", "DELETE", handler.DeleteHandler, "", nil, http.StatusBadRequest, core.CodeInvalidArgument},
+		{"JournalDown", "PUT", handler.PutHandler, "key", errors.New("disk gone"), http.StatusServiceUnavailable, core.CodeUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			transactor.SetFailure(tc.failure)
+			defer transactor.SetFailure(nil)
+
+			rr := serve(tc.method, tc.handler, tc.key)
+			if rr.Code != tc.status {
+				t.Errorf("got status %v, want %v", rr.Code, tc.status)
+			}
+
+			var resp struct {
+				Error struct {
+					Code    core.Code `json:"code"`
+					Message string    `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error.Code != tc.code || resp.Error.Message == "" {
+				t.Errorf("got %+v, want code %s", resp.Error, tc.code)
+			}
+		})
+	}
+}
+
+func TestPutHandlerWithTTL(t *testing.T) {
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	handler := NewHandler(store, slog.Default())
+
+	key := "key1"
+	value := "value1"
+
+	req, err := http.NewRequest("PUT", "/v1/{key}?ttl=60", bytes.NewBuffer([]byte(value)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"key": key})
+
+	rr := httptest.NewRecorder()
+	handler.PutHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler got, %v want %v", status, http.StatusCreated)
+	}
+
+	req, err = http.NewRequest("GET", "/v1/{key}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"key": key})
+
+	rr = httptest.NewRecorder()
+	handler.GetHandler(rr, req)
+
+	if ttl := rr.Header().Get("X-TTL"); ttl != "60" {
+		t.Errorf("handler got ttl %q, want %q", ttl, "60")
+	}
+
+	req, err = http.NewRequest("PUT", "/v1/{key}", bytes.NewBuffer([]byte(value)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-TTL", "soon")
+	req = mux.SetURLVars(req, map[string]string{"key": key})
+
+	rr = httptest.NewRecorder()
+	handler.PutHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler got, %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestMetadataHandlers(t *testing.T) {
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	handler := NewHandler(store, slog.Default())
+
+	const key = "doc"
+	request := func(method string, body string) *http.Request {
+		req := httptest.NewRequest(method, "/v1/"+key, strings.NewReader(body))
+		return mux.SetURLVars(req, map[string]string{"key": key})
+	}
+
+	req := request(http.MethodPut, `{"a":1}`)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-KV-Meta-Owner", "ops")
+	req.Header.Set("x-kv-meta-build", "42")
+	rr := httptest.NewRecorder()
+	handler.PutHandler(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got status %d", rr.Code)
+	}
+
+	get := httptest.NewRecorder()
+	handler.GetHandler(get, request(http.MethodGet, ""))
+	head := httptest.NewRecorder()
+	handler.HeadHandler(head, request(http.MethodHead, ""))
+
+	for name, rr := range map[string]*httptest.ResponseRecorder{"GET": get, "HEAD": head} {
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s got status %d", name, rr.Code)
+		}
+		header := rr.Header()
+		if ct := header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s got content type %q", name, ct)
+		}
+		if header.Get("X-KV-Meta-Owner") != "ops" || header.Get("X-KV-Meta-Build") != "42" {
+			t.Errorf("%s got headers %v", name, header)
+		}
+		if _, err := http.ParseTime(header.Get("Last-Modified")); err != nil {
+			t.Errorf("%s got last modified %q", name, header.Get("Last-Modified"))
+		}
+		if _, err := time.Parse(time.RFC3339, header.Get("X-KV-Created-At")); err != nil {
+			t.Errorf("%s got created at %q", name, header.Get("X-KV-Created-At"))
+		}
+		if header.Get("ETag") == "" || header.Get("Content-Length") != "7" {
+			t.Errorf("%s got etag %q and length %q", name, header.Get("ETag"), header.Get("Content-Length"))
+		}
+	}
+	if get.Body.String() != `{"a":1}` {
+		t.Errorf("GET got body %q", get.Body)
+	}
+	if head.Body.Len() != 0 {
+		t.Errorf("HEAD got body %q", head.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HeadHandler(rr, mux.SetURLVars(httptest.NewRequest(http.MethodHead, "/v1/missing", nil), map[string]string{"key": "missing"}))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("HEAD of a missing key got status %d", rr.Code)
+	}
+}
+
+func TestValueLimitsAndRanges(t *testing.T) {
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	store.SetSizeLimits(8, 16)
+	handler := NewHandler(store, slog.Default())
+	handler.SetSizeLimits(8, 16)
+
+	put := func(key string, body io.Reader) *httptest.ResponseRecorder {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodPut, "/v1/"+key, body), map[string]string{"key": key})
+		rr := httptest.NewRecorder()
+		handler.PutHandler(rr, req)
+		return rr
+	}
+	// a reader of unknown length is sent chunked
+	chunked := func(s string) io.Reader {
+		return io.MultiReader(strings.NewReader(s))
+	}
+
+	for _, tc := range []struct {
+		name   string
+		key    string
+		body   io.Reader
+		status int
+	}{
+		{"Declared Too Large", "big", strings.NewReader(strings.Repeat("x", 17)), http.StatusRequestEntityTooLarge},
+		{"Chunked Too Large", "big", chunked(strings.Repeat("x", 17)), http.StatusRequestEntityTooLarge},
+		{"Key Too Large", "very-long-key", strings.NewReader("x"), http.StatusRequestEntityTooLarge},
+		{"Chunked", "chunked", chunked("0123456789abcdef"), http.StatusCreated},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if rr := put(tc.key, tc.body); rr.Code != tc.status {
+				t.Errorf("got status %d, want %d: %s", rr.Code, tc.status, rr.Body)
+			}
+		})
+	}
+
+	t.Run("Range", func(t *testing.T) {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/v1/chunked", nil), map[string]string{"key": "chunked"})
+		req.Header.Set("Range", "bytes=4-7")
+		rr := httptest.NewRecorder()
+		handler.GetHandler(rr, req)
+
+		if rr.Code != http.StatusPartialContent {
+			t.Fatalf("got status %d", rr.Code)
+		}
+		if rr.Body.String() != "4567" || rr.Header().Get("Content-Range") != "bytes 4-7/16" {
+			t.Errorf("got %q with range %q", rr.Body, rr.Header().Get("Content-Range"))
+		}
+	})
+}
+
+func TestIncrHandler(t *testing.T) {
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	handler := NewHandler(store, slog.Default())
+	_ = store.Put(context.Background(), "name", "alice")
+
+	for _, tc := range []struct {
+		name   string
+		handle http.HandlerFunc
+		key    string
+		query  string
+		status int
+		body   string
+	}{
+		{"Incr", handler.IncrHandler, "counter", "", http.StatusOK, "1\n"},
+		{"Incr By", handler.IncrHandler, "counter", "?by=10", http.StatusOK, "11\n"},
+		{"Decr By", handler.DecrHandler, "counter", "?by=4", http.StatusOK, "7\n"},
+		{"Invalid By", handler.IncrHandler, "counter", "?by=ten", http.StatusBadRequest, ""},
+		{"Not An Integer", handler.IncrHandler, "name", "", http.StatusBadRequest, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/"+tc.key+"/_incr"+tc.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"key": tc.key})
+			rr := httptest.NewRecorder()
+			tc.handle(rr, req)
+
+			if rr.Code != tc.status {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tc.status, rr.Body)
+			}
+			if tc.body != "" && rr.Body.String() != tc.body {
+				t.Errorf("got body %q, want %q", rr.Body, tc.body)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			version, _ := store.Version(context.Background(), tc.key)
+			if etag := rr.Header().Get("ETag"); etag != formatETag(version) {
+				t.Errorf("got etag %q, want %q", etag, formatETag(version))
+			}
+		})
+	}
+}
+
+func TestPutHandlerStoreFull(t *testing.T) {
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	if err := store.SetLimits(core.Limits{MaxKeys: 1}); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(store, slog.Default())
+
+	for _, tc := range []struct {
+		key  string
+		want int
+	}{
+		{"key1", http.StatusCreated},
+		{"key2", http.StatusInsufficientStorage},
+	} {
+		req, err := http.NewRequest("PUT", "/v1/{key}", bytes.NewBuffer([]byte("value")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"key": tc.key})
+
+		rr := httptest.NewRecorder()
+		handler.PutHandler(rr, req)
+
+		if status := rr.Code; status != tc.want {
+			t.Errorf("handler got, %v want %v", status, tc.want)
+		}
+	}
+}
+
+func TestConditionalPutHandler(t *testing.T) {
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	handler := NewHandler(store, slog.Default())
+
+	key := "key1"
+
+	put := func(value string, header, etag string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("PUT", "/v1/{key}", bytes.NewBuffer([]byte(value)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(header, etag)
+		req = mux.SetURLVars(req, map[string]string{"key": key})
+
+		rr := httptest.NewRecorder()
+		handler.PutHandler(rr, req)
+		return rr
+	}
+
+	rr := put("value1", "If-None-Match", "*")
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler got, %v want %v", status, http.StatusCreated)
+	}
+	etag := rr.Header().Get("ETag")
+
+	if status := put("value2", "If-None-Match", "*").Code; status != http.StatusPreconditionFailed {
+		t.Errorf("handler got, %v want %v", status, http.StatusPreconditionFailed)
+	}
+
+	if status := put("value2", "If-Match", etag).Code; status != http.StatusCreated {
+		t.Errorf("handler got, %v want %v", status, http.StatusCreated)
+	}
+
+	if status := put("value3", "If-Match", etag).Code; status != http.StatusPreconditionFailed {
+		t.Errorf("handler got, %v want %v", status, http.StatusPreconditionFailed)
+	}
+
+	if storedValue, _ := store.Get(context.TODO(), key); storedValue != "value2" {
+		t.Errorf("handler got, %v want %v", storedValue, "value2")
+	}
+
+	req, err := http.NewRequest("PUT", "/v1/{key}?ttl=60", bytes.NewBuffer([]byte("value3")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", "*")
+	req = mux.SetURLVars(req, map[string]string{"key": "key2"})
+
+	rr = httptest.NewRecorder()
+	handler.PutHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler got, %v want %v", status, http.StatusCreated)
+	}
+	version, _ := store.Version(context.TODO(), "key2")
+	if etag := rr.Header().Get("ETag"); etag != formatETag(version) {
+		t.Errorf("handler got etag %q, want %q", etag, formatETag(version))
+	}
+	if ttl, _ := store.TTL(context.TODO(), "key2"); ttl <= 0 {
+		t.Errorf("handler got ttl %v, want it to expire", ttl)
+	}
+}
+
+func TestBatchHandler(t *testing.T) {
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	handler := NewHandler(store, slog.Default())
+
+	body := `{"operations":[{"op":"put","key":"a","value":"1"},{"op":"put","key":"b","value":"2"}]}`
+	req, err := http.NewRequest("POST", "/v1/_batch", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.BatchHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler got, %v want %v", status, http.StatusOK)
+	}
+	if storedValue, _ := store.Get(context.TODO(), "b"); storedValue != "2" {
+		t.Errorf("handler got, %v want %v", storedValue, "2")
+	}
+
+	body = `{"operations":[{"op":"put","key":"c","value":"3"},{"op":"rename","key":"a"}]}`
+	req, err = http.NewRequest("POST", "/v1/_batch", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.BatchHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler got, %v want %v", status, http.StatusBadRequest)
+	}
+	if _, err := store.Get(context.TODO(), "c"); err == nil {
+		t.Error("invalid batch was partially applied")
+	}
+}
+
+func TestBatchHandlerLimits(t *testing.T) {
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	handler := NewHandler(store, slog.Default())
+	handler.SetSizeLimits(8, 64)
+	handler.SetBatchLimit(256)
+
+	op := func(key string, size int) string {
+		return `{"op":"put","key":"` + key + `","value":"` + strings.Repeat("x", size) + `"}`
+	}
+
+	for _, tc := range []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"Within Limits", `{"operations":[{"op":"put","key":"a","value":"1"}]}`, http.StatusOK},
+		{"Body Above Value Limit", `{"operations":[` + op("c", 60) + `,` + op("d", 60) + `]}`, http.StatusOK},
+		{"Key Too Large", `{"operations":[{"op":"put","key":"very-long-key","value":"1"}]}`, http.StatusRequestEntityTooLarge},
+		{"Value Too Large", `{"operations":[` + op("b", 65) + `]}`, http.StatusRequestEntityTooLarge},
+		{"Body Too Large", `{"operations":[` + op("e", 60) + `,` + op("f", 60) + `,` + op("g", 60) + `,` + op("h", 60) + `]}`, http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.BatchHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/_batch", strings.NewReader(tc.body)))
+			if rr.Code != tc.status {
+				t.Errorf("got status %d, want %d: %s", rr.Code, tc.status, rr.Body)
+			}
+		})
+	}
+
+	if _, err := store.Get(context.TODO(), "very-long-key"); err == nil {
+		t.Error("batch with a key above the limit was applied")
+	}
+}
+
+func TestListHandler(t *testing.T) {
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	handler := NewHandler(store, slog.Default())
+
+	for _, key := range []string{"user:1", "user:2", "order:1"} {
+		store.Put(context.TODO(), key, "value")
+	}
+
+	req, err := http.NewRequest("GET", "/v1?prefix=user:&limit=1&values=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ListHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler got, %v want %v", status, http.StatusOK)
+	}
+
+	var page listResponse
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Key != "user:1" || page.Items[0].Value == nil || page.Cursor == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+
+	req, err = http.NewRequest("GET", "/v1?prefix=user:&limit=1&cursor="+page.Cursor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ListHandler(rr, req)
+
+	page = listResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Key != "user:2" || page.Items[0].Value != nil || page.Cursor != "" {
+		t.Errorf("unexpected second page %+v", page)
+	}
+}
+
+func TestWatchHandler(t *testing.T) {
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	handler := NewHandler(store, slog.Default())
+
+	store.Put(context.TODO(), "key1", "value1")
+
+	srv := httptest.NewServer(http.HandlerFunc(handler.WatchHandler))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/_watch?from_seq=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("handler got content type %q", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+
+	if lines[0] != "id: 1" || lines[1] != "event: put" {
+		t.Errorf("unexpected event %v", lines)
+	}
+	if !strings.Contains(lines[2], `"key":"key1"`) {
+		t.Errorf("unexpected data %q", lines[2])
+	}
+}
+
+func TestNamespaceHandlers(t *testing.T) {
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	handler := NewHandler(store, slog.Default())
+
+	serve := func(method string, next http.HandlerFunc, vars map[string]string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/ns/{namespace}", strings.NewReader(body))
+		req = mux.SetURLVars(req, vars)
+		rr := httptest.NewRecorder()
+		next(rr, req)
+		return rr
+	}
+
+	vars := map[string]string{"namespace": "team", "key": "key"}
+	if rr := serve("PUT", handler.InNamespace((*Handler).PutHandler), vars, "team"); rr.Code != http.StatusCreated {
+		t.Fatalf("put got %v, want %v", rr.Code, http.StatusCreated)
+	}
+	if rr := serve("GET", handler.InNamespace((*Handler).GetHandler), vars, ""); rr.Body.String() != "team" {
+		t.Errorf("get got %q, want %q", rr.Body.String(), "team")
+	}
+	if _, err := store.Get(context.TODO(), "key"); err == nil {
+		t.Error("namespaced put is visible in the default namespace")
+	}
+
+	invalid := map[string]string{"namespace": "a b", "key": "key"}
+	if rr := serve("GET", handler.InNamespace((*Handler).GetHandler), invalid, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid namespace got %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	if rr := serve("DELETE", handler.DropNamespaceHandler, vars, ""); rr.Code != http.StatusOK {
+		t.Fatalf("drop got %v, want %v", rr.Code, http.StatusOK)
+	}
+	team, _ := store.Namespace("team")
+	if _, err := team.Get(context.TODO(), "key"); err == nil {
+		t.Error("key survived the drop of its namespace")
+	}
+}
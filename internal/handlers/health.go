@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type journalHealthResponse struct {
+	Healthy bool       `json:"healthy"`
+	Error   string     `json:"error,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+// JournalHealthHandler reports whether the journal accepts writes, 503 while
+// it is degraded and the store rejects writes
+func (h *Handler) JournalHealthHandler(w http.ResponseWriter, r *http.Request) {
+	health := h.store.JournalHealth()
+
+	resp := journalHealthResponse{Healthy: health.Healthy}
+	if health.LastError != nil {
+		resp.Error = health.LastError.Error()
+	}
+	if !health.Since.IsZero() {
+		resp.Since = &health.Since
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !health.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
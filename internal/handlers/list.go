@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"cloud/internal/apierror"
+	"cloud/internal/core"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+type listItem struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value,omitempty"`
+}
+
+type listResponse struct {
+	Items  []listItem `json:"items"`
+	Cursor string     `json:"cursor,omitempty"`
+}
+
+// ListHandler serves GET /v1?prefix=&start=&limit=&cursor=&values=true
+func (h *Handler) ListHandler(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.ListHandler"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	query := r.URL.Query()
+
+	limit := defaultListLimit
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxListLimit {
+			log.Warn("invalid limit", slog.String("limit", raw))
+			apierror.Write(w, r, core.CodeInvalidArgument, "limit must be between 1 and "+strconv.Itoa(maxListLimit))
+			return
+		}
+		limit = n
+	}
+
+	after, err := decodeCursor(query.Get("cursor"))
+	if err != nil {
+		log.Warn("invalid cursor", slog.Any("error", err))
+		apierror.Write(w, r, core.CodeInvalidArgument, "invalid cursor")
+		return
+	}
+
+	withValues, _ := strconv.ParseBool(query.Get("values"))
+
+	res, err := h.store.List(r.Context(), core.ListOptions{
+		Prefix: query.Get("prefix"),
+		Start:  query.Get("start"),
+		After:  after,
+		Limit:  limit,
+	})
+	if err != nil {
+		log.Error("list failed", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+
+	resp := listResponse{
+		Items:  make([]listItem, 0, len(res.Items)),
+		Cursor: encodeCursor(res.Next),
+	}
+	for _, item := range res.Items {
+		li := listItem{Key: item.Key}
+		if withValues {
+			li.Value = &item.Value
+		}
+		resp.Items = append(resp.Items, li)
+	}
+
+	log.Info("keys listed", slog.Int("count", len(resp.Items)))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// the cursor is the last returned key, encoded so clients treat it as opaque
+func encodeCursor(key string) string {
+	if key == "" {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	return string(key), err
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"bytes"
+	"cloud/internal/core"
+	"cloud/internal/transaction"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// metaHeaderPrefix marks user headers stored with the value, canonical
+	// as net/http keys them
+	metaHeaderPrefix = "X-Kv-Meta-"
+	createdAtHeader  = "X-KV-Created-At"
+)
+
+// requestMetadata reads the content type and the X-KV-Meta-* headers of a
+// put, header names are stored without the prefix in lower case
+func requestMetadata(r *http.Request) transaction.Metadata {
+	meta := transaction.Metadata{ContentType: r.Header.Get("Content-Type")}
+	for name, values := range r.Header {
+		suffix, ok := strings.CutPrefix(name, metaHeaderPrefix)
+		if !ok || suffix == "" {
+			continue
+		}
+		if meta.Headers == nil {
+			meta.Headers = make(map[string]string)
+		}
+		meta.Headers[strings.ToLower(suffix)] = strings.Join(values, ", ")
+	}
+	return meta
+}
+
+// serveItem answers a GET or HEAD with the value and the headers describing
+// it. Range requests and conditional requests on ETag and Last-Modified are
+// served by http.ServeContent.
+func serveItem(w http.ResponseWriter, r *http.Request, item core.Item) {
+	header := w.Header()
+	if item.Metadata.ContentType != "" {
+		header.Set("Content-Type", item.Metadata.ContentType)
+	}
+	for name, value := range item.Metadata.Headers {
+		header.Set(metaHeaderPrefix+name, value)
+	}
+	if !item.Metadata.CreatedAt.IsZero() {
+		header.Set(createdAtHeader, item.Metadata.CreatedAt.UTC().Format(time.RFC3339))
+	}
+
+	header.Set("ETag", formatETag(item.Version))
+	if !item.ExpiresAt.IsZero() {
+		if ttl := time.Until(item.ExpiresAt); ttl > 0 {
+			header.Set(ttlHeader, strconv.FormatInt(int64(math.Ceil(ttl.Seconds())), 10))
+		}
+	}
+
+	http.ServeContent(w, r, "", item.Metadata.UpdatedAt, bytes.NewReader(item.Value))
+}
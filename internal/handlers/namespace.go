@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"cloud/internal/apierror"
+	"cloud/internal/core"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// InNamespace serves next with the store of the namespace named by the
+// {namespace} route variable, so every key route also works per namespace
+func (h *Handler) InNamespace(next func(*Handler, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["namespace"]
+
+		store, err := h.store.Namespace(name)
+		if err != nil {
+			h.log.Warn("invalid namespace", slog.String("namespace", name), slog.Any("error", err))
+			apierror.WriteError(w, r, err)
+			return
+		}
+
+		next(&Handler{
+			store:        store,
+			log:          h.log.With(slog.String("namespace", name)),
+			maxValueSize: h.maxValueSize,
+			maxKeySize:   h.maxKeySize,
+			maxBatchSize: h.maxBatchSize,
+		}, w, r)
+	}
+}
+
+// DropNamespaceHandler removes a namespace with all its keys
+func (h *Handler) DropNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.DropNamespaceHandler"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	name := mux.Vars(r)["namespace"]
+
+	err := h.store.DropNamespace(r.Context(), name)
+	if errors.Is(err, core.ErrInvalidNamespace) {
+		log.Warn("invalid namespace", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Error("drop failed", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+
+	log.Info("namespace dropped", slog.String("namespace", name))
+	w.WriteHeader(http.StatusOK)
+}
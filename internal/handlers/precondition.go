@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"cloud/internal/core"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+func hasPrecondition(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != ""
+}
+
+// currentVersion returns the version of the key, zero if it does not exist
+func currentVersion(ctx context.Context, store core.Store, key string) (uint64, error) {
+	version, err := store.Version(ctx, key)
+	if errors.Is(err, core.ErrKeyNotFound) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// preconditionHolds evaluates If-Match and If-None-Match against the
+// current version of the key, zero meaning the key does not exist
+func preconditionHolds(r *http.Request, current uint64) bool {
+	if header := r.Header.Get("If-Match"); header != "" {
+		if current == 0 || !etagListMatches(header, current) {
+			return false
+		}
+	}
+
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		if current != 0 && etagListMatches(header, current) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// etagListMatches reports whether the comma separated list contains "*" or the version
+func etagListMatches(header string, version uint64) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+
+		tag = strings.TrimPrefix(tag, "W/")
+		if tag == formatETag(version) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"cloud/internal/apierror"
+	"cloud/internal/core"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const watchHeartbeat = 15 * time.Second
+
+type watchEvent struct {
+	Sequence  uint64     `json:"sequence"`
+	Type      string     `json:"type"`
+	Namespace string     `json:"namespace,omitempty"`
+	Key       string     `json:"key"`
+	Value     []byte     `json:"value,omitempty"` // base64, values may be binary
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time        `json:"updated_at,omitempty"`
+}
+
+// WatchHandler streams changes as Server-Sent Events on GET /v1/_watch?prefix=&from_seq=N.
+// A reconnecting EventSource resumes after its Last-Event-ID. With all_namespaces=true
+// the changes of every namespace are streamed, each naming its namespace.
+func (h *Handler) WatchHandler(w http.ResponseWriter, r *http.Request) {
+	const op = "Handler.WatchHandler"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	from, err := watchStart(r)
+	if err != nil {
+		log.Warn("invalid sequence", slog.Any("error", err))
+		apierror.Write(w, r, core.CodeInvalidArgument, err.Error())
+		return
+	}
+
+	events, err := h.store.Watch(r.Context(), core.WatchOptions{
+		Prefix:        r.URL.Query().Get("prefix"),
+		From:          from,
+		AllNamespaces: r.URL.Query().Get("all_namespaces") == "true",
+	})
+	if errors.Is(err, core.ErrHistoryCompacted) {
+		log.Warn("watch history compacted", slog.Uint64("from", from))
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Error("watch failed", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// the stream outlives the server write timeout
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Error("streaming unsupported", slog.Any("error", err))
+		return
+	}
+
+	log.Info("watch started", slog.Uint64("from", from))
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Info("watch finished")
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case e, ok := <-events:
+			if !ok {
+				log.Info("watch closed by store")
+				return
+			}
+			err = writeWatchEvent(w, e)
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			log.Info("watch client gone", slog.Any("error", err))
+			return
+		}
+	}
+}
+
+func writeWatchEvent(w http.ResponseWriter, e core.WatchEvent) error {
+	we := watchEvent{
+		Sequence:  e.Sequence,
+		Type:      string(e.Type),
+		Namespace: e.Namespace,
+		Key:       e.Key,
+		Value:     []byte(e.Value),
+
+		ContentType: e.Metadata.ContentType,
+		Headers:     e.Metadata.Headers,
+	}
+	if !e.ExpiresAt.IsZero() {
+		we.ExpiresAt = &e.ExpiresAt
+	}
+	if !e.Metadata.CreatedAt.IsZero() {
+		we.CreatedAt = &e.Metadata.CreatedAt
+	}
+	if !e.Metadata.UpdatedAt.IsZero() {
+		we.UpdatedAt = &e.Metadata.UpdatedAt
+	}
+
+	data, err := json.Marshal(we)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Sequence, e.Type, data)
+	return err
+}
+
+// watchStart returns the first sequence to deliver from from_seq or Last-Event-ID
+func watchStart(r *http.Request) (uint64, error) {
+	if raw := r.URL.Query().Get("from_seq"); raw != "" {
+		from, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid from_seq %q", raw)
+		}
+		return from, nil
+	}
+
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		last, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid Last-Event-ID %q", raw)
+		}
+		return last + 1, nil
+	}
+
+	return 0, nil
+}
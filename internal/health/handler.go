@@ -0,0 +1,220 @@
+package health
+
+import (
+	"cloud/internal/config"
+	"cloud/internal/core"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// checkTimeout bounds every readiness check, probes give up after a few seconds
+const checkTimeout = 2 * time.Second
+
+// Check fails while a dependency keeps the node from serving
+type Check func(ctx context.Context) error
+
+// Source is the store the status is read from
+type Source interface {
+	Usages() map[string]core.Usage
+	LastSequence() (uint64, error)
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type probeResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type namespaceStatus struct {
+	Keys  int   `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+type statusResponse struct {
+	Status        string                     `json:"status"`
+	StartedAt     time.Time                  `json:"started_at"`
+	Uptime        string                     `json:"uptime"`
+	UptimeSeconds int64                      `json:"uptime_seconds"`
+	Keys          int                        `json:"keys"`
+	Bytes         int64                      `json:"bytes"`
+	Namespaces    map[string]namespaceStatus `json:"namespaces"`
+	LastSequence  *uint64                    `json:"last_sequence,omitempty"`
+	Checks        map[string]string          `json:"checks,omitempty"`
+	Config        summary                    `json:"config"`
+}
+
+// summary is the part of the configuration safe to show, secrets stay out
+type summary struct {
+	Env              string `json:"env"`
+	HTTPAddr         string `json:"http_addr"`
+	TLS              bool   `json:"tls"`
+	MutualTLS        bool   `json:"mutual_tls"`
+	GRPCAddr         string `json:"grpc_addr,omitempty"`
+	RESPAddr         string `json:"resp_addr,omitempty"`
+	Engine           string `json:"engine"`
+	EvictionPolicy   string `json:"eviction_policy"`
+	MaxBytes         int64  `json:"max_bytes,omitempty"`
+	MaxKeys          int    `json:"max_keys,omitempty"`
+	MaxKeySize       int    `json:"max_key_size,omitempty"`
+	MaxValueSize     int64  `json:"max_value_size,omitempty"`
+	MaxBatchSize     int64  `json:"max_batch_size,omitempty"`
+	Durability       string `json:"durability"`
+	ReplicationRole  string `json:"replication_role"`
+	LeaderURL        string `json:"leader_url,omitempty"`
+	Cluster          bool   `json:"cluster"`
+	NodeID           string `json:"node_id,omitempty"`
+	Auth             bool   `json:"auth"`
+	TracingExporter  string `json:"tracing_exporter"`
+	SnapshotInterval string `json:"snapshot_interval,omitempty"`
+}
+
+func summarize(cfg *config.Config) summary {
+	s := summary{
+		Env:             cfg.Env,
+		HTTPAddr:        cfg.HTTP.Addr,
+		TLS:             cfg.HTTP.TLS.CertFile != "",
+		MutualTLS:       cfg.HTTP.TLS.ClientCAFile != "",
+		GRPCAddr:        cfg.GRPC.Addr,
+		RESPAddr:        cfg.RESP.Addr,
+		Engine:          cfg.Store.Engine,
+		EvictionPolicy:  cfg.Store.EvictionPolicy,
+		MaxBytes:        cfg.Store.MaxBytes,
+		MaxKeys:         cfg.Store.MaxKeys,
+		MaxKeySize:      cfg.Store.MaxKeySize,
+		MaxValueSize:    cfg.Store.MaxValueSize,
+		MaxBatchSize:    cfg.Store.MaxBatchSize,
+		Durability:      cfg.Journal.Durability,
+		ReplicationRole: cfg.Replication.Role,
+		LeaderURL:       cfg.Replication.LeaderURL,
+		Cluster:         cfg.Cluster.Enabled,
+		Auth:            cfg.Auth.Enabled,
+		TracingExporter: cfg.Tracing.Exporter,
+	}
+	if cfg.Cluster.Enabled {
+		s.NodeID = cfg.Cluster.NodeID
+	}
+	if cfg.Store.SnapshotInterval > 0 {
+		s.SnapshotInterval = cfg.Store.SnapshotInterval.String()
+	}
+	return s
+}
+
+// Handler serves the kubernetes probes and the debug status of the node
+type Handler struct {
+	source  Source
+	config  summary
+	started time.Time
+	checks  []namedCheck
+	log     *slog.Logger
+}
+
+func NewHandler(source Source, cfg *config.Config, log *slog.Logger) *Handler {
+	return &Handler{
+		source:  source,
+		config:  summarize(cfg),
+		started: time.Now(),
+		log:     log,
+	}
+}
+
+// AddCheck makes readiness depend on check, it must be called before serving
+func (h *Handler) AddCheck(name string, check Check) {
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// LivenessHandler answers as long as the process serves HTTP at all
+func (h *Handler) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, probeResponse{Status: "ok"})
+}
+
+// ReadinessHandler runs every check, 503 while any of them fails
+func (h *Handler) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	const op = "health.Handler.ReadinessHandler"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	results, ready := h.run(r.Context())
+	if !ready {
+		log.Warn("node is not ready", slog.Any("checks", results))
+		writeJSON(w, http.StatusServiceUnavailable, probeResponse{Status: "unavailable", Checks: results})
+		return
+	}
+	writeJSON(w, http.StatusOK, probeResponse{Status: "ok", Checks: results})
+}
+
+// StatusHandler reports uptime, store size, journal position, the checks and
+// the configuration the node runs with
+func (h *Handler) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	const op = "health.Handler.StatusHandler"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	uptime := time.Since(h.started)
+	resp := statusResponse{
+		Status:        "ok",
+		StartedAt:     h.started,
+		Uptime:        uptime.Round(time.Second).String(),
+		UptimeSeconds: int64(uptime.Seconds()),
+		Namespaces:    make(map[string]namespaceStatus),
+		Config:        h.config,
+	}
+
+	for name, u := range h.source.Usages() {
+		resp.Keys += u.Keys
+		resp.Bytes += u.Bytes
+		resp.Namespaces[name] = namespaceStatus{Keys: u.Keys, Bytes: u.Bytes}
+	}
+
+	if seq, err := h.source.LastSequence(); err != nil {
+		log.Warn("read last sequence failed", slog.Any("error", err))
+	} else {
+		resp.LastSequence = &seq
+	}
+
+	results, ready := h.run(r.Context())
+	resp.Checks = results
+	if !ready {
+		resp.Status = "unavailable"
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// run returns the outcome of every check by name and whether all passed
+func (h *Handler) run(ctx context.Context) (map[string]string, bool) {
+	if len(h.checks) == 0 {
+		return nil, true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	results := make(map[string]string, len(h.checks))
+	ready := true
+	for _, c := range h.checks {
+		if err := c.check(ctx); err != nil {
+			results[c.name] = err.Error()
+			ready = false
+			continue
+		}
+		results[c.name] = "ok"
+	}
+	return results, ready
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"cloud/internal/config"
+	"cloud/internal/core"
+	"cloud/internal/mocks"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	transactor := &mocks.MockTransactor{}
+	store, err := core.NewStore(transactor, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Put(ctx, "a", "1")
+	_ = store.Put(ctx, "b", "22")
+	team, _ := store.Namespace("team")
+	_ = team.Put(ctx, "c", "3")
+
+	cfg := &config.Config{Env: "test", Auth: config.AuthConfig{Tokens: []config.TokenConfig{{Token: "secret"}}}}
+	h := NewHandler(store, cfg, slog.Default())
+	h.AddCheck("journal", transactor.Ping)
+
+	serve := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rr
+	}
+
+	t.Run("Liveness", func(t *testing.T) {
+		transactor.SetFailure(errors.New("disk gone"))
+		defer transactor.SetFailure(nil)
+
+		if rr := serve(h.LivenessHandler); rr.Code != http.StatusOK {
+			t.Errorf("got status %d", rr.Code)
+		}
+	})
+
+	t.Run("Readiness", func(t *testing.T) {
+		rr := serve(h.ReadinessHandler)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+		}
+
+		transactor.SetFailure(errors.New("disk gone"))
+		defer transactor.SetFailure(nil)
+
+		rr = serve(h.ReadinessHandler)
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Fatalf("got status %d", rr.Code)
+		}
+		var resp probeResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != "unavailable" || resp.Checks["journal"] != "disk gone" {
+			t.Errorf("got %+v", resp)
+		}
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		rr := serve(h.StatusHandler)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d", rr.Code)
+		}
+		if body := rr.Body.String(); strings.Contains(body, "secret") {
+			t.Errorf("status leaks the token: %s", body)
+		}
+
+		var resp statusResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Keys != 3 || resp.Namespaces["team"].Keys != 1 {
+			t.Errorf("got keys %d, namespaces %+v", resp.Keys, resp.Namespaces)
+		}
+		if resp.LastSequence == nil || *resp.LastSequence != 3 {
+			t.Errorf("got last sequence %v", resp.LastSequence)
+		}
+		if resp.Config.Env != "test" || resp.Checks["journal"] != "ok" {
+			t.Errorf("got %+v", resp)
+		}
+	})
+}
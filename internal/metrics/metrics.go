@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kvstore"
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by method, route template and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by method, route template and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	journalWriteDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "journal",
+		Name:      "write_duration_seconds",
+		Help:      "Latency of journal commits, a commit may carry several events.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+	})
+
+	journalWriteErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "journal",
+		Name:      "write_errors_total",
+		Help:      "Journal commits that failed.",
+	})
+
+	journalQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "journal",
+		Name:      "queue_depth",
+		Help:      "Write requests waiting for the journal writer.",
+	})
+
+	journalReplayDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "journal",
+		Name:      "replay_duration_seconds",
+		Help:      "Time the last journal replay on startup took.",
+	})
+)
+
+// ObserveRequest records a served request, route is the matched route
+// template so the label values stay bounded
+func ObserveRequest(method, route string, status int, duration time.Duration) {
+	code := strconv.Itoa(status)
+	httpRequests.WithLabelValues(method, route, code).Inc()
+	httpDuration.WithLabelValues(method, route, code).Observe(duration.Seconds())
+}
+
+// ObserveJournalWrite records a journal commit and whether it failed
+func ObserveJournalWrite(duration time.Duration, err error) {
+	journalWriteDuration.Observe(duration.Seconds())
+	if err != nil {
+		journalWriteErrors.Inc()
+	}
+}
+
+// SetJournalQueueDepth records how many write requests wait for the writer
+func SetJournalQueueDepth(depth int) {
+	journalQueueDepth.Set(float64(depth))
+}
+
+// ObserveJournalReplay records how long reading the journal on startup took
+func ObserveJournalReplay(duration time.Duration) {
+	journalReplayDuration.Set(duration.Seconds())
+}
+
+// NewRegistry registers the metrics of this package, the go runtime and
+// process stats and the given collectors
+func NewRegistry(extra ...prometheus.Collector) (*prometheus.Registry, error) {
+	reg := prometheus.NewRegistry()
+
+	all := []prometheus.Collector{
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		journalWriteDuration,
+		journalWriteErrors,
+		journalQueueDepth,
+		journalReplayDuration,
+	}
+	for _, c := range append(all, extra...) {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return reg, nil
+}
+
+// Handler serves the metrics of reg in the prometheus text format
+func Handler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
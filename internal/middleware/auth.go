@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"bytes"
+	"cloud/internal/apierror"
+	"cloud/internal/auth"
+	"cloud/internal/core"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Authenticate resolves the bearer token or client certificate of every
+// request to a principal, requests to other than the public paths are
+// rejected without a valid one
+func Authenticate(guard *auth.Guard, baseLog *slog.Logger, public ...string) func(http.Handler) http.Handler {
+	const op = "http.authenticate"
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(public, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := baseLog.With(slog.String("op", op))
+
+			principal, err := authenticate(guard, r)
+			if err != nil {
+				logger.Warn("authentication failed",
+					slog.String("path", r.URL.Path),
+					slog.String("remote", r.RemoteAddr),
+					slog.Any("error", err),
+				)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="kvstore"`)
+				apierror.Write(w, r, apierror.CodeUnauthenticated, "missing or invalid credentials")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// authenticate prefers the bearer token and falls back to the verified client
+// certificate of a mutual tls connection
+func authenticate(guard *auth.Guard, r *http.Request) (string, error) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token = strings.TrimSpace(token); token == "" && auth.Identity(r.TLS) != "" {
+		return guard.CertificatePrincipal(r.TLS.PeerCertificates[0])
+	}
+	return guard.Authenticate(token)
+}
+
+// Authorize checks the principal set by Authenticate against the ACL. It runs
+// on the matched route: key routes need the permission of their method on the
+// key, lists and watches read permission on their prefix, batches the
+// permission of every operation and any other route admin permission. A batch
+// body above maxBodySize is rejected with 413, the body is buffered to read its
+// operations so there is no unlimited setting.
+func Authorize(guard *auth.Guard, maxBodySize int64, baseLog *slog.Logger, public ...string) mux.MiddlewareFunc {
+	const op = "http.authorize"
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(public, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := baseLog.With(slog.String("op", op))
+
+			principal, _ := auth.PrincipalFrom(r.Context())
+			grants, err := requiredGrants(w, r, maxBodySize)
+			if err != nil {
+				logger.Warn("unreadable request", slog.Any("error", err))
+				if apierror.CodeOf(err) == core.CodeTooLarge {
+					apierror.WriteError(w, r, err)
+					return
+				}
+				apierror.Write(w, r, core.CodeInvalidArgument, err.Error())
+				return
+			}
+
+			for _, g := range grants {
+				if !guard.Allowed(principal, g.perm, g.namespace, g.key) {
+					logger.Warn("access denied",
+						slog.String("principal", principal),
+						slog.String("permission", g.perm.String()),
+						slog.String("namespace", g.namespace),
+						slog.String("key", g.key),
+					)
+					apierror.Write(w, r, apierror.CodePermissionDenied, "missing "+g.perm.String()+" permission")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// grant is a permission a request needs on a key or a key prefix
+type grant struct {
+	perm      auth.Permission
+	namespace string
+	key       string
+}
+
+// requiredGrants maps the matched route of r to the grants it needs
+func requiredGrants(w http.ResponseWriter, r *http.Request, maxBodySize int64) ([]grant, error) {
+	var (
+		vars      = mux.Vars(r)
+		namespace = vars["namespace"]
+		template  string
+	)
+	if route := mux.CurrentRoute(r); route != nil {
+		template, _ = route.GetPathTemplate()
+	}
+
+	if key, ok := vars["key"]; ok {
+		return []grant{{perm: methodPermission(r.Method), namespace: namespace, key: key}}, nil
+	}
+
+	switch {
+	case strings.HasSuffix(template, "/_batch"):
+		return batchGrants(w, r, namespace, maxBodySize)
+	case strings.HasSuffix(template, "/_watch"):
+		if r.URL.Query().Get("all_namespaces") == "true" {
+			namespace = auth.AnyNamespace
+		}
+		return []grant{{perm: auth.PermRead, namespace: namespace, key: r.URL.Query().Get("prefix")}}, nil
+	case template == "/v1" || template == "/v1/ns/{namespace}":
+		if r.Method == http.MethodDelete {
+			// dropping a namespace deletes every key in it
+			return []grant{{perm: auth.PermDelete, namespace: namespace}}, nil
+		}
+		return []grant{{perm: auth.PermRead, namespace: namespace, key: r.URL.Query().Get("prefix")}}, nil
+	default:
+		return []grant{{perm: auth.PermAdmin, namespace: auth.AnyNamespace}}, nil
+	}
+}
+
+func methodPermission(method string) auth.Permission {
+	switch method {
+	case http.MethodPut, http.MethodPost, http.MethodPatch:
+		return auth.PermWrite
+	case http.MethodDelete:
+		return auth.PermDelete
+	default:
+		return auth.PermRead
+	}
+}
+
+// batchGrants reads the operations of a batch body of at most maxBodySize bytes
+// and puts the body back for the handler
+func batchGrants(w http.ResponseWriter, r *http.Request, namespace string, maxBodySize int64) ([]grant, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, max(maxBodySize, 0)))
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var batch struct {
+		Operations []struct {
+			Op  string `json:"op"`
+			Key string `json:"key"`
+		} `json:"operations"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, err
+	}
+
+	grants := make([]grant, 0, len(batch.Operations))
+	for _, o := range batch.Operations {
+		perm := auth.PermWrite
+		if o.Op == "delete" {
+			perm = auth.PermDelete
+		}
+		grants = append(grants, grant{perm: perm, namespace: namespace, key: o.Key})
+	}
+	return grants, nil
+}
@@ -1,10 +1,17 @@
 package middleware
 
 import (
+	"cloud/internal/apierror"
+	"cloud/internal/auth"
+	"cloud/internal/metrics"
 	"net/http"
 	"time"
 
 	"log/slog"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func Logging(baseLog *slog.Logger) func(http.Handler) http.Handler {
@@ -12,19 +19,36 @@ func Logging(baseLog *slog.Logger) func(http.Handler) http.Handler {
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			logger := baseLog.With(slog.String("op", op))
+			logger := baseLog.With(
+				slog.String("op", op),
+				slog.String("request_id", apierror.RequestID(r.Context())),
+			)
 
 			start := time.Now()
-			logger.Info("request started",
+			attrs := []any{
 				slog.String("method", r.Method),
 				slog.String("path", r.URL.Path),
 				slog.String("remote", r.RemoteAddr),
-			)
+			}
+			if client := auth.Identity(r.TLS); client != "" {
+				attrs = append(attrs, slog.String("client", client))
+			}
+			logger.Info("request started", attrs...)
 
 			ww := &responseWriter{ResponseWriter: w}
 			next.ServeHTTP(ww, r)
 
+			if ww.status == 0 {
+				ww.status = http.StatusOK
+			}
+			route := ww.route
+			if route == "" {
+				// rejected before routing or matching no route
+				route = "none"
+			}
+
 			duration := time.Since(start)
+			metrics.ObserveRequest(r.Method, route, ww.status, duration)
 			logger.Info("request finished",
 				slog.Int("status", ww.status),
 				slog.Duration("duration", duration),
@@ -36,9 +60,50 @@ func Logging(baseLog *slog.Logger) func(http.Handler) http.Handler {
 type responseWriter struct {
 	http.ResponseWriter
 	status int
+	route  string // template of the matched route, set by Route
+}
+
+// Route records the template of the matched route for the request metrics
+// and names the request span after it, it runs as router middleware below
+// Logging and Tracing
+func Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var template string
+		if route := mux.CurrentRoute(r); route != nil {
+			template, _ = route.GetPathTemplate()
+		}
+		if rw, ok := findResponseWriter(w); ok {
+			rw.route = template
+		}
+		if template != "" {
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + template)
+			span.SetAttributes(attribute.String("http.route", template))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// findResponseWriter looks for the writer of Logging beneath the wrappers of w
+func findResponseWriter(w http.ResponseWriter) (*responseWriter, bool) {
+	for {
+		if rw, ok := w.(*responseWriter); ok {
+			return rw, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil, false
+		}
+		w = u.Unwrap()
+	}
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
 	rw.status = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap lets http.ResponseController reach Flush and deadlines of the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"cloud/internal/apierror"
+	"cloud/internal/core"
 	"log/slog"
 	"net/http"
 )
@@ -19,7 +21,7 @@ func Recover(baseLog *slog.Logger) func(http.Handler) http.Handler {
 						slog.String("method", r.Method),
 						slog.String("path", r.URL.Path),
 					)
-					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					apierror.Write(w, r, core.CodeInternal, http.StatusText(http.StatusInternalServerError))
 				}
 			}()
 
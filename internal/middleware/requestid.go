@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"cloud/internal/apierror"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// maxRequestIDLength bounds ids taken over from clients, they end up in logs
+const maxRequestIDLength = 128
+
+// RequestID gives every request an id, kept from the X-Request-ID header if a
+// proxy set a sane one, and returns it in the same header
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(apierror.RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(apierror.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(apierror.WithRequestID(r.Context(), id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
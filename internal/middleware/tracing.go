@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing runs the request in a server span continuing the W3C trace context
+// of its headers, if any. It goes beneath Logging, whose writer holds the
+// status, and Route names the span after the matched route. The path is left
+// out, it holds the key.
+func Tracing() func(http.Handler) http.Handler {
+	tracer := otel.Tracer("cloud/internal/middleware")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("client.address", r.RemoteAddr),
+				),
+			)
+			defer span.End()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+
+			if rw, ok := findResponseWriter(w); ok {
+				status := rw.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				span.SetAttributes(attribute.Int("http.response.status_code", status))
+				if status >= http.StatusInternalServerError {
+					span.SetStatus(codes.Error, http.StatusText(status))
+				}
+			}
+		})
+	}
+}
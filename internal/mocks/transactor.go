@@ -3,18 +3,56 @@ package mocks
 import (
 	"cloud/internal/transaction"
 	"context"
+	"sync"
 )
 
-type MockTransactor struct{}
+// MockTransactor keeps the journal in memory. The zero value is ready to use.
+type MockTransactor struct {
+	mu      sync.Mutex
+	events  []transaction.Event
+	subs    []chan transaction.Event
+	failure error
+}
 
-func (t *MockTransactor) WritePut(context.Context, string, string) error {
-	return nil
+// SetFailure makes every write fail with err and the journal report itself
+// unhealthy, nil heals it
+func (t *MockTransactor) SetFailure(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.failure = err
+}
+
+func (t *MockTransactor) Health() transaction.Health {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return transaction.Health{Healthy: t.failure == nil, LastError: t.failure}
+}
+
+func (t *MockTransactor) Ping(context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.failure
 }
 
-func (t *MockTransactor) WriteDelete(context.Context, string) error {
+// Append journals the events at once, there is nothing left to wait for
+func (t *MockTransactor) Append(_ context.Context, events []transaction.Event) (func() error, error) {
+	if err := t.append(events...); err != nil {
+		return nil, err
+	}
+	return func() error { return nil }, nil
+}
+
+func (t *MockTransactor) WriteSnapshot(context.Context, transaction.Snapshot) error {
 	return nil
 }
 
+func (t *MockTransactor) ReadSnapshot() (transaction.Snapshot, error) {
+	return transaction.Snapshot{}, nil
+}
+
 func (t *MockTransactor) Close() error {
 	return nil
 }
@@ -26,3 +64,78 @@ func (t *MockTransactor) ReadEvents() (<-chan transaction.Event, <-chan error) {
 	close(outEvent)
 	return outEvent, outError
 }
+
+func (t *MockTransactor) ReplayEvents(_ context.Context, after uint64) (<-chan transaction.Event, <-chan error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	outEvent := make(chan transaction.Event, len(t.events))
+	outError := make(chan error, 1)
+	for _, e := range t.events {
+		if e.Sequence > after {
+			outEvent <- e
+		}
+	}
+	close(outError)
+	close(outEvent)
+	return outEvent, outError
+}
+
+func (t *MockTransactor) LastSequence() (uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.events) == 0 {
+		return 0, nil
+	}
+	return t.events[len(t.events)-1].Sequence, nil
+}
+
+func (t *MockTransactor) Subscribe(buffer int) (<-chan transaction.Event, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch := make(chan transaction.Event, buffer)
+	t.subs = append(t.subs, ch)
+
+	cancel := func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		for i, sub := range t.subs {
+			if sub == ch {
+				t.subs = append(t.subs[:i], t.subs[i+1:]...)
+				close(ch)
+				return
+			}
+		}
+	}
+	return ch, cancel
+}
+
+func (t *MockTransactor) append(events ...transaction.Event) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.failure != nil {
+		return t.failure
+	}
+
+	for _, e := range events {
+		if e.Sequence == 0 {
+			e.Sequence = 1
+			if n := len(t.events); n > 0 {
+				e.Sequence = t.events[n-1].Sequence + 1
+			}
+		}
+		t.events = append(t.events, e)
+
+		for _, sub := range t.subs {
+			select {
+			case sub <- e:
+			default:
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+package replication
+
+import (
+	"bufio"
+	"cloud/internal/apierror"
+	"cloud/internal/config"
+	"cloud/internal/transaction"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotBootstrapped  = errors.New("follower has not loaded a leader snapshot yet")
+	errHistoryCompacted = errors.New("leader compacted the requested history")
+)
+
+// Replica is the follower side store events are applied to
+type Replica interface {
+	ApplySnapshot(snapshot transaction.Snapshot) error
+	Apply(event transaction.Event) error
+}
+
+type Status struct {
+	Leader          string
+	Connected       bool
+	AppliedSequence uint64
+	LeaderSequence  uint64
+	LastContact     time.Time
+}
+
+// Lag is the number of leader journal events not applied yet
+func (s Status) Lag() uint64 {
+	if s.LeaderSequence <= s.AppliedSequence {
+		return 0
+	}
+	return s.LeaderSequence - s.AppliedSequence
+}
+
+// Follower bootstraps from a leader snapshot and then tails the leader journal
+// through its watch stream, applying every event to the replica
+type Follower struct {
+	leader  string
+	replica Replica
+	client  *http.Client
+	cfg     config.ReplicationConfig
+	log     *slog.Logger
+
+	mu           sync.RWMutex
+	status       Status
+	bootstrapped bool // a leader snapshot was applied once
+}
+
+func NewFollower(cfg config.ReplicationConfig, replica Replica, log *slog.Logger) (*Follower, error) {
+	leader := strings.TrimRight(cfg.LeaderURL, "/")
+
+	client := &http.Client{}
+	if cfg.CAFile != "" || cfg.CertFile != "" {
+		tlsConfig, err := clientTLS(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("replication tls: %w", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+
+	return &Follower{
+		leader:  leader,
+		replica: replica,
+		client:  client,
+		cfg:     cfg,
+		log:     log,
+		status:  Status{Leader: leader},
+	}, nil
+}
+
+// clientTLS trusts the configured CA and presents the client certificate of a
+// mutual tls leader
+func clientTLS(cfg config.ReplicationConfig) (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+func (f *Follower) Status() Status {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.status
+}
+
+// Ready fails until the first leader snapshot is applied, the replica is
+// empty or stale before
+func (f *Follower) Ready() error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.bootstrapped {
+		return ErrNotBootstrapped
+	}
+	return nil
+}
+
+// Run replicates until ctx is done, reconnecting after failures and
+// bootstrapping again whenever the leader no longer has the needed history
+func (f *Follower) Run(ctx context.Context) {
+	const op = "replication.Follower.Run"
+
+	log := f.log.With(
+		slog.String("op", op),
+		slog.String("leader", f.leader),
+	)
+
+	go f.pollLeader(ctx)
+
+	bootstrap := true
+	for ctx.Err() == nil {
+		if bootstrap {
+			if err := f.bootstrap(ctx); err != nil {
+				log.Error("bootstrap failed", slog.Any("error", err))
+				f.wait(ctx)
+				continue
+			}
+			bootstrap = false
+		}
+
+		err := f.tail(ctx)
+		f.setConnected(false)
+
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, errHistoryCompacted):
+			log.Warn("leader history compacted, bootstrapping again")
+			bootstrap = true
+		default:
+			log.Error("journal tail interrupted", slog.Any("error", err))
+			f.wait(ctx)
+		}
+	}
+}
+
+func (f *Follower) bootstrap(ctx context.Context) error {
+	resp, err := f.get(ctx, snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("snapshot request failed: %s", resp.Status)
+	}
+
+	var msg snapshotMessage
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return fmt.Errorf("snapshot decoding failure: %w", err)
+	}
+
+	snapshot := transaction.Snapshot{
+		Sequence: msg.Sequence,
+		Entries:  make([]transaction.Event, 0, len(msg.Entries)),
+	}
+	for _, e := range msg.Entries {
+		entry := transaction.Event{
+			Sequence:  e.Version,
+			EventType: transaction.EventPut,
+			Namespace: e.Namespace,
+			Key:       e.Key,
+			Value:     string(e.Value),
+			Metadata:  metadata(e.ContentType, e.Headers, e.CreatedAt, e.UpdatedAt),
+		}
+		if e.ExpiresAt != nil {
+			entry.ExpiresAt = *e.ExpiresAt
+		}
+		snapshot.Entries = append(snapshot.Entries, entry)
+	}
+
+	if err := f.replica.ApplySnapshot(snapshot); err != nil {
+		// the replica holds a partial state until a snapshot applies
+		f.mu.Lock()
+		f.bootstrapped = false
+		f.mu.Unlock()
+		return err
+	}
+
+	f.mu.Lock()
+	f.status.AppliedSequence = snapshot.Sequence
+	f.status.LeaderSequence = max(f.status.LeaderSequence, snapshot.Sequence)
+	f.status.LastContact = time.Now()
+	f.bootstrapped = true
+	f.mu.Unlock()
+
+	return nil
+}
+
+// tail streams the leader journal after the applied sequence until it fails
+func (f *Follower) tail(ctx context.Context) error {
+	from := f.Status().AppliedSequence + 1
+
+	resp, err := f.get(ctx, watchPath+"?all_namespaces=true&from_seq="+strconv.FormatUint(from, 10))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return errHistoryCompacted
+	default:
+		return fmt.Errorf("watch request failed: %s", resp.Status)
+	}
+
+	f.setConnected(true)
+	f.log.Info("tailing leader journal", slog.Uint64("from", from))
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		f.mu.Lock()
+		f.status.LastContact = time.Now()
+		f.mu.Unlock()
+
+		// only data lines matter, the sequence is repeated inside the payload
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+
+		event, err := decodeWatchEvent(data)
+		if err != nil {
+			return err
+		}
+		if err := f.replica.Apply(event); err != nil {
+			return fmt.Errorf("apply event %d: %w", event.Sequence, err)
+		}
+
+		f.mu.Lock()
+		f.status.AppliedSequence = event.Sequence
+		f.status.LeaderSequence = max(f.status.LeaderSequence, event.Sequence)
+		f.mu.Unlock()
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("watch stream failure: %w", err)
+	}
+	return errors.New("leader closed the watch stream")
+}
+
+// pollLeader refreshes the leader sequence used to compute the lag
+func (f *Follower) pollLeader(ctx context.Context) {
+	ticker := time.NewTicker(f.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		resp, err := f.get(ctx, "/v1/_replication/status")
+		if err != nil {
+			f.log.Warn("leader status unavailable", slog.Any("error", err))
+			continue
+		}
+
+		var st statusResponse
+		err = json.NewDecoder(resp.Body).Decode(&st)
+		_ = resp.Body.Close()
+		if err != nil {
+			f.log.Warn("leader status decoding failure", slog.Any("error", err))
+			continue
+		}
+
+		f.mu.Lock()
+		f.status.LeaderSequence = max(f.status.LeaderSequence, st.Sequence)
+		f.mu.Unlock()
+	}
+}
+
+func (f *Follower) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.leader+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.cfg.Token)
+	}
+	return f.client.Do(req)
+}
+
+// redirect sends the client to the same request on the leader
+func (f *Follower) redirect(w http.ResponseWriter, r *http.Request) {
+	target, err := url.JoinPath(f.leader, r.URL.Path)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+}
+
+func (f *Follower) setConnected(connected bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.status.Connected = connected
+}
+
+func (f *Follower) wait(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(f.cfg.RetryInterval):
+	}
+}
+
+type watchMessage struct {
+	Sequence  uint64     `json:"sequence"`
+	Type      string     `json:"type"`
+	Namespace string     `json:"namespace"`
+	Key       string     `json:"key"`
+	Value     []byte     `json:"value"`
+	ExpiresAt *time.Time `json:"expires_at"`
+
+	ContentType string            `json:"content_type"`
+	Headers     map[string]string `json:"headers"`
+	CreatedAt   *time.Time        `json:"created_at"`
+	UpdatedAt   *time.Time        `json:"updated_at"`
+}
+
+func decodeWatchEvent(data string) (transaction.Event, error) {
+	var msg watchMessage
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		return transaction.Event{}, fmt.Errorf("watch event decoding failure: %w", err)
+	}
+
+	event := transaction.Event{
+		Sequence:  msg.Sequence,
+		Namespace: msg.Namespace,
+		Key:       msg.Key,
+		Value:     string(msg.Value),
+		Metadata:  metadata(msg.ContentType, msg.Headers, msg.CreatedAt, msg.UpdatedAt),
+	}
+	if msg.ExpiresAt != nil {
+		event.ExpiresAt = *msg.ExpiresAt
+	}
+
+	switch msg.Type {
+	case "put":
+		event.EventType = transaction.EventPut
+	case "delete":
+		event.EventType = transaction.EventDelete
+	case "expire":
+		event.EventType = transaction.EventExpire
+	case "drop":
+		event.EventType = transaction.EventDrop
+	case "incr":
+		event.EventType = transaction.EventIncr
+	default:
+		return transaction.Event{}, fmt.Errorf("unknown watch event type %q", msg.Type)
+	}
+	return event, nil
+}
+
+// metadata assembles the metadata of an entry sent by the leader, absent
+// timestamps stay zero
+func metadata(contentType string, headers map[string]string, createdAt, updatedAt *time.Time) transaction.Metadata {
+	meta := transaction.Metadata{ContentType: contentType, Headers: headers}
+	if createdAt != nil {
+		meta.CreatedAt = *createdAt
+	}
+	if updatedAt != nil {
+		meta.UpdatedAt = *updatedAt
+	}
+	return meta
+}
@@ -0,0 +1,172 @@
+package replication
+
+import (
+	"cloud/internal/config"
+	"cloud/internal/core"
+	"cloud/internal/handlers"
+	"cloud/internal/mocks"
+	"cloud/internal/transaction"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFollowerReplicates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leader, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	// binary values survive the snapshot and the watch stream
+	_ = leader.Put(ctx, "before", "snapshot\xff\x00")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(watchPath, handlers.NewHandler(leader, slog.Default()).WatchHandler)
+	mux.HandleFunc(snapshotPath, NewHandler(leader, nil, slog.Default()).SnapshotHandler)
+	mux.HandleFunc("/v1/_replication/status", NewHandler(leader, nil, slog.Default()).StatusHandler)
+	srv := httptest.NewServer(mux)
+	defer func() {
+		cancel() // the follower holds a watch stream open
+		srv.Close()
+	}()
+
+	replica, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	replica.SetReadOnly(true)
+
+	follower, err := NewFollower(config.ReplicationConfig{
+		LeaderURL:     srv.URL,
+		RetryInterval: 10 * time.Millisecond,
+		PollInterval:  10 * time.Millisecond,
+	}, replica, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go follower.Run(ctx)
+
+	waitFor(t, func() bool {
+		value, err := replica.Get(ctx, "before")
+		return err == nil && value == "snapshot\xff\x00"
+	})
+
+	_ = leader.Put(ctx, "after", "tail\xfe\x00")
+	_ = leader.Delete(ctx, "before")
+
+	waitFor(t, func() bool {
+		value, err := replica.Get(ctx, "after")
+		_, gone := replica.Get(ctx, "before")
+		return err == nil && value == "tail\xfe\x00" && gone != nil
+	})
+
+	waitFor(t, func() bool {
+		return follower.Status().Lag() == 0 && follower.Status().LeaderSequence == 3
+	})
+
+	if err := replica.Put(ctx, "direct", "write"); err != core.ErrReadOnly {
+		t.Errorf("expected error %v, got %v", core.ErrReadOnly, err)
+	}
+}
+
+func TestForwardToLeader(t *testing.T) {
+	follower, _ := NewFollower(config.ReplicationConfig{LeaderURL: "http://leader:8080"}, nil, slog.Default())
+	h := NewHandler(nil, follower, slog.Default())
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPut, "/v1/key?ttl=5", nil)
+	rr := httptest.NewRecorder()
+	h.ForwardToLeader(next).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+	if loc := rr.Header().Get("Location"); loc != "http://leader:8080/v1/key?ttl=5" {
+		t.Errorf("got location %q", loc)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/key", nil)
+	rr = httptest.NewRecorder()
+	h.ForwardToLeader(next).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestFollowerRetriesFailedSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leader, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	_ = leader.Put(ctx, "key", "value")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(watchPath, handlers.NewHandler(leader, slog.Default()).WatchHandler)
+	mux.HandleFunc(snapshotPath, NewHandler(leader, nil, slog.Default()).SnapshotHandler)
+	srv := httptest.NewServer(mux)
+	defer func() {
+		cancel()
+		srv.Close()
+	}()
+
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	replica := &failingReplica{Replica: store, failures: 2}
+
+	follower, err := NewFollower(config.ReplicationConfig{
+		LeaderURL:     srv.URL,
+		RetryInterval: 10 * time.Millisecond,
+		PollInterval:  time.Hour,
+	}, replica, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go follower.Run(ctx)
+
+	waitFor(t, func() bool {
+		return replica.attempts.Load() >= 1
+	})
+	if replica.attempts.Load() <= 2 && follower.Ready() == nil {
+		t.Error("follower is ready on top of a failed snapshot")
+	}
+
+	waitFor(t, func() bool {
+		return follower.Ready() == nil
+	})
+	if replica.attempts.Load() != 3 {
+		t.Errorf("got %d snapshot attempts, want 3", replica.attempts.Load())
+	}
+	if value, err := store.Get(ctx, "key"); err != nil || value != "value" {
+		t.Errorf("got %q, %v after the retried snapshot", value, err)
+	}
+}
+
+// failingReplica fails the first snapshots it is given
+type failingReplica struct {
+	Replica
+	failures int32
+	attempts atomic.Int32
+}
+
+func (r *failingReplica) ApplySnapshot(snapshot transaction.Snapshot) error {
+	if r.attempts.Add(1) <= r.failures {
+		return errors.New("disk full")
+	}
+	return r.Replica.ApplySnapshot(snapshot)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
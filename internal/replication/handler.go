@@ -0,0 +1,175 @@
+package replication
+
+import (
+	"cloud/internal/apierror"
+	"cloud/internal/transaction"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	RoleLeader   = "leader"
+	RoleFollower = "follower"
+
+	snapshotPath = "/v1/_replication/snapshot"
+	watchPath    = "/v1/_watch"
+)
+
+// Source is the leader side store a follower bootstraps from
+type Source interface {
+	ExportSnapshot(ctx context.Context) (transaction.Snapshot, error)
+	LastSequence() (uint64, error)
+}
+
+type snapshotMessage struct {
+	Sequence uint64         `json:"sequence"`
+	Entries  []entryMessage `json:"entries"`
+}
+
+type entryMessage struct {
+	Namespace string     `json:"namespace,omitempty"`
+	Key       string     `json:"key"`
+	Value     []byte     `json:"value"` // base64, values may be binary
+	Version   uint64     `json:"version"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time        `json:"updated_at,omitempty"`
+}
+
+type statusResponse struct {
+	Role            string     `json:"role"`
+	Sequence        uint64     `json:"sequence,omitempty"`
+	Leader          string     `json:"leader,omitempty"`
+	Connected       bool       `json:"connected"`
+	AppliedSequence uint64     `json:"applied_sequence,omitempty"`
+	LeaderSequence  uint64     `json:"leader_sequence,omitempty"`
+	Lag             uint64     `json:"lag"`
+	LastContact     *time.Time `json:"last_contact,omitempty"`
+}
+
+type Handler struct {
+	source   Source
+	follower *Follower // nil on the leader
+	log      *slog.Logger
+}
+
+func NewHandler(source Source, follower *Follower, log *slog.Logger) *Handler {
+	return &Handler{
+		source:   source,
+		follower: follower,
+		log:      log,
+	}
+}
+
+// SnapshotHandler serves the full state with its journal sequence for followers to bootstrap
+func (h *Handler) SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	const op = "replication.Handler.SnapshotHandler"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	if h.follower != nil {
+		h.follower.redirect(w, r)
+		return
+	}
+
+	snapshot, err := h.source.ExportSnapshot(r.Context())
+	if err != nil {
+		log.Error("export snapshot failed", slog.Any("error", err))
+		apierror.WriteError(w, r, err)
+		return
+	}
+
+	msg := snapshotMessage{
+		Sequence: snapshot.Sequence,
+		Entries:  make([]entryMessage, 0, len(snapshot.Entries)),
+	}
+	for _, e := range snapshot.Entries {
+		em := entryMessage{
+			Namespace:   e.Namespace,
+			Key:         e.Key,
+			Value:       []byte(e.Value),
+			Version:     e.Sequence,
+			ContentType: e.Metadata.ContentType,
+			Headers:     e.Metadata.Headers,
+		}
+		if !e.ExpiresAt.IsZero() {
+			em.ExpiresAt = &e.ExpiresAt
+		}
+		if !e.Metadata.CreatedAt.IsZero() {
+			em.CreatedAt = &e.Metadata.CreatedAt
+		}
+		if !e.Metadata.UpdatedAt.IsZero() {
+			em.UpdatedAt = &e.Metadata.UpdatedAt
+		}
+		msg.Entries = append(msg.Entries, em)
+	}
+
+	log.Info("snapshot exported", slog.Uint64("sequence", msg.Sequence), slog.Int("keys", len(msg.Entries)))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(msg)
+}
+
+// StatusHandler reports the role of the instance and, on a follower, its replication lag
+func (h *Handler) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	const op = "replication.Handler.StatusHandler"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	var resp statusResponse
+
+	if h.follower != nil {
+		st := h.follower.Status()
+		resp = statusResponse{
+			Role:            RoleFollower,
+			Leader:          st.Leader,
+			Connected:       st.Connected,
+			AppliedSequence: st.AppliedSequence,
+			LeaderSequence:  st.LeaderSequence,
+			Lag:             st.Lag(),
+		}
+		if !st.LastContact.IsZero() {
+			resp.LastContact = &st.LastContact
+		}
+	} else {
+		sequence, err := h.source.LastSequence()
+		if err != nil {
+			log.Error("read sequence failed", slog.Any("error", err))
+			apierror.WriteError(w, r, err)
+			return
+		}
+		resp = statusResponse{Role: RoleLeader, Sequence: sequence, Connected: true}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// ForwardToLeader redirects writes and watches to the leader when running as a follower,
+// a follower does not journal what it applies and has nothing to stream
+func (h *Handler) ForwardToLeader(next http.Handler) http.Handler {
+	if h.follower == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == watchPath || strings.HasSuffix(r.URL.Path, "/_watch"):
+			h.follower.redirect(w, r)
+		case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+			next.ServeHTTP(w, r)
+		default:
+			h.follower.redirect(w, r)
+		}
+	})
+}
@@ -0,0 +1,506 @@
+package resp
+
+import (
+	"cloud/internal/auth"
+	"cloud/internal/core"
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	scanPage         = 1000 // keys fetched per List call while walking the index
+	defaultScanCount = 10
+)
+
+// errQuit closes the connection once the reply is written
+var errQuit = errors.New("quit")
+
+type command struct {
+	arity   int // minimal number of arguments, including the command name
+	handler func(ctx context.Context, s *Server, w *writer, args []string) error
+
+	// perm is needed on every key keys returns while auth is enabled, zero
+	// lets unauthenticated clients run the command
+	perm auth.Permission
+	keys func(args []string) []string
+}
+
+var commands = map[string]command{
+	"PING":    {arity: 1, handler: ping},
+	"ECHO":    {arity: 2, handler: echo},
+	"QUIT":    {arity: 1, handler: quit},
+	"COMMAND": {arity: 1, handler: commandInfo},
+	"AUTH":    {arity: 2, handler: authenticate},
+	"GET":     {arity: 2, handler: get, perm: auth.PermRead, keys: firstKey},
+	"SET":     {arity: 3, handler: set, perm: auth.PermWrite, keys: firstKey},
+	"DEL":     {arity: 2, handler: del, perm: auth.PermDelete, keys: allKeys},
+	"EXISTS":  {arity: 2, handler: exists, perm: auth.PermRead, keys: allKeys},
+	"KEYS":    {arity: 2, handler: keys, perm: auth.PermRead, keys: keysPrefix},
+	"SCAN":    {arity: 2, handler: scan, perm: auth.PermRead, keys: scanPrefix},
+	"INCR":    {arity: 2, handler: incr, perm: auth.PermWrite, keys: firstKey},
+	"INCRBY":  {arity: 3, handler: incrBy, perm: auth.PermWrite, keys: firstKey},
+	"DECR":    {arity: 2, handler: decr, perm: auth.PermWrite, keys: firstKey},
+	"DECRBY":  {arity: 3, handler: decrBy, perm: auth.PermWrite, keys: firstKey},
+}
+
+func firstKey(args []string) []string {
+	return args[1:2]
+}
+
+func allKeys(args []string) []string {
+	return args[1:]
+}
+
+// keysPrefix returns the literal prefix of the KEYS pattern, the listing is
+// authorized like an HTTP list of that prefix
+func keysPrefix(args []string) []string {
+	return []string{globPrefix(args[1])}
+}
+
+// scanPrefix returns the literal prefix of the SCAN MATCH pattern
+func scanPrefix(args []string) []string {
+	pattern := "*"
+	for i := 2; i+1 < len(args); i += 2 {
+		if strings.EqualFold(args[i], "MATCH") {
+			pattern = args[i+1]
+		}
+	}
+	return []string{globPrefix(pattern)}
+}
+
+func ping(_ context.Context, _ *Server, w *writer, args []string) error {
+	if len(args) > 1 {
+		w.bulk(args[1])
+		return nil
+	}
+	w.simple("PONG")
+	return nil
+}
+
+func echo(_ context.Context, _ *Server, w *writer, args []string) error {
+	w.bulk(args[1])
+	return nil
+}
+
+func quit(_ context.Context, _ *Server, w *writer, _ []string) error {
+	w.simple("OK")
+	return errQuit
+}
+
+// authenticate implements AUTH [username] password, the password is a token
+// the guard accepts and the username is ignored
+func authenticate(ctx context.Context, s *Server, w *writer, args []string) error {
+	if s.guard == nil {
+		w.error("ERR AUTH called without any password configured for the default user.")
+		return nil
+	}
+
+	principal, err := s.guard.Authenticate(args[len(args)-1])
+	if err != nil {
+		s.logger.Warn("authentication failed", slog.Any("error", err))
+		w.error("WRONGPASS invalid username-password pair or user is disabled.")
+		return nil
+	}
+
+	sessionFrom(ctx).principal = principal
+	w.simple("OK")
+	return nil
+}
+
+// commandInfo answers the COMMAND introspection redis-cli sends on connect with nothing
+func commandInfo(_ context.Context, _ *Server, w *writer, _ []string) error {
+	w.array(0)
+	return nil
+}
+
+func get(ctx context.Context, s *Server, w *writer, args []string) error {
+	value, err := s.store.Get(ctx, args[1])
+	if errors.Is(err, core.ErrKeyNotFound) {
+		w.null()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.bulk(value)
+	return nil
+}
+
+// set implements SET key value [NX | XX] [EX seconds | PX milliseconds]
+func set(ctx context.Context, s *Server, w *writer, args []string) error {
+	key, value := args[1], args[2]
+
+	var (
+		ttl    time.Duration
+		nx, xx bool
+	)
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if ttl != 0 || i+1 == len(args) {
+				w.error("ERR syntax error")
+				return nil
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || n <= 0 {
+				w.error("ERR invalid expire time in 'set' command")
+				return nil
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			if n > math.MaxInt64/int64(unit) {
+				w.error("ERR invalid expire time in 'set' command")
+				return nil
+			}
+			ttl = time.Duration(n) * unit
+		default:
+			w.error("ERR syntax error")
+			return nil
+		}
+	}
+	if nx && xx {
+		w.error("ERR syntax error")
+		return nil
+	}
+
+	var (
+		stored = true
+		err    error
+	)
+	switch {
+	case nx:
+		_, err = s.compareAndSwap(ctx, key, value, 0, ttl)
+		if errors.Is(err, core.ErrVersionMismatch) {
+			stored, err = false, nil
+		}
+	case xx:
+		stored, err = s.replace(ctx, key, value, ttl)
+	case ttl > 0:
+		err = s.store.PutWithTTL(ctx, key, value, ttl)
+	default:
+		err = s.store.Put(ctx, key, value)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !stored {
+		w.null()
+		return nil
+	}
+	w.simple("OK")
+	return nil
+}
+
+func del(ctx context.Context, s *Server, w *writer, args []string) error {
+	var n int64
+	for _, key := range args[1:] {
+		// a missing key is not journaled as a delete
+		if _, err := s.store.Get(ctx, key); err != nil {
+			continue
+		}
+		if err := s.store.Delete(ctx, key); err != nil {
+			return err
+		}
+		n++
+	}
+	w.integer(n)
+	return nil
+}
+
+func exists(ctx context.Context, s *Server, w *writer, args []string) error {
+	var n int64
+	for _, key := range args[1:] {
+		if _, err := s.store.Get(ctx, key); err == nil {
+			n++
+		}
+	}
+	w.integer(n)
+	return nil
+}
+
+func keys(ctx context.Context, s *Server, w *writer, args []string) error {
+	pattern := args[1]
+
+	var matched []string
+	err := s.walk(ctx, pattern, 0, func(key string) bool {
+		if globMatch(pattern, key) {
+			matched = append(matched, key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	w.bulks(matched)
+	return nil
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count]. The cursor is
+// the number of keys already walked in key order under the literal prefix of
+// the pattern, so keys deleted during the iteration may cause later keys to be skipped.
+func scan(ctx context.Context, s *Server, w *writer, args []string) error {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		w.error("ERR invalid cursor")
+		return nil
+	}
+
+	pattern, count := "*", defaultScanCount
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			w.error("ERR syntax error")
+			return nil
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				w.error("ERR syntax error")
+				return nil
+			}
+		default:
+			w.error("ERR syntax error")
+			return nil
+		}
+	}
+
+	var (
+		matched  []string
+		examined int
+		more     bool
+	)
+	err = s.walk(ctx, pattern, cursor, func(key string) bool {
+		if examined == count {
+			more = true
+			return false
+		}
+		examined++
+		if globMatch(pattern, key) {
+			matched = append(matched, key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	next := uint64(0)
+	if more {
+		next = cursor + uint64(examined)
+	}
+
+	w.array(2)
+	w.bulk(strconv.FormatUint(next, 10))
+	w.bulks(matched)
+	return nil
+}
+
+func incr(ctx context.Context, s *Server, w *writer, args []string) error {
+	return increment(ctx, s, w, args[1], 1)
+}
+
+func decr(ctx context.Context, s *Server, w *writer, args []string) error {
+	return increment(ctx, s, w, args[1], -1)
+}
+
+func incrBy(ctx context.Context, s *Server, w *writer, args []string) error {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		w.error("ERR value is not an integer or out of range")
+		return nil
+	}
+	return increment(ctx, s, w, args[1], delta)
+}
+
+func decrBy(ctx context.Context, s *Server, w *writer, args []string) error {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		w.error("ERR value is not an integer or out of range")
+		return nil
+	}
+	if delta == math.MinInt64 {
+		w.error("ERR decrement would overflow")
+		return nil
+	}
+	return increment(ctx, s, w, args[1], -delta)
+}
+
+// increment adds delta to an integer value under the store lock, keeping its ttl
+func increment(ctx context.Context, s *Server, w *writer, key string, delta int64) error {
+	result, _, err := s.store.Incr(ctx, key, delta)
+	switch {
+	case errors.Is(err, core.ErrNotInteger):
+		w.error("ERR value is not an integer or out of range")
+		return nil
+	case errors.Is(err, core.ErrOverflow):
+		w.error("ERR increment or decrement would overflow")
+		return nil
+	case err != nil:
+		return err
+	}
+
+	w.integer(result)
+	return nil
+}
+
+// replace stores the pair only if the key exists, retrying on a concurrent write
+func (s *Server) replace(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+
+		version, err := s.store.Version(ctx, key)
+		if errors.Is(err, core.ErrKeyNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		_, err = s.compareAndSwap(ctx, key, value, version, ttl)
+		if errors.Is(err, core.ErrVersionMismatch) {
+			continue
+		}
+		return err == nil, err
+	}
+}
+
+// compareAndSwap writes with the ttl if it is positive
+func (s *Server) compareAndSwap(ctx context.Context, key, value string, expected uint64, ttl time.Duration) (uint64, error) {
+	return s.store.Write(ctx, key, core.Value(value), core.WriteOptions{TTL: ttl, Expected: &expected})
+}
+
+// walk calls fn for the keys that may match the pattern in ascending order,
+// skipping the first skip of them, until fn returns false
+func (s *Server) walk(ctx context.Context, pattern string, skip uint64, fn func(key string) bool) error {
+	opts := core.ListOptions{Prefix: globPrefix(pattern), Limit: scanPage}
+
+	for {
+		res, err := s.store.List(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range res.Items {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if !fn(item.Key) {
+				return nil
+			}
+		}
+
+		if res.Next == "" {
+			return nil
+		}
+		opts.After = res.Next
+	}
+}
+
+// globPrefix returns the literal prefix of a glob pattern, used to narrow the listing
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// globMatch reports whether s matches the Redis glob pattern: * and ? wildcards,
+// [abc], [^a] and [a-z] classes and \ escapes. Unlike path.Match, * also matches '/'.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				// an unterminated class is matched literally
+				if s[0] != '[' {
+					return false
+				}
+				break
+			}
+			class := pattern[1 : end+1]
+			if !classMatch(class, s[0]) {
+				return false
+			}
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
+
+func classMatch(class string, c byte) bool {
+	negate := strings.HasPrefix(class, "^")
+	if negate {
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		if class[i] == '\\' && i+1 < len(class) {
+			i++
+			matched = matched || class[i] == c
+			continue
+		}
+		if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= c && c <= hi)
+			i += 2
+			continue
+		}
+		matched = matched || class[i] == c
+	}
+	return matched != negate
+}
@@ -0,0 +1,138 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxArgs      = 1024 * 1024
+	maxBulkBytes = 64 * 1024 * 1024
+	maxInline    = 64 * 1024
+)
+
+var errProtocol = errors.New("protocol error")
+
+type reader struct {
+	br *bufio.Reader
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{br: bufio.NewReader(r)}
+}
+
+// buffered reports whether a pipelined command is already waiting
+func (r *reader) buffered() bool {
+	return r.br.Buffered() > 0
+}
+
+// readCommand reads a command sent as an array of bulk strings, or as an
+// inline command line the way telnet and redis-cli --pipe do
+func (r *reader) readCommand() ([]string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		if len(line) > maxInline {
+			return nil, fmt.Errorf("%w: too big inline request", errProtocol)
+		}
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+
+	args := make([]string, 0, max(n, 0))
+	for range n {
+		arg, err := r.readBulk()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func (r *reader) readBulk() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "$") {
+		return "", fmt.Errorf("%w: expected '$', got '%.1s'", errProtocol, line)
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxBulkBytes {
+		return "", fmt.Errorf("%w: invalid bulk length", errProtocol)
+	}
+
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.br, buf); err != nil {
+		return "", err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return "", fmt.Errorf("%w: bulk string not terminated", errProtocol)
+	}
+	return string(buf[:n]), nil
+}
+
+func (r *reader) readLine() (string, error) {
+	line, err := r.br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// writer encodes RESP2 replies, the caller flushes once the pipeline is drained
+type writer struct {
+	bw *bufio.Writer
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{bw: bufio.NewWriter(w)}
+}
+
+func (w *writer) simple(s string) {
+	_, _ = w.bw.WriteString("+" + s + "\r\n")
+}
+
+func (w *writer) error(msg string) {
+	_, _ = w.bw.WriteString("-" + msg + "\r\n")
+}
+
+func (w *writer) integer(n int64) {
+	_, _ = w.bw.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *writer) bulk(s string) {
+	_, _ = w.bw.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (w *writer) null() {
+	_, _ = w.bw.WriteString("$-1\r\n")
+}
+
+func (w *writer) array(n int) {
+	_, _ = w.bw.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w *writer) bulks(items []string) {
+	w.array(len(items))
+	for _, s := range items {
+		w.bulk(s)
+	}
+}
+
+func (w *writer) flush() error {
+	return w.bw.Flush()
+}
@@ -0,0 +1,239 @@
+package resp
+
+import (
+	"cloud/internal/auth"
+	"cloud/internal/config"
+	"cloud/internal/core"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server accepts Redis clients on a TCP listener and serves a subset of the
+// Redis commands from the store
+type Server struct {
+	addr        string
+	idleTimeout time.Duration
+	store       core.Store
+	guard       *auth.Guard // nil while auth is disabled
+	logger      *slog.Logger
+	errCh       chan error
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer serves the store, clients have to AUTH with a token the guard
+// accepts before they run a command on keys unless guard is nil
+func NewServer(cfg config.RESPConfig, store core.Store, guard *auth.Guard, logger *slog.Logger) *Server {
+	return &Server{
+		addr:        cfg.Addr,
+		idleTimeout: cfg.IdleTimeout,
+		store:       store,
+		guard:       guard,
+		logger:      logger,
+		errCh:       make(chan error, 1),
+		conns:       make(map[net.Conn]struct{}),
+	}
+}
+
+func (s *Server) Start() error {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("listen error: %v", err)
+	}
+
+	s.mu.Lock()
+	s.listener = lis
+	s.mu.Unlock()
+
+	go s.accept(lis)
+
+	s.logger.Info("resp server started", "addr", lis.Addr().String())
+	return nil
+}
+
+func (s *Server) accept(lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			s.errCh <- fmt.Errorf("accept error: %v", err)
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serve(conn)
+	}
+}
+
+// Stop closes the listener and every client connection, redis clients keep
+// idle connections open, then waits for the running commands until ctx is done
+func (s *Server) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.listener == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	_ = s.listener.Close()
+	s.listener = nil
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("shutdown error: %v", ctx.Err())
+	}
+
+	s.logger.Info("resp server stopped")
+	return nil
+}
+
+func (s *Server) ErrChan() <-chan error {
+	return s.errCh
+}
+
+type sessionKey struct{}
+
+// session is the state of a client connection, it is only used by the
+// goroutine serving the connection
+type session struct {
+	principal string // empty until AUTH succeeds
+}
+
+func sessionFrom(ctx context.Context) *session {
+	sess, _ := ctx.Value(sessionKey{}).(*session)
+	if sess == nil {
+		return &session{}
+	}
+	return sess
+}
+
+func (s *Server) serve(conn net.Conn) {
+	const op = "resp.Server.serve"
+
+	log := s.logger.With(
+		slog.String("op", op),
+		slog.String("remote", conn.RemoteAddr().String()),
+	)
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+		s.wg.Done()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = context.WithValue(ctx, sessionKey{}, &session{})
+
+	r, w := newReader(conn), newWriter(conn)
+	for {
+		if s.idleTimeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+		}
+
+		args, err := r.readCommand()
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				w.error("ERR " + err.Error())
+				_ = w.flush()
+			}
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Debug("connection closed", slog.Any("error", err))
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		err = s.exec(ctx, w, args)
+
+		// replies to pipelined commands are written together
+		if !r.buffered() || err != nil {
+			if ferr := w.flush(); ferr != nil {
+				return
+			}
+		}
+		if errors.Is(err, errQuit) {
+			return
+		}
+	}
+}
+
+// exec runs a command and writes its reply, only errQuit is returned
+func (s *Server) exec(ctx context.Context, w *writer, args []string) error {
+	name := strings.ToUpper(args[0])
+
+	cmd, ok := commands[name]
+	if !ok {
+		w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return nil
+	}
+	if len(args) < cmd.arity {
+		w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return nil
+	}
+	if s.guard != nil && cmd.perm != 0 {
+		principal := sessionFrom(ctx).principal
+		if principal == "" {
+			w.error("NOAUTH Authentication required.")
+			return nil
+		}
+		for _, key := range cmd.keys(args) {
+			if !s.guard.Allowed(principal, cmd.perm, "", key) {
+				s.logger.Warn("access denied",
+					slog.String("principal", principal),
+					slog.String("command", name),
+					slog.String("key", key),
+				)
+				w.error("NOPERM this user has no permissions to access one of the keys used as arguments")
+				return nil
+			}
+		}
+	}
+
+	err := cmd.handler(ctx, s, w, args)
+	switch {
+	case err == nil, errors.Is(err, errQuit):
+		return err
+	case errors.Is(err, core.ErrReadOnly):
+		w.error("READONLY You can't write against a read only replica.")
+	case errors.Is(err, core.ErrInsufficientStorage):
+		w.error("OOM command not allowed when used memory > 'maxmemory'.")
+	case errors.Is(err, core.ErrJournalUnavailable):
+		w.error("MISCONF Errors writing to the journal, writes are disabled until it recovers.")
+	case errors.Is(err, core.ErrEmptyKey):
+		w.error("ERR empty key")
+	default:
+		s.logger.Error("command failed", slog.String("command", name), slog.Any("error", err))
+		w.error("ERR " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package resp
+
+import (
+	"bufio"
+	"cloud/internal/auth"
+	"cloud/internal/config"
+	"cloud/internal/core"
+	"cloud/internal/mocks"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestConn(t *testing.T, guard *auth.Guard) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	srv := NewServer(config.RESPConfig{Addr: "127.0.0.1:0"}, store, guard, slog.Default())
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", srv.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = srv.Stop(context.Background())
+	})
+	return conn, bufio.NewReader(conn)
+}
+
+// do sends the command as a RESP array and returns the raw reply
+func do(t *testing.T, conn net.Conn, r *bufio.Reader, args ...string) string {
+	t.Helper()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply, err := readReply(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func readReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	var n int
+	switch line[0] {
+	case '$':
+		if _, err := fmt.Sscanf(line, "$%d", &n); err != nil || n < 0 {
+			return line, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return "", err
+		}
+		return line + string(data), nil
+	case '*':
+		_, _ = fmt.Sscanf(line, "*%d", &n)
+		reply := line
+		for range n {
+			item, err := readReply(r)
+			if err != nil {
+				return "", err
+			}
+			reply += item
+		}
+		return reply, nil
+	default:
+		return line, nil
+	}
+}
+
+func TestCommands(t *testing.T) {
+	conn, r := newTestConn(t, nil)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PING"}, "+PONG\r\n"},
+		{[]string{"SET", "a", "1"}, "+OK\r\n"},
+		{[]string{"GET", "a"}, "$1\r\n1\r\n"},
+		{[]string{"GET", "missing"}, "$-1\r\n"},
+		{[]string{"SET", "a", "2", "NX"}, "$-1\r\n"},
+		{[]string{"SET", "b", "2", "XX"}, "$-1\r\n"},
+		{[]string{"SET", "b", "2", "NX", "EX", "60"}, "+OK\r\n"},
+		{[]string{"SET", "b", "3", "XX", "PX", "60000"}, "+OK\r\n"},
+		{[]string{"SET", "b", "3", "EX", "0"}, "-ERR invalid expire time in 'set' command\r\n"},
+		{[]string{"SET", "b", "3", "NX", "XX"}, "-ERR syntax error\r\n"},
+		{[]string{"INCR", "a"}, ":2\r\n"},
+		{[]string{"INCR", "counter"}, ":1\r\n"},
+		{[]string{"INCR", "b"}, ":4\r\n"},
+		{[]string{"INCRBY", "counter", "10"}, ":11\r\n"},
+		{[]string{"DECR", "counter"}, ":10\r\n"},
+		{[]string{"DECRBY", "counter", "15"}, ":-5\r\n"},
+		{[]string{"INCRBY", "counter", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"SET", "text", "abc"}, "+OK\r\n"},
+		{[]string{"INCR", "text"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"EXISTS", "a", "b", "missing"}, ":2\r\n"},
+		{[]string{"KEYS", "[ab]"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"SCAN", "0", "COUNT", "2"}, "*2\r\n$1\r\n2\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"SCAN", "0", "MATCH", "t*"}, "*2\r\n$1\r\n0\r\n*1\r\n$4\r\ntext\r\n"},
+		{[]string{"DEL", "a", "missing"}, ":1\r\n"},
+		{[]string{"NOPE"}, "-ERR unknown command 'NOPE'\r\n"},
+		{[]string{"GET"}, "-ERR wrong number of arguments for 'get' command\r\n"},
+	}
+
+	for _, tt := range tests {
+		if got := do(t, conn, r, tt.args...); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	guard, err := auth.New(config.AuthConfig{
+		Enabled: true,
+		Tokens:  []config.TokenConfig{{Token: "secret", Principal: "app"}},
+		ACL: map[string][]config.ACLRuleConfig{
+			"app": {{Prefix: "app/", Permissions: []string{"read", "write"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, r := newTestConn(t, guard)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PING"}, "+PONG\r\n"},
+		{[]string{"SET", "app/a", "1"}, "-NOAUTH Authentication required.\r\n"},
+		{[]string{"AUTH", "wrong"}, "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{[]string{"AUTH", "default", "secret"}, "+OK\r\n"},
+		{[]string{"SET", "app/a", "1"}, "+OK\r\n"},
+		{[]string{"GET", "app/a"}, "$1\r\n1\r\n"},
+		{[]string{"SET", "other", "1"}, "-NOPERM this user has no permissions to access one of the keys used as arguments\r\n"},
+		{[]string{"DEL", "app/a"}, "-NOPERM this user has no permissions to access one of the keys used as arguments\r\n"},
+		{[]string{"KEYS", "*"}, "-NOPERM this user has no permissions to access one of the keys used as arguments\r\n"},
+		{[]string{"SCAN", "0", "MATCH", "app/*"}, "*2\r\n$1\r\n0\r\n*1\r\n$5\r\napp/a\r\n"},
+	}
+
+	for _, tt := range tests {
+		if got := do(t, conn, r, tt.args...); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestInlineAndPipeline(t *testing.T) {
+	conn, r := newTestConn(t, nil)
+
+	if _, err := conn.Write([]byte("SET k v\r\nGET k\r\nPING hi\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"+OK\r\n", "$1\r\nv\r\n", "$2\r\nhi\r\n"} {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		got, err := readReply(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestConcurrentIncr(t *testing.T) {
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	srv := NewServer(config.RESPConfig{}, store, nil, slog.Default())
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := newWriter(io.Discard)
+			for range 50 {
+				_ = srv.exec(context.Background(), w, []string{"INCR", "n"})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value, _ := store.Get(context.Background(), "n"); value != "400" {
+		t.Errorf("got %s, want 400", value)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "app/a", true},
+		{"app/*", "app/a/b", true},
+		{"h?llo", "hello", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+	}
+
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package rpc
+
+import (
+	kvstorev1 "cloud/api/kvstore/v1"
+	"cloud/internal/auth"
+	"context"
+	"log/slog"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authorizer checks the bearer token in the call metadata and the ACL of its
+// principal, the same way the HTTP middleware does
+type authorizer struct {
+	guard *auth.Guard
+	log   *slog.Logger
+}
+
+func (a *authorizer) unary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authorize(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authorizer) stream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &authorizedStream{ServerStream: ss, authorizer: a, ctx: ss.Context()})
+}
+
+// authorize returns ctx carrying the principal once it may run req
+func (a *authorizer) authorize(ctx context.Context, req any) (context.Context, error) {
+	const op = "rpc.authorize"
+
+	log := a.log.With(slog.String("op", op))
+
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token, _ = strings.CutPrefix(values[0], "Bearer ")
+			token = strings.TrimSpace(token)
+		}
+	}
+
+	principal, err := a.guard.Authenticate(token)
+	if err != nil {
+		log.Warn("authentication failed", slog.Any("error", err))
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid credentials")
+	}
+
+	perm, namespace, key := requiredGrant(req)
+	if !a.guard.Allowed(principal, perm, namespace, key) {
+		log.Warn("access denied",
+			slog.String("principal", principal),
+			slog.String("permission", perm.String()),
+			slog.String("key", key),
+		)
+		return nil, status.Error(codes.PermissionDenied, "missing "+perm.String()+" permission")
+	}
+	return auth.WithPrincipal(ctx, principal), nil
+}
+
+// requiredGrant maps a request to the permission it needs on a key or a key
+// prefix of the default namespace, unknown requests need admin permission
+func requiredGrant(req any) (auth.Permission, string, string) {
+	switch r := req.(type) {
+	case *kvstorev1.GetRequest:
+		return auth.PermRead, "", r.GetKey()
+	case *kvstorev1.PutRequest:
+		return auth.PermWrite, "", r.GetKey()
+	case *kvstorev1.DeleteRequest:
+		return auth.PermDelete, "", r.GetKey()
+	case *kvstorev1.ListRequest:
+		return auth.PermRead, "", r.GetPrefix()
+	case *kvstorev1.WatchRequest:
+		return auth.PermRead, "", r.GetPrefix()
+	default:
+		return auth.PermAdmin, auth.AnyNamespace, ""
+	}
+}
+
+// authorizedStream authorizes the request of a server stream once it is received
+type authorizedStream struct {
+	grpc.ServerStream
+	authorizer *authorizer
+	ctx        context.Context
+	authorized bool
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if s.authorized {
+		return nil
+	}
+
+	ctx, err := s.authorizer.authorize(s.ctx, m)
+	if err != nil {
+		return err
+	}
+	s.ctx, s.authorized = ctx, true
+	return nil
+}
@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	kvstorev1 "cloud/api/kvstore/v1"
+	"cloud/internal/auth"
+	"cloud/internal/config"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"google.golang.org/grpc"
+)
+
+type GRPCServer struct {
+	srv    *grpc.Server
+	addr   string
+	logger *slog.Logger
+	errCh  chan error
+}
+
+// NewServer serves the service, every call needs a token the guard accepts
+// unless guard is nil
+func NewServer(cfg config.GRPCConfig, guard *auth.Guard, logger *slog.Logger, service *Service) *GRPCServer {
+	opts := []grpc.ServerOption{
+		grpc.ConnectionTimeout(cfg.ConnectionTimeout),
+	}
+	if guard != nil {
+		a := &authorizer{guard: guard, log: logger}
+		opts = append(opts, grpc.UnaryInterceptor(a.unary), grpc.StreamInterceptor(a.stream))
+	}
+
+	server := grpc.NewServer(opts...)
+	kvstorev1.RegisterKVStoreServer(server, service)
+
+	return &GRPCServer{
+		srv:    server,
+		addr:   cfg.Addr,
+		logger: logger,
+		errCh:  make(chan error, 1),
+	}
+}
+
+func (s *GRPCServer) Start() error {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("listen error: %v", err)
+	}
+
+	go func() {
+		if err := s.srv.Serve(lis); err != nil {
+			s.errCh <- fmt.Errorf("serve error: %v", err)
+		}
+	}()
+
+	s.logger.Info("grpc server started", "addr", lis.Addr().String())
+	return nil
+}
+
+// Stop waits for in-flight calls until ctx is done, then closes the remaining
+// ones, watch streams never finish on their own
+func (s *GRPCServer) Stop(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.srv.Stop()
+		return fmt.Errorf("shutdown error: %v", ctx.Err())
+	}
+
+	s.logger.Info("grpc server stopped")
+	return nil
+}
+
+func (s *GRPCServer) ErrChan() <-chan error {
+	return s.errCh
+}
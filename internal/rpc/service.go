@@ -0,0 +1,228 @@
+package rpc
+
+import (
+	kvstorev1 "cloud/api/kvstore/v1"
+	"cloud/internal/core"
+	"context"
+	"encoding/base64"
+	"log/slog"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// Service implements the KVStore gRPC service on top of the store shared with the HTTP handlers
+type Service struct {
+	kvstorev1.UnimplementedKVStoreServer
+
+	store core.Store
+	log   *slog.Logger
+}
+
+func NewService(store core.Store, log *slog.Logger) *Service {
+	return &Service{
+		store: store,
+		log:   log,
+	}
+}
+
+func (s *Service) Get(ctx context.Context, req *kvstorev1.GetRequest) (*kvstorev1.GetResponse, error) {
+	const op = "rpc.Service.Get"
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	value, err := s.store.Get(ctx, req.GetKey())
+	if err != nil {
+		log.Warn("get failed", slog.Any("error", err))
+		return nil, toStatus(err)
+	}
+
+	resp := &kvstorev1.GetResponse{Value: value}
+	if version, err := s.store.Version(ctx, req.GetKey()); err == nil {
+		resp.Version = version
+	}
+	if ttl, err := s.store.TTL(ctx, req.GetKey()); err == nil && ttl > 0 {
+		resp.Ttl = durationpb.New(ttl)
+	}
+
+	log.Info("value retrieved")
+	return resp, nil
+}
+
+func (s *Service) Put(ctx context.Context, req *kvstorev1.PutRequest) (*kvstorev1.PutResponse, error) {
+	const op = "rpc.Service.Put"
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	opts := core.WriteOptions{Expected: req.IfVersion}
+	if ttl := req.GetTtl(); ttl != nil {
+		if err := ttl.CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		opts.TTL = ttl.AsDuration()
+	}
+
+	version, err := s.store.Write(ctx, req.GetKey(), core.Value(req.GetValue()), opts)
+	if err != nil {
+		log.Warn("put failed", slog.Any("error", err))
+		return nil, toStatus(err)
+	}
+
+	resp := &kvstorev1.PutResponse{Version: version}
+	log.Info("value stored")
+	return resp, nil
+}
+
+func (s *Service) Delete(ctx context.Context, req *kvstorev1.DeleteRequest) (*kvstorev1.DeleteResponse, error) {
+	const op = "rpc.Service.Delete"
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	var err error
+	if req.IfVersion != nil {
+		err = s.store.CompareAndDelete(ctx, req.GetKey(), req.GetIfVersion())
+	} else {
+		err = s.store.Delete(ctx, req.GetKey())
+	}
+	if err != nil {
+		log.Warn("delete failed", slog.Any("error", err))
+		return nil, toStatus(err)
+	}
+
+	log.Info("value deleted")
+	return &kvstorev1.DeleteResponse{}, nil
+}
+
+func (s *Service) List(ctx context.Context, req *kvstorev1.ListRequest) (*kvstorev1.ListResponse, error) {
+	const op = "rpc.Service.List"
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+	if limit < 0 || limit > maxListLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxListLimit)
+	}
+
+	after, err := base64.RawURLEncoding.DecodeString(req.GetCursor())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid cursor")
+	}
+
+	res, err := s.store.List(ctx, core.ListOptions{
+		Prefix: req.GetPrefix(),
+		Start:  req.GetStart(),
+		After:  string(after),
+		Limit:  limit,
+	})
+	if err != nil {
+		log.Error("list failed", slog.Any("error", err))
+		return nil, toStatus(err)
+	}
+
+	// the cursor matches the one of the HTTP API, so pages can be fetched through either
+	resp := &kvstorev1.ListResponse{
+		Items:      make([]*kvstorev1.KeyValue, 0, len(res.Items)),
+		NextCursor: base64.RawURLEncoding.EncodeToString([]byte(res.Next)),
+	}
+	for _, item := range res.Items {
+		kv := &kvstorev1.KeyValue{Key: item.Key}
+		if req.GetValues() {
+			kv.Value = item.Value
+		}
+		resp.Items = append(resp.Items, kv)
+	}
+
+	log.Info("keys listed", slog.Int("count", len(resp.Items)))
+	return resp, nil
+}
+
+func (s *Service) Watch(req *kvstorev1.WatchRequest, stream kvstorev1.KVStore_WatchServer) error {
+	const op = "rpc.Service.Watch"
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	events, err := s.store.Watch(stream.Context(), core.WatchOptions{
+		Prefix: req.GetPrefix(),
+		From:   req.GetFromSequence(),
+	})
+	if err != nil {
+		log.Warn("watch failed", slog.Any("error", err))
+		return toStatus(err)
+	}
+
+	log.Info("watch started", slog.Uint64("from", req.GetFromSequence()))
+
+	for e := range events {
+		if err := stream.Send(toWatchEvent(e)); err != nil {
+			log.Info("watch finished", slog.Any("error", err))
+			return err
+		}
+	}
+
+	if err := stream.Context().Err(); err != nil {
+		log.Info("watch finished")
+		return status.FromContextError(err).Err()
+	}
+	// the store dropped a watcher that fell behind, it resumes from its last sequence
+	log.Warn("watcher dropped")
+	return status.Error(codes.Aborted, "watcher fell behind, resume from the last sequence")
+}
+
+func toWatchEvent(e core.WatchEvent) *kvstorev1.WatchEvent {
+	we := &kvstorev1.WatchEvent{Sequence: e.Sequence, Key: e.Key, Value: e.Value}
+
+	switch e.Type {
+	case core.WatchPut:
+		we.Type = kvstorev1.WatchEvent_TYPE_PUT
+	case core.WatchDelete:
+		we.Type = kvstorev1.WatchEvent_TYPE_DELETE
+	case core.WatchExpire:
+		we.Type = kvstorev1.WatchEvent_TYPE_EXPIRE
+	}
+	if !e.ExpiresAt.IsZero() {
+		we.ExpiresAt = timestamppb.New(e.ExpiresAt)
+	}
+	return we
+}
+
+// toStatus maps store errors to gRPC codes by their class
+func toStatus(err error) error {
+	code := codes.Internal
+	switch core.CodeOf(err) {
+	case core.CodeNotFound:
+		code = codes.NotFound
+	case core.CodeInvalidArgument:
+		code = codes.InvalidArgument
+	case core.CodePreconditionFailed:
+		code = codes.Aborted
+	case core.CodeReadOnly:
+		code = codes.FailedPrecondition
+	case core.CodeUnavailable:
+		code = codes.Unavailable
+	case core.CodeInsufficientStorage, core.CodeTooLarge:
+		code = codes.ResourceExhausted
+	case core.CodeGone:
+		code = codes.OutOfRange
+	}
+	return status.Error(code, err.Error())
+}
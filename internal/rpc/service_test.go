@@ -0,0 +1,184 @@
+package rpc
+
+import (
+	kvstorev1 "cloud/api/kvstore/v1"
+	"cloud/internal/auth"
+	"cloud/internal/config"
+	"cloud/internal/core"
+	"cloud/internal/mocks"
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func newTestClient(t *testing.T, opts ...grpc.ServerOption) kvstorev1.KVStoreClient {
+	t.Helper()
+
+	store, _ := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(opts...)
+	kvstorev1.RegisterKVStoreServer(srv, NewService(store, slog.Default()))
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+		srv.Stop()
+	})
+	return kvstorev1.NewKVStoreClient(conn)
+}
+
+func TestService(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	put, err := client.Put(ctx, &kvstorev1.PutRequest{Key: "a", Value: "1", Ttl: durationpb.New(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get, err := client.Get(ctx, &kvstorev1.GetRequest{Key: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if get.GetValue() != "1" || get.GetVersion() != put.GetVersion() || get.GetTtl() == nil {
+		t.Errorf("unexpected get response %v", get)
+	}
+
+	_, err = client.Put(ctx, &kvstorev1.PutRequest{Key: "a", Value: "2", IfVersion: proto.Uint64(put.GetVersion() + 1)})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("got code %v, want %v", status.Code(err), codes.Aborted)
+	}
+
+	_, _ = client.Put(ctx, &kvstorev1.PutRequest{Key: "b", Value: "2"})
+	list, err := client.List(ctx, &kvstorev1.ListRequest{Limit: 1, Values: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.GetItems()) != 1 || list.GetItems()[0].GetKey() != "a" || list.GetNextCursor() == "" {
+		t.Fatalf("unexpected first page %v", list)
+	}
+	list, err = client.List(ctx, &kvstorev1.ListRequest{Limit: 1, Cursor: list.GetNextCursor()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.GetItems()) != 1 || list.GetItems()[0].GetKey() != "b" || list.GetNextCursor() != "" {
+		t.Errorf("unexpected last page %v", list)
+	}
+
+	if _, err := client.Delete(ctx, &kvstorev1.DeleteRequest{Key: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Get(ctx, &kvstorev1.GetRequest{Key: "a"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("got code %v, want %v", status.Code(err), codes.NotFound)
+	}
+}
+
+func TestServiceWatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client := newTestClient(t)
+
+	_, _ = client.Put(ctx, &kvstorev1.PutRequest{Key: "app/a", Value: "1"})
+	_, _ = client.Put(ctx, &kvstorev1.PutRequest{Key: "other", Value: "2"})
+
+	stream, err := client.Watch(ctx, &kvstorev1.WatchRequest{Prefix: "app/", FromSequence: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = client.Delete(ctx, &kvstorev1.DeleteRequest{Key: "app/a"})
+
+	want := []struct {
+		sequence uint64
+		typ      kvstorev1.WatchEvent_Type
+	}{
+		{1, kvstorev1.WatchEvent_TYPE_PUT},
+		{3, kvstorev1.WatchEvent_TYPE_DELETE},
+	}
+	for _, w := range want {
+		e, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.GetSequence() != w.sequence || e.GetType() != w.typ || e.GetKey() != "app/a" {
+			t.Errorf("got event %v, want sequence %d type %v", e, w.sequence, w.typ)
+		}
+	}
+}
+
+func TestServiceAuth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	guard, err := auth.New(config.AuthConfig{
+		Enabled: true,
+		Tokens:  []config.TokenConfig{{Token: "secret", Principal: "app"}},
+		ACL: map[string][]config.ACLRuleConfig{
+			"app": {{Prefix: "app/", Permissions: []string{"read", "write"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &authorizer{guard: guard, log: slog.Default()}
+	client := newTestClient(t, grpc.UnaryInterceptor(a.unary), grpc.StreamInterceptor(a.stream))
+
+	_, err = client.Put(ctx, &kvstorev1.PutRequest{Key: "app/a", Value: "1"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("got code %v without a token, want %v", status.Code(err), codes.Unauthenticated)
+	}
+
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	if _, err := client.Put(authed, &kvstorev1.PutRequest{Key: "app/a", Value: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Put(authed, &kvstorev1.PutRequest{Key: "other", Value: "2"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got code %v outside the prefix, want %v", status.Code(err), codes.PermissionDenied)
+	}
+	_, err = client.Delete(authed, &kvstorev1.DeleteRequest{Key: "app/a"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got code %v without delete permission, want %v", status.Code(err), codes.PermissionDenied)
+	}
+
+	stream, err := client.Watch(authed, &kvstorev1.WatchRequest{Prefix: ""})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got code %v watching every key, want %v", status.Code(err), codes.PermissionDenied)
+	}
+
+	stream, err = client.Watch(authed, &kvstorev1.WatchRequest{Prefix: "app/", FromSequence: 1})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if err != nil {
+		t.Errorf("watch of the prefix failed: %v", err)
+	}
+}
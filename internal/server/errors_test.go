@@ -0,0 +1,129 @@
+package server
+
+import (
+	"cloud/internal/auth"
+	"cloud/internal/config"
+	"cloud/internal/core"
+	"cloud/internal/handlers"
+	"cloud/internal/health"
+	"cloud/internal/mocks"
+	"cloud/internal/replication"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorEnvelope(t *testing.T) {
+	store, err := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := NewRouter(
+		handlers.NewHandler(store, slog.Default()),
+		replication.NewHandler(store, nil, slog.Default()),
+		nil, health.NewHandler(store, &config.Config{}, slog.Default()),
+		nil, http.NotFoundHandler(), slog.Default(),
+	)
+
+	type envelope struct {
+		Error struct {
+			Code      core.Code `json:"code"`
+			Message   string    `json:"message"`
+			RequestID string    `json:"request_id"`
+		} `json:"error"`
+	}
+
+	for _, tc := range []struct {
+		name      string
+		method    string
+		path      string
+		requestID string
+		status    int
+		code      string
+	}{
+		{"MissingKey", http.MethodGet, "/v1/missing", "req-42", http.StatusNotFound, "not_found"},
+		{"UnknownRoute", http.MethodDelete, "/v2/nowhere", "", http.StatusNotFound, "route_not_found"},
+		{"WrongMethod", http.MethodPost, "/v1/key", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"HostileRequestID", http.MethodGet, "/v1/missing", "bad\tid", http.StatusNotFound, "not_found"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.requestID != "" {
+				req.Header.Set("X-Request-ID", tc.requestID)
+			}
+			rr := httptest.NewRecorder()
+			routes.ServeHTTP(rr, req)
+
+			if rr.Code != tc.status {
+				t.Errorf("got status %d, want %d", rr.Code, tc.status)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("got content type %q", ct)
+			}
+
+			var resp envelope
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if string(resp.Error.Code) != tc.code {
+				t.Errorf("got code %q, want %q", resp.Error.Code, tc.code)
+			}
+
+			id := rr.Header().Get("X-Request-ID")
+			switch {
+			case id == "" || resp.Error.RequestID != id:
+				t.Errorf("got request id %q in the body and %q in the header", resp.Error.RequestID, id)
+			case tc.requestID == "req-42" && id != tc.requestID:
+				t.Errorf("got request id %q, want the one sent", id)
+			case tc.requestID == "bad\tid" && id == tc.requestID:
+				t.Error("kept an invalid request id")
+			}
+		})
+	}
+}
+
+func TestAuthorizeBoundsBatchBody(t *testing.T) {
+	store, err := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard, err := auth.New(config.AuthConfig{
+		Enabled: true,
+		Tokens:  []config.TokenConfig{{Token: "secret", Principal: "writer"}},
+		ACL: map[string][]config.ACLRuleConfig{
+			"writer": {{Permissions: []string{"write"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := handlers.NewHandler(store, slog.Default())
+	h.SetBatchLimit(128)
+	routes := NewRouter(
+		h,
+		replication.NewHandler(store, nil, slog.Default()),
+		nil, health.NewHandler(store, &config.Config{}, slog.Default()),
+		guard, http.NotFoundHandler(), slog.Default(),
+	)
+
+	batch := func(value string) int {
+		body := `{"operations":[{"op":"put","key":"key1","value":"` + value + `"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/_batch", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := batch("value1"); code != http.StatusOK {
+		t.Errorf("got status %d, want %d", code, http.StatusOK)
+	}
+	if code := batch(strings.Repeat("x", 128)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want %d", code, http.StatusRequestEntityTooLarge)
+	}
+}
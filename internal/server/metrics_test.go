@@ -0,0 +1,76 @@
+package server
+
+import (
+	"cloud/internal/config"
+	"cloud/internal/core"
+	"cloud/internal/handlers"
+	"cloud/internal/health"
+	"cloud/internal/metrics"
+	"cloud/internal/mocks"
+	"cloud/internal/replication"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	store, err := core.NewStore(&mocks.MockTransactor{}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := metrics.NewRegistry(store.Collector())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := NewRouter(
+		handlers.NewHandler(store, slog.Default()),
+		replication.NewHandler(store, nil, slog.Default()),
+		nil,
+		health.NewHandler(store, &config.Config{}, slog.Default()),
+		nil,
+		metrics.Handler(registry),
+		slog.Default(),
+	)
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	do := func(method, path, body string) {
+		t.Helper()
+
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	do(http.MethodPut, "/v1/metered", "value")
+	do(http.MethodGet, "/v1/metered", "")
+	do(http.MethodGet, "/nowhere", "")
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, want := range []string{
+		`kvstore_http_requests_total{method="PUT",route="/v1/{key}",status="201"} 1`,
+		`kvstore_http_requests_total{method="GET",route="/v1/{key}",status="200"} 1`,
+		`kvstore_http_requests_total{method="GET",route="none",status="404"} 1`,
+		`kvstore_http_request_duration_seconds_count{method="PUT",route="/v1/{key}",status="201"} 1`,
+		`kvstore_store_keys{namespace=""} 1`,
+		`kvstore_store_bytes{namespace=""} 12`,
+		`kvstore_journal_write_errors_total 0`,
+		`go_goroutines `,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics miss %s", want)
+		}
+	}
+}
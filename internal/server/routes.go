@@ -1,24 +1,84 @@
 package server
 
 import (
+	"cloud/internal/apierror"
+	"cloud/internal/auth"
+	"cloud/internal/cluster"
 	"cloud/internal/handlers"
+	"cloud/internal/health"
 	"cloud/internal/middleware"
+	"cloud/internal/replication"
 	"log/slog"
 	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
-func NewRouter(h *handlers.Handler, logger *slog.Logger) http.Handler {
+// publicPaths are served without authentication
+var publicPaths = []string{"/", "/healthz", "/readyz", "/v1/_journal/health"}
+
+// NewRouter builds the routes, ch is nil unless the node runs in a raft cluster
+// and guard is nil unless auth is enabled
+func NewRouter(h *handlers.Handler, rh *replication.Handler, ch *cluster.Handler, hh *health.Handler, guard *auth.Guard, metrics http.Handler, logger *slog.Logger) http.Handler {
 	r := mux.NewRouter()
+	r.Use(middleware.Route)
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Write(w, r, apierror.CodeRouteNotFound, "no route for "+r.URL.Path)
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+	})
 
 	r.HandleFunc("/", h.HelloGoHandler)
+	r.HandleFunc("/healthz", hh.LivenessHandler).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", hh.ReadinessHandler).Methods(http.MethodGet)
+	r.HandleFunc("/debug/status", hh.StatusHandler).Methods(http.MethodGet)
+	r.Handle("/metrics", metrics).Methods(http.MethodGet)
+	r.HandleFunc("/v1", h.ListHandler).Methods(http.MethodGet)
+	r.HandleFunc("/v1/_batch", h.BatchHandler).Methods(http.MethodPost)
+	r.HandleFunc("/v1/_watch", h.WatchHandler).Methods(http.MethodGet)
+	r.HandleFunc("/v1/_journal/health", h.JournalHealthHandler).Methods(http.MethodGet)
+	r.HandleFunc("/v1/_replication/snapshot", rh.SnapshotHandler).Methods(http.MethodGet)
+	r.HandleFunc("/v1/_replication/status", rh.StatusHandler).Methods(http.MethodGet)
+	if ch != nil {
+		r.HandleFunc("/v1/_cluster/members", ch.MembersHandler).Methods(http.MethodGet)
+		r.HandleFunc("/v1/_cluster/members", ch.JoinHandler).Methods(http.MethodPost)
+		r.HandleFunc("/v1/_cluster/members/{id}", ch.RemoveHandler).Methods(http.MethodDelete)
+	}
+
+	ns := r.PathPrefix("/v1/ns/{namespace}").Subrouter()
+	ns.HandleFunc("", h.InNamespace((*handlers.Handler).ListHandler)).Methods(http.MethodGet)
+	ns.HandleFunc("", h.DropNamespaceHandler).Methods(http.MethodDelete)
+	ns.HandleFunc("/_batch", h.InNamespace((*handlers.Handler).BatchHandler)).Methods(http.MethodPost)
+	ns.HandleFunc("/_watch", h.InNamespace((*handlers.Handler).WatchHandler)).Methods(http.MethodGet)
+	ns.HandleFunc("/{key}/_incr", h.InNamespace((*handlers.Handler).IncrHandler)).Methods(http.MethodPost)
+	ns.HandleFunc("/{key}/_decr", h.InNamespace((*handlers.Handler).DecrHandler)).Methods(http.MethodPost)
+	ns.HandleFunc("/{key}", h.InNamespace((*handlers.Handler).PutHandler)).Methods(http.MethodPut)
+	ns.HandleFunc("/{key}", h.InNamespace((*handlers.Handler).GetHandler)).Methods(http.MethodGet)
+	ns.HandleFunc("/{key}", h.InNamespace((*handlers.Handler).HeadHandler)).Methods(http.MethodHead)
+	ns.HandleFunc("/{key}", h.InNamespace((*handlers.Handler).DeleteHandler)).Methods(http.MethodDelete)
+
+	r.HandleFunc("/v1/{key}/_incr", h.IncrHandler).Methods(http.MethodPost)
+	r.HandleFunc("/v1/{key}/_decr", h.DecrHandler).Methods(http.MethodPost)
 	r.HandleFunc("/v1/{key}", h.PutHandler).Methods(http.MethodPut)
 	r.HandleFunc("/v1/{key}", h.GetHandler).Methods(http.MethodGet)
+	r.HandleFunc("/v1/{key}", h.HeadHandler).Methods(http.MethodHead)
 	r.HandleFunc("/v1/{key}", h.DeleteHandler).Methods(http.MethodDelete)
 
-	chain := middleware.Logging(logger)(
-		middleware.Recover(logger)(r),
+	var next http.Handler = rh.ForwardToLeader(r)
+	if guard != nil {
+		r.Use(middleware.Authorize(guard, h.MaxBatchSize(), logger, publicPaths...))
+		next = middleware.Authenticate(guard, logger, publicPaths...)(next)
+	}
+
+	chain := middleware.RequestID(
+		middleware.Logging(logger)(
+			middleware.Tracing()(
+				middleware.Recover(logger)(
+					next,
+				),
+			),
+		),
 	)
 
 	return chain
@@ -6,16 +6,18 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 )
 
 type HTTPServer struct {
 	srv       *http.Server
+	certs     *certReloader // nil unless the server speaks tls
 	logger    *slog.Logger
 	errCh     chan error
 	isRunning bool
 }
 
-func NewServer(cfg config.ServerConfig, logger *slog.Logger, routes http.Handler) *HTTPServer {
+func NewServer(cfg config.ServerConfig, logger *slog.Logger, routes http.Handler) (*HTTPServer, error) {
 	server := &http.Server{
 		Addr:         cfg.Addr,
 		ReadTimeout:  cfg.ReadTimeout,
@@ -23,21 +25,58 @@ func NewServer(cfg config.ServerConfig, logger *slog.Logger, routes http.Handler
 		IdleTimeout:  cfg.IdleTimeout,
 		Handler:      routes,
 	}
+
+	var certs *certReloader
+	if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" {
+		c, err := newCertReloader(cfg.TLS, logger)
+		if err != nil {
+			return nil, fmt.Errorf("tls config: %w", err)
+		}
+		certs = c
+		server.TLSConfig = certs.tlsConfig()
+	}
+
 	return &HTTPServer{
 		srv:    server,
+		certs:  certs,
 		logger: logger,
 		errCh:  make(chan error, 1),
-	}
+	}, nil
 }
 
 func (s *HTTPServer) Start() {
 	go func() {
-		if err := s.srv.ListenAndServe(); err != nil {
+		var err error
+		if s.certs != nil {
+			// the certificates come from TLSConfig
+			err = s.srv.ListenAndServeTLS("", "")
+		} else {
+			err = s.srv.ListenAndServe()
+		}
+		if err != nil {
 			s.errCh <- fmt.Errorf("listen error: %v", err)
 		}
 	}()
 
-	s.logger.Info("http server started", "addr", s.srv.Addr)
+	s.logger.Info("http server started", "addr", s.srv.Addr, "tls", s.certs != nil)
+}
+
+// ReloadTLS reads the certificate and client CAs again, connections already
+// open keep the ones they were made with
+func (s *HTTPServer) ReloadTLS() error {
+	if s.certs == nil {
+		return nil
+	}
+	return s.certs.reload()
+}
+
+// RunTLSReloader reloads the certificates whenever their files change, until
+// ctx is done
+func (s *HTTPServer) RunTLSReloader(ctx context.Context, interval time.Duration) {
+	if s.certs == nil || interval <= 0 {
+		return
+	}
+	s.certs.watch(ctx, interval)
 }
 
 func (s *HTTPServer) Stop(ctx context.Context) error {
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"cloud/internal/config"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownTLSVersion = errors.New("unknown tls version")
+	ErrNoCertificates    = errors.New("no certificates found")
+)
+
+// tlsVersions are the accepted min_version values
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certReloader serves the certificate and client CAs last read from disk, so
+// they can be replaced without restarting the server
+type certReloader struct {
+	cfg  config.TLSConfig
+	base *tls.Config
+	log  *slog.Logger
+
+	mu       sync.RWMutex
+	config   *tls.Config // base with the current certificate and client CAs
+	modified time.Time   // latest modification time of the files read
+}
+
+func newCertReloader(cfg config.TLSConfig, log *slog.Logger) (*certReloader, error) {
+	var version uint16 = tls.VersionTLS12
+	if cfg.MinVersion != "" {
+		v, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTLSVersion, cfg.MinVersion)
+		}
+		version = v
+	}
+
+	// the configs handed out replace the one http.Server fills in, so they
+	// need its protocols as well
+	base := &tls.Config{MinVersion: version, NextProtos: []string{"h2", "http/1.1"}}
+	switch {
+	case cfg.ClientCAFile == "":
+		base.ClientAuth = tls.NoClientCert
+	case cfg.RequireClientCert:
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	c := &certReloader{cfg: cfg, base: base, log: log}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload reads the files again, the current certificate stays in use if they
+// are broken
+func (c *certReloader) reload() error {
+	modified, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.cfg.CertFile, c.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+
+	conf := c.base.Clone()
+	conf.Certificates = []tls.Certificate{cert}
+	if c.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%w in %s", ErrNoCertificates, c.cfg.ClientCAFile)
+		}
+		conf.ClientCAs = pool
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config = conf
+	c.modified = modified
+	return nil
+}
+
+func (c *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.cfg.CertFile, c.cfg.KeyFile, c.cfg.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// changed reports whether any file was modified since the last reload
+func (c *certReloader) changed() bool {
+	modified, err := c.lastModified()
+	if err != nil {
+		// a file being replaced may be missing for a moment
+		return false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return !modified.Equal(c.modified)
+}
+
+// tlsConfig hands every new connection the current configuration
+func (c *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: c.base.MinVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+
+			return c.config, nil
+		},
+	}
+}
+
+// watch reloads the files whenever they change until ctx is done
+func (c *certReloader) watch(ctx context.Context, interval time.Duration) {
+	const op = "server.certReloader.watch"
+	log := c.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !c.changed() {
+				continue
+			}
+			if err := c.reload(); err != nil {
+				log.Error("failed to reload tls certificates", slog.Any("error", err))
+				continue
+			}
+			log.Info("tls certificates reloaded")
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"cloud/internal/auth"
+	"cloud/internal/config"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pair tls.Certificate
+}
+
+func issue(t *testing.T, serial int64, subject pkix.Name, parent *testCert, server bool) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	switch {
+	case parent == nil:
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	case server:
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	default:
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, pair: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+
+	keyDER, _ := x509.MarshalECPrivateKey(c.key)
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile != "" {
+		if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLSConfig{
+		CertFile:          filepath.Join(dir, "server.crt"),
+		KeyFile:           filepath.Join(dir, "server.key"),
+		ClientCAFile:      filepath.Join(dir, "ca.crt"),
+		MinVersion:        "1.3",
+		RequireClientCert: true,
+	}
+
+	ca := issue(t, 1, pkix.Name{CommonName: "test ca"}, nil, false)
+	ca.write(t, cfg.ClientCAFile, "")
+	issue(t, 2, pkix.Name{CommonName: "127.0.0.1"}, ca, true).write(t, cfg.CertFile, cfg.KeyFile)
+	client := issue(t, 3, pkix.Name{CommonName: "alice", Organization: []string{"kv"}}, ca, false)
+
+	certs, err := newCertReloader(cfg, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, auth.Identity(r.TLS))
+	}))
+	srv.TLS = certs.tlsConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(clientCerts ...tls.Certificate) (*http.Response, error) {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: clientCerts,
+		}}}
+		return c.Get(srv.URL)
+	}
+
+	t.Run("Client Identity", func(t *testing.T) {
+		resp, err := get(client.pair)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "CN=alice,O=kv" {
+			t.Errorf("got identity %q", body)
+		}
+		if resp.TLS.Version != tls.VersionTLS13 {
+			t.Errorf("got tls version %x", resp.TLS.Version)
+		}
+	})
+
+	t.Run("Missing Client Certificate", func(t *testing.T) {
+		if resp, err := get(); err == nil {
+			resp.Body.Close()
+			t.Fatal("expected the handshake to fail")
+		}
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		// make sure the modification time moves on coarse file systems
+		later := time.Now().Add(time.Second)
+		issue(t, 4, pkix.Name{CommonName: "127.0.0.1"}, ca, true).write(t, cfg.CertFile, cfg.KeyFile)
+		_ = os.Chtimes(cfg.CertFile, later, later)
+
+		if !certs.changed() {
+			t.Fatal("expected the certificate change to be noticed")
+		}
+		if err := certs.reload(); err != nil {
+			t.Fatal(err)
+		}
+		if certs.changed() {
+			t.Error("expected no change after reload")
+		}
+
+		resp, err := get(client.pair)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 4 {
+			t.Errorf("got certificate %d, want the reloaded one", serial)
+		}
+	})
+
+	t.Run("Broken Files Keep The Certificate", func(t *testing.T) {
+		if err := os.WriteFile(cfg.KeyFile, []byte("garbage"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := certs.reload(); err == nil {
+			t.Fatal("expected the reload to fail")
+		}
+
+		resp, err := get(client.pair)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"cloud/internal/config"
+	"cloud/internal/core"
+	"cloud/internal/handlers"
+	"cloud/internal/health"
+	"cloud/internal/replication"
+	"cloud/internal/transaction"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	ctx := context.Background()
+	transactor, err := transaction.NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = transactor.Close()
+		_ = os.Remove("transactor.journal")
+	}()
+	store, err := core.NewStore(transactor, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := NewRouter(
+		handlers.NewHandler(store, slog.Default()),
+		replication.NewHandler(store, nil, slog.Default()),
+		nil, health.NewHandler(store, &config.Config{}, slog.Default()),
+		nil, http.NotFoundHandler(), slog.Default(),
+	)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPut, "/v1/traced", strings.NewReader("value"))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got status %d", rr.Code)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+
+	server, ok := spans["PUT /v1/{key}"]
+	if !ok {
+		t.Fatalf("no server span in %v", spans)
+	}
+	if got := server.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("server span in trace %s, want the incoming %s", got, traceID)
+	}
+
+	put, ok := spans["inMemoryStore.Write"]
+	if !ok || put.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatal("store span is not a child of the server span")
+	}
+	for _, s := range []sdktrace.ReadOnlySpan{server, put} {
+		for _, attr := range s.Attributes() {
+			if strings.Contains(attr.Value.Emit(), "traced") {
+				t.Errorf("span %s records the key in %s", s.Name(), attr.Key)
+			}
+		}
+	}
+	write, ok := spans["FileTransactor.write"]
+	if !ok || write.Parent().SpanID() != put.SpanContext().SpanID() {
+		t.Fatal("transactor span is not a child of the store span")
+	}
+
+	commit, ok := spans["FileTransactor.commit"]
+	if !ok {
+		t.Fatal("no commit span")
+	}
+	linked := false
+	for _, l := range commit.Links() {
+		linked = linked || l.SpanContext.SpanID() == write.SpanContext().SpanID()
+	}
+	if !linked {
+		t.Error("commit span does not link the write")
+	}
+}
@@ -0,0 +1,576 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	_ Engine    = &BoltEngine{}
+	_ Keyspaces = &BoltKeyspaces{}
+)
+
+var (
+	entriesBucket    = []byte("entries")
+	expiryBucket     = []byte("expiry") // deadline | key, scanned in deadline order by the reaper
+	metaBucket       = []byte("meta")
+	namespacesBucket = []byte("namespaces") // a nested entries, expiry and meta set per namespace
+
+	sequenceKey = []byte("sequence")
+	formatKey   = []byte("format")
+)
+
+// entryFormat is the layout of the entries bucket, files without a format
+// key hold entries without metadata and are rewritten when opened
+const entryFormat = 2
+
+var (
+	ErrCorruptEntry = errors.New("stored entry is corrupted")
+	// ErrNamespaceDropped is returned by an engine whose namespace was dropped
+	ErrNamespaceDropped = errors.New("namespace was dropped")
+)
+
+// BoltEngine keeps the entries in a bbolt file. Every write is committed to
+// disk before it returns, so the state survives a restart without replaying
+// the journal and the dataset is not bounded by memory.
+type BoltEngine struct {
+	db        *bolt.DB
+	namespace []byte // nil for the default namespace, whose buckets are top level
+	owned     bool   // Close closes db
+	count     int64  // atomic, entries in the entries bucket
+	sequence  uint64 // atomic, mirrors the meta bucket
+}
+
+// NewBoltEngine opens the default namespace of the file at path
+func NewBoltEngine(path string) (*BoltEngine, error) {
+	db, err := openBolt(path)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := openBoltEngine(db, DefaultNamespace)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	e.owned = true
+	return e, nil
+}
+
+func openBolt(path string) (*bolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("cannot create storage directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open storage file: %w", err)
+	}
+	return db, nil
+}
+
+func openBoltEngine(db *bolt.DB, namespace string) (*BoltEngine, error) {
+	e := &BoltEngine{db: db}
+	if namespace != DefaultNamespace {
+		e.namespace = []byte(namespace)
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		parent, err := e.createParent(tx)
+		if err != nil {
+			return err
+		}
+		if err := createBuckets(parent); err != nil {
+			return err
+		}
+		if err := upgradeEntries(parent); err != nil {
+			return err
+		}
+		e.count = int64(parent.Bucket(entriesBucket).Stats().KeyN)
+		if v := parent.Bucket(metaBucket).Get(sequenceKey); v != nil {
+			e.sequence = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize storage file: %w", err)
+	}
+	return e, nil
+}
+
+// bucketParent is what a *bolt.Tx and a *bolt.Bucket share for nesting buckets
+type bucketParent interface {
+	Bucket(name []byte) *bolt.Bucket
+	CreateBucketIfNotExists(name []byte) (*bolt.Bucket, error)
+	DeleteBucket(name []byte) error
+}
+
+func createBuckets(parent bucketParent) error {
+	for _, name := range [][]byte{entriesBucket, expiryBucket, metaBucket} {
+		if _, err := parent.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgradeEntries rewrites the entries of an older format in the current one
+func upgradeEntries(parent bucketParent) error {
+	meta := parent.Bucket(metaBucket)
+	if v := meta.Get(formatKey); len(v) == 1 && v[0] == entryFormat {
+		return nil
+	}
+
+	entries := parent.Bucket(entriesBucket)
+	upgraded := make(map[string][]byte)
+	err := entries.ForEach(func(k, v []byte) error {
+		entry, err := decodeEntryV1(v)
+		if err != nil {
+			return err
+		}
+		upgraded[string(k)] = encodeEntry(entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for k, v := range upgraded {
+		if err := entries.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return meta.Put(formatKey, []byte{entryFormat})
+}
+
+// createParent returns what holds the buckets of the namespace, creating it if needed
+func (e *BoltEngine) createParent(tx *bolt.Tx) (bucketParent, error) {
+	if e.namespace == nil {
+		return tx, nil
+	}
+	namespaces, err := tx.CreateBucketIfNotExists(namespacesBucket)
+	if err != nil {
+		return nil, err
+	}
+	return namespaces.CreateBucketIfNotExists(e.namespace)
+}
+
+// bucket returns the named bucket of the namespace
+func (e *BoltEngine) bucket(tx *bolt.Tx, name []byte) (*bolt.Bucket, error) {
+	if e.namespace == nil {
+		return tx.Bucket(name), nil
+	}
+	if namespaces := tx.Bucket(namespacesBucket); namespaces != nil {
+		if parent := namespaces.Bucket(e.namespace); parent != nil {
+			return parent.Bucket(name), nil
+		}
+	}
+	return nil, ErrNamespaceDropped
+}
+
+func (e *BoltEngine) Get(key string) (Entry, bool, error) {
+	var (
+		entry Entry
+		found bool
+	)
+	err := e.db.View(func(tx *bolt.Tx) error {
+		entries, err := e.bucket(tx, entriesBucket)
+		if err != nil {
+			return err
+		}
+		data := entries.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+
+		entry, err = decodeEntry(data)
+		return err
+	})
+	return entry, found, err
+}
+
+func (e *BoltEngine) Write(changes ...Change) error {
+	var (
+		delta    int64
+		sequence = atomic.LoadUint64(&e.sequence)
+	)
+
+	err := e.db.Update(func(tx *bolt.Tx) error {
+		entries, err := e.bucket(tx, entriesBucket)
+		if err != nil {
+			return err
+		}
+		expiry, err := e.bucket(tx, expiryBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range changes {
+			key := []byte(c.Key)
+
+			if data := entries.Get(key); data != nil {
+				old, err := decodeEntry(data)
+				if err != nil {
+					return err
+				}
+				if !old.ExpiresAt.IsZero() {
+					if err := expiry.Delete(expiryKey(old.ExpiresAt, c.Key)); err != nil {
+						return err
+					}
+				}
+				delta--
+			}
+
+			if c.Delete {
+				if err := entries.Delete(key); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := entries.Put(key, encodeEntry(c.Entry)); err != nil {
+				return err
+			}
+			if !c.Entry.ExpiresAt.IsZero() {
+				if err := expiry.Put(expiryKey(c.Entry.ExpiresAt, c.Key), nil); err != nil {
+					return err
+				}
+			}
+			delta++
+			sequence = max(sequence, c.Entry.Version)
+		}
+
+		meta, err := e.bucket(tx, metaBucket)
+		if err != nil {
+			return err
+		}
+		return meta.Put(sequenceKey, binary.BigEndian.AppendUint64(nil, sequence))
+	})
+	if err != nil {
+		return fmt.Errorf("storage write failure: %w", err)
+	}
+
+	atomic.AddInt64(&e.count, delta)
+	atomic.StoreUint64(&e.sequence, sequence)
+	return nil
+}
+
+func (e *BoltEngine) Ascend(prefix, from, after string, fn func(key string, entry Entry) bool) error {
+	return e.db.View(func(tx *bolt.Tx) error {
+		entries, err := e.bucket(tx, entriesBucket)
+		if err != nil {
+			return err
+		}
+		c := entries.Cursor()
+
+		for k, v := c.Seek([]byte(max(prefix, from, after))); k != nil; k, v = c.Next() {
+			if !bytes.HasPrefix(k, []byte(prefix)) {
+				return nil
+			}
+			if after != "" && string(k) == after {
+				continue
+			}
+
+			entry, err := decodeEntry(v)
+			if err != nil {
+				return err
+			}
+			if !fn(string(k), entry) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (e *BoltEngine) Expired(now time.Time) ([]string, error) {
+	var keys []string
+
+	err := e.db.View(func(tx *bolt.Tx) error {
+		expiry, err := e.bucket(tx, expiryBucket)
+		if err != nil {
+			return err
+		}
+		c := expiry.Cursor()
+
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if len(k) < 8 {
+				return ErrCorruptEntry
+			}
+			if int64(binary.BigEndian.Uint64(k)) > now.UnixNano() {
+				return nil
+			}
+			keys = append(keys, string(k[8:]))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (e *BoltEngine) Len() int {
+	return int(atomic.LoadInt64(&e.count))
+}
+
+func (e *BoltEngine) Sequence() uint64 {
+	return atomic.LoadUint64(&e.sequence)
+}
+
+func (e *BoltEngine) Reset() error {
+	err := e.db.Update(func(tx *bolt.Tx) error {
+		parent, err := e.createParent(tx)
+		if err != nil {
+			return err
+		}
+		for _, name := range [][]byte{entriesBucket, expiryBucket, metaBucket} {
+			if err := parent.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+		if err := createBuckets(parent); err != nil {
+			return err
+		}
+		return upgradeEntries(parent)
+	})
+	if err != nil {
+		return fmt.Errorf("storage reset failure: %w", err)
+	}
+
+	atomic.StoreInt64(&e.count, 0)
+	atomic.StoreUint64(&e.sequence, 0)
+	return nil
+}
+
+func (e *BoltEngine) Persistent() bool {
+	return true
+}
+
+// Close closes the file unless the engine was opened through BoltKeyspaces,
+// which then owns it
+func (e *BoltEngine) Close() error {
+	if !e.owned {
+		return nil
+	}
+	return e.db.Close()
+}
+
+// BoltKeyspaces keeps every namespace in one bbolt file, the default namespace
+// in the top level buckets so files written before namespaces open unchanged
+type BoltKeyspaces struct {
+	db      *bolt.DB
+	mu      sync.Mutex
+	engines map[string]*BoltEngine
+}
+
+func NewBoltKeyspaces(path string) (*BoltKeyspaces, error) {
+	db, err := openBolt(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BoltKeyspaces{db: db, engines: make(map[string]*BoltEngine)}, nil
+}
+
+func (k *BoltKeyspaces) Open(namespace string) (Engine, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.open(namespace)
+}
+
+// open must be called with the lock held
+func (k *BoltKeyspaces) open(namespace string) (*BoltEngine, error) {
+	if e, ok := k.engines[namespace]; ok {
+		return e, nil
+	}
+	e, err := openBoltEngine(k.db, namespace)
+	if err != nil {
+		return nil, err
+	}
+	k.engines[namespace] = e
+	return e, nil
+}
+
+func (k *BoltKeyspaces) Drop(namespace string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if namespace == DefaultNamespace {
+		e, err := k.open(namespace)
+		if err != nil {
+			return err
+		}
+		return e.Reset()
+	}
+
+	err := k.db.Update(func(tx *bolt.Tx) error {
+		namespaces := tx.Bucket(namespacesBucket)
+		if namespaces == nil {
+			return nil
+		}
+		err := namespaces.DeleteBucket([]byte(namespace))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("storage drop failure: %w", err)
+	}
+	delete(k.engines, namespace)
+	return nil
+}
+
+func (k *BoltKeyspaces) Namespaces() ([]string, error) {
+	var namespaces []string
+
+	err := k.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(namespacesBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(name, _ []byte) error {
+			namespaces = append(namespaces, string(name))
+			return nil
+		})
+	})
+	return namespaces, err
+}
+
+func (k *BoltKeyspaces) Close() error {
+	return k.db.Close()
+}
+
+// encodeEntry lays an entry out as uvarint version | varint deadline |
+// uvarint content type length | content type | uvarint header count |
+// per header: uvarint name length | name | uvarint value length | value |
+// varint created at | varint updated at | value
+func encodeEntry(entry Entry) []byte {
+	meta := entry.Metadata
+	buf := make([]byte, 0, 4*binary.MaxVarintLen64+len(meta.ContentType)+len(entry.Value))
+	buf = binary.AppendUvarint(buf, entry.Version)
+	buf = binary.AppendVarint(buf, unixNanoOrZero(entry.ExpiresAt))
+
+	buf = appendString(buf, meta.ContentType)
+	buf = binary.AppendUvarint(buf, uint64(len(meta.Headers)))
+	for name, value := range meta.Headers {
+		buf = appendString(buf, name)
+		buf = appendString(buf, value)
+	}
+	buf = binary.AppendVarint(buf, unixNanoOrZero(meta.CreatedAt))
+	buf = binary.AppendVarint(buf, unixNanoOrZero(meta.UpdatedAt))
+
+	return append(buf, entry.Value...)
+}
+
+func decodeEntry(data []byte) (Entry, error) {
+	d := entryDecoder{data: data}
+	entry := Entry{Version: d.uvarint(), ExpiresAt: d.time()}
+
+	entry.Metadata.ContentType = d.string()
+	if count := d.uvarint(); count > 0 && count <= uint64(len(d.data)) {
+		entry.Metadata.Headers = make(map[string]string, count)
+		for range count {
+			name := d.string()
+			entry.Metadata.Headers[name] = d.string()
+		}
+	} else if count > 0 {
+		d.failed = true
+	}
+	entry.Metadata.CreatedAt = d.time()
+	entry.Metadata.UpdatedAt = d.time()
+
+	if d.failed {
+		return Entry{}, ErrCorruptEntry
+	}
+	entry.Value = string(d.data)
+	return entry, nil
+}
+
+// decodeEntryV1 reads the layout written before entries carried metadata:
+// uvarint version | varint deadline | value
+func decodeEntryV1(data []byte) (Entry, error) {
+	d := entryDecoder{data: data}
+	entry := Entry{Version: d.uvarint(), ExpiresAt: d.time()}
+	if d.failed {
+		return Entry{}, ErrCorruptEntry
+	}
+	entry.Value = string(d.data)
+	return entry, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// entryDecoder consumes data field by field, once a field is malformed failed
+// is set and the following fields read as zero
+type entryDecoder struct {
+	data   []byte
+	failed bool
+}
+
+func (d *entryDecoder) uvarint() uint64 {
+	if d.failed {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.failed = true
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *entryDecoder) time() time.Time {
+	if d.failed {
+		return time.Time{}
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.failed = true
+		return time.Time{}
+	}
+	d.data = d.data[n:]
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v)
+}
+
+func (d *entryDecoder) string() string {
+	size := d.uvarint()
+	if d.failed {
+		return ""
+	}
+	if size > uint64(len(d.data)) {
+		d.failed = true
+		return ""
+	}
+	s := string(d.data[:size])
+	d.data = d.data[size:]
+	return s
+}
+
+// expiryKey orders deadlines first, big endian keeps them sorted byte-wise
+func expiryKey(expiresAt time.Time, key string) []byte {
+	buf := make([]byte, 0, 8+len(key))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(expiresAt.UnixNano()))
+	return append(buf, key...)
+}
+
+func unixNanoOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"cloud/internal/config"
+	"cloud/internal/transaction"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	EngineMemory = "memory"
+	EngineBolt   = "bolt"
+)
+
+var ErrUnknownEngine = errors.New("unknown storage engine")
+
+// Entry is a stored value with the metadata the store keeps for it
+type Entry struct {
+	Value     string
+	ExpiresAt time.Time // zero if the entry never expires
+	Version   uint64
+	Metadata  transaction.Metadata
+}
+
+// Expired reports whether the deadline of the entry has passed
+func (e Entry) Expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// Change is a single write, Delete removes the key and ignores Entry
+type Change struct {
+	Key    string
+	Entry  Entry
+	Delete bool
+}
+
+// Engine holds the entries beneath core.Store. Reads may run concurrently,
+// writes are serialized by the caller and never overlap a read.
+type Engine interface {
+	// Get returns the entry of key, expired entries included
+	Get(key string) (Entry, bool, error)
+	// Write applies the changes atomically
+	Write(changes ...Change) error
+	// Ascend calls fn in key order for every key with the prefix that is not
+	// less than from and, if after is set, strictly greater than after, until
+	// fn returns false
+	Ascend(prefix, from, after string, fn func(key string, entry Entry) bool) error
+	// Expired returns the keys whose deadline is not after now
+	Expired(now time.Time) ([]string, error)
+	// Len returns the number of entries, expired ones included
+	Len() int
+	// Sequence returns the highest version written since the last Reset
+	Sequence() uint64
+	// Reset drops every entry
+	Reset() error
+	// Persistent reports whether the entries survive a restart, the journal
+	// then does not have to be replayed to rebuild them
+	Persistent() bool
+
+	Close() error
+}
+
+// DefaultNamespace holds the keys written without a namespace
+const DefaultNamespace = ""
+
+// Keyspaces holds one Engine per namespace. It is safe for concurrent use.
+type Keyspaces interface {
+	// Open returns the engine of the namespace, creating it empty if needed
+	Open(namespace string) (Engine, error)
+	// Drop removes the namespace with its entries, engines opened for it must
+	// not be used afterwards. The default namespace is only emptied.
+	Drop(namespace string) error
+	// Namespaces lists the namespaces holding state, the default one excluded
+	Namespaces() ([]string, error)
+
+	Close() error
+}
+
+// New opens the keyspaces of the engine selected by cfg, the in-memory one by default
+func New(cfg config.StoreConfig) (Keyspaces, error) {
+	switch cfg.Engine {
+	case EngineMemory, "":
+		return NewMapKeyspaces(), nil
+	case EngineBolt:
+		return NewBoltKeyspaces(cfg.Path)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEngine, cfg.Engine)
+	}
+}
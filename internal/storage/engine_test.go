@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"cloud/internal/transaction"
+	"encoding/binary"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestEngines(t *testing.T) {
+	engines := map[string]func(t *testing.T) Engine{
+		EngineMemory: func(t *testing.T) Engine {
+			return NewMapEngine()
+		},
+		EngineBolt: func(t *testing.T) Engine {
+			e, err := NewBoltEngine(filepath.Join(t.TempDir(), "store.db"))
+			if err != nil {
+				t.Fatalf("cannot open engine: %v", err)
+			}
+			return e
+		},
+	}
+
+	for name, open := range engines {
+		t.Run(name, func(t *testing.T) {
+			e := open(t)
+			defer e.Close()
+
+			now := time.Now()
+			err := e.Write(
+				Change{Key: "user:2", Entry: Entry{Value: "b", Version: 2}},
+				Change{Key: "user:1", Entry: Entry{Value: "a", Version: 1, ExpiresAt: now.Add(-time.Second)}},
+				Change{Key: "order:1", Entry: Entry{Value: "c", Version: 3, ExpiresAt: now.Add(time.Hour)}},
+				Change{Key: "user:3", Entry: Entry{Value: "d", Version: 4}},
+				Change{Key: "user:3", Delete: true},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entry, ok, err := e.Get("user:2")
+			if err != nil || !ok || entry.Value != "b" || entry.Version != 2 {
+				t.Errorf("unexpected entry %+v, %v, %v", entry, ok, err)
+			}
+			if _, ok, _ := e.Get("user:3"); ok {
+				t.Error("deleted key still exists")
+			}
+			if e.Len() != 3 || e.Sequence() != 4 {
+				t.Errorf("got len %d and sequence %d", e.Len(), e.Sequence())
+			}
+
+			var keys []string
+			_ = e.Ascend("user:", "", "", func(key string, _ Entry) bool {
+				keys = append(keys, key)
+				return true
+			})
+			if !slices.Equal(keys, []string{"user:1", "user:2"}) {
+				t.Errorf("unexpected keys %v", keys)
+			}
+
+			expired, err := e.Expired(now)
+			if err != nil || !slices.Equal(expired, []string{"user:1"}) {
+				t.Errorf("unexpected expired keys %v, %v", expired, err)
+			}
+
+			// a new deadline replaces the old one
+			if err := e.Write(Change{Key: "user:1", Entry: Entry{Value: "a", Version: 5}}); err != nil {
+				t.Fatal(err)
+			}
+			if expired, _ := e.Expired(now.Add(2 * time.Hour)); !slices.Equal(expired, []string{"order:1"}) {
+				t.Errorf("unexpected expired keys %v", expired)
+			}
+
+			if err := e.Reset(); err != nil {
+				t.Fatal(err)
+			}
+			if e.Len() != 0 || e.Sequence() != 0 {
+				t.Errorf("got len %d and sequence %d after reset", e.Len(), e.Sequence())
+			}
+		})
+	}
+}
+
+func TestBoltEngineReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+
+	e, err := NewBoltEngine(path)
+	if err != nil {
+		t.Fatalf("cannot open engine: %v", err)
+	}
+	_ = e.Write(Change{Key: "key", Entry: Entry{Value: "value", Version: 7}})
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err = NewBoltEngine(path)
+	if err != nil {
+		t.Fatalf("cannot reopen engine: %v", err)
+	}
+	defer e.Close()
+
+	entry, ok, err := e.Get("key")
+	if err != nil || !ok || entry.Value != "value" {
+		t.Errorf("entry was not kept: %+v, %v, %v", entry, ok, err)
+	}
+	if e.Len() != 1 || e.Sequence() != 7 {
+		t.Errorf("got len %d and sequence %d after reopen", e.Len(), e.Sequence())
+	}
+}
+
+func TestBoltEntryMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+
+	// a file written before entries carried metadata
+	db, err := openBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		entries, err := tx.CreateBucket(entriesBucket)
+		if err != nil {
+			return err
+		}
+		old := binary.AppendUvarint(nil, 2)
+		old = binary.AppendVarint(old, 0)
+		return entries.Put([]byte("old"), append(old, "legacy"...))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+
+	e, err := NewBoltEngine(path)
+	if err != nil {
+		t.Fatalf("cannot open engine: %v", err)
+	}
+	created := time.Unix(1700000000, 0)
+	meta := transaction.Metadata{
+		ContentType: "text/plain",
+		Headers:     map[string]string{"owner": "ops"},
+		CreatedAt:   created,
+		UpdatedAt:   created.Add(time.Hour),
+	}
+	_ = e.Write(Change{Key: "key", Entry: Entry{Value: "value", Version: 3, Metadata: meta}})
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err = NewBoltEngine(path)
+	if err != nil {
+		t.Fatalf("cannot reopen engine: %v", err)
+	}
+	defer e.Close()
+
+	entry, _, err := e.Get("key")
+	if err != nil || entry.Value != "value" || entry.Metadata.ContentType != meta.ContentType ||
+		!maps.Equal(entry.Metadata.Headers, meta.Headers) ||
+		!entry.Metadata.CreatedAt.Equal(meta.CreatedAt) || !entry.Metadata.UpdatedAt.Equal(meta.UpdatedAt) {
+		t.Errorf("got %+v, %v", entry, err)
+	}
+	entry, _, err = e.Get("old")
+	if err != nil || entry.Value != "legacy" || entry.Version != 2 {
+		t.Errorf("old entry was not upgraded: %+v, %v", entry, err)
+	}
+}
+
+func TestKeyspaces(t *testing.T) {
+	keyspaces := map[string]func(t *testing.T) Keyspaces{
+		EngineMemory: func(t *testing.T) Keyspaces {
+			return NewMapKeyspaces()
+		},
+		EngineBolt: func(t *testing.T) Keyspaces {
+			k, err := NewBoltKeyspaces(filepath.Join(t.TempDir(), "store.db"))
+			if err != nil {
+				t.Fatalf("cannot open keyspaces: %v", err)
+			}
+			return k
+		},
+	}
+
+	for name, open := range keyspaces {
+		t.Run(name, func(t *testing.T) {
+			k := open(t)
+			defer k.Close()
+
+			def, _ := k.Open(DefaultNamespace)
+			team, err := k.Open("team")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = def.Write(Change{Key: "key", Entry: Entry{Value: "default", Version: 1}})
+			_ = team.Write(Change{Key: "key", Entry: Entry{Value: "team", Version: 2}})
+
+			if entry, _, _ := def.Get("key"); entry.Value != "default" {
+				t.Errorf("namespaces share keys, got %q", entry.Value)
+			}
+			if namespaces, err := k.Namespaces(); err != nil || !slices.Equal(namespaces, []string{"team"}) {
+				t.Errorf("unexpected namespaces %v, %v", namespaces, err)
+			}
+
+			if err := k.Drop("team"); err != nil {
+				t.Fatal(err)
+			}
+			if namespaces, _ := k.Namespaces(); len(namespaces) != 0 {
+				t.Errorf("dropped namespace is listed: %v", namespaces)
+			}
+			team, _ = k.Open("team")
+			if team.Len() != 0 {
+				t.Errorf("dropped namespace kept %d entries", team.Len())
+			}
+			if def.Len() != 1 {
+				t.Error("drop removed entries of another namespace")
+			}
+
+			if err := k.Drop(DefaultNamespace); err != nil {
+				t.Fatal(err)
+			}
+			if def, _ = k.Open(DefaultNamespace); def.Len() != 0 {
+				t.Error("default namespace was not emptied")
+			}
+		})
+	}
+}
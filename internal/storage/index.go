@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// keyIndex keeps the keys of the store sorted for range and prefix scans.
+// It is not safe for concurrent use, the engine caller guards it.
+type keyIndex struct {
+	keys []string
+}
+
+func (i *keyIndex) insert(key string) {
+	pos, found := slices.BinarySearch(i.keys, key)
+	if found {
+		return
+	}
+	i.keys = slices.Insert(i.keys, pos, key)
+}
+
+func (i *keyIndex) remove(key string) {
+	pos, found := slices.BinarySearch(i.keys, key)
+	if !found {
+		return
+	}
+	i.keys = slices.Delete(i.keys, pos, pos+1)
+}
+
+// ascend calls fn for every key with the prefix that is not less than from
+// and, if after is set, strictly greater than after, until fn returns false
+func (i *keyIndex) ascend(prefix, from, after string, fn func(key string) bool) {
+	pos := sort.SearchStrings(i.keys, max(prefix, from, after))
+	for _, key := range i.keys[pos:] {
+		if !strings.HasPrefix(key, prefix) {
+			return
+		}
+		if after != "" && key == after {
+			continue
+		}
+		if !fn(key) {
+			return
+		}
+	}
+}
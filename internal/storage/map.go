@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	_ Engine    = &MapEngine{}
+	_ Keyspaces = &MapKeyspaces{}
+)
+
+// MapEngine keeps every entry in a Go map, the dataset must fit in memory
+// and is rebuilt from the journal on restart
+type MapEngine struct {
+	m        map[string]Entry
+	expiring map[string]struct{} // keys with a deadline
+	index    keyIndex
+	sequence uint64
+}
+
+func NewMapEngine() *MapEngine {
+	return &MapEngine{
+		m:        make(map[string]Entry),
+		expiring: make(map[string]struct{}),
+	}
+}
+
+func (e *MapEngine) Get(key string) (Entry, bool, error) {
+	entry, ok := e.m[key]
+	return entry, ok, nil
+}
+
+func (e *MapEngine) Write(changes ...Change) error {
+	for _, c := range changes {
+		if c.Delete {
+			e.remove(c.Key)
+			continue
+		}
+		e.set(c.Key, c.Entry)
+	}
+	return nil
+}
+
+func (e *MapEngine) set(key string, entry Entry) {
+	if _, ok := e.m[key]; !ok {
+		e.index.insert(key)
+	}
+	e.m[key] = entry
+	e.sequence = max(e.sequence, entry.Version)
+	if entry.ExpiresAt.IsZero() {
+		delete(e.expiring, key)
+	} else {
+		e.expiring[key] = struct{}{}
+	}
+}
+
+func (e *MapEngine) remove(key string) {
+	if _, ok := e.m[key]; ok {
+		e.index.remove(key)
+	}
+	delete(e.m, key)
+	delete(e.expiring, key)
+}
+
+func (e *MapEngine) Ascend(prefix, from, after string, fn func(key string, entry Entry) bool) error {
+	e.index.ascend(prefix, from, after, func(key string) bool {
+		return fn(key, e.m[key])
+	})
+	return nil
+}
+
+func (e *MapEngine) Expired(now time.Time) ([]string, error) {
+	var keys []string
+	for key := range e.expiring {
+		if e.m[key].Expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (e *MapEngine) Len() int {
+	return len(e.m)
+}
+
+func (e *MapEngine) Sequence() uint64 {
+	return e.sequence
+}
+
+func (e *MapEngine) Reset() error {
+	e.m = make(map[string]Entry)
+	e.expiring = make(map[string]struct{})
+	e.index = keyIndex{}
+	e.sequence = 0
+	return nil
+}
+
+func (e *MapEngine) Persistent() bool {
+	return false
+}
+
+func (e *MapEngine) Close() error {
+	return nil
+}
+
+// MapKeyspaces keeps a MapEngine per namespace
+type MapKeyspaces struct {
+	mu      sync.Mutex
+	engines map[string]*MapEngine
+}
+
+func NewMapKeyspaces() *MapKeyspaces {
+	return &MapKeyspaces{engines: make(map[string]*MapEngine)}
+}
+
+func (k *MapKeyspaces) Open(namespace string) (Engine, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	e, ok := k.engines[namespace]
+	if !ok {
+		e = NewMapEngine()
+		k.engines[namespace] = e
+	}
+	return e, nil
+}
+
+func (k *MapKeyspaces) Drop(namespace string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if namespace == DefaultNamespace {
+		if e, ok := k.engines[namespace]; ok {
+			return e.Reset()
+		}
+		return nil
+	}
+	delete(k.engines, namespace)
+	return nil
+}
+
+func (k *MapKeyspaces) Namespaces() ([]string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	namespaces := make([]string, 0, len(k.engines))
+	for namespace := range k.engines {
+		if namespace != DefaultNamespace {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces, nil
+}
+
+func (k *MapKeyspaces) Close() error {
+	return nil
+}
@@ -2,24 +2,48 @@ package transaction
 
 import (
 	"cloud/internal/config"
+	"cloud/internal/metrics"
 	"cloud/internal/migrator"
 	"cloud/internal/utils"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PostgresTransactor struct {
-	events chan Event
-	errors chan error
-	done   chan struct{}
-	closed uint32 // 0 if open, 1 if closed
-	pool   *pgxpool.Pool
+	events    chan writeRequest
+	snapshots chan snapshotRequest
+	done      chan struct{}
+	stopped   chan struct{} // closed once the writer has exited
+	opts      writerOptions
+	closed    uint32 // 0 if open, 1 if closed
+	pool      *pgxpool.Pool
+	feed      Feed
+	health    health
+
+	appendMu     sync.Mutex // queues the inserts in sequence order
+	lastSequence uint64     // atomic, seeded from the table on start
 }
 
-func NewPostgresTransactor(ctx context.Context, cfg config.PostgresConfig) (*PostgresTransactor, error) {
+func NewPostgresTransactor(ctx context.Context, cfg config.PostgresConfig, journal config.JournalConfig) (*PostgresTransactor, error) {
+	opts, err := newWriterOptions(journal)
+	if err != nil {
+		return nil, err
+	}
+
 	dsn := utils.MakeDSN(cfg)
 
 	psqlConfig, err := pgxpool.ParseConfig(dsn)
@@ -37,10 +61,16 @@ func NewPostgresTransactor(ctx context.Context, cfg config.PostgresConfig) (*Pos
 	}
 
 	t := &PostgresTransactor{
-		events: make(chan Event, 128),
-		errors: make(chan error, 1),
-		done:   make(chan struct{}),
-		pool:   pool,
+		events:    make(chan writeRequest, 128),
+		snapshots: make(chan snapshotRequest),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		opts:      opts,
+		pool:      pool,
+	}
+	if err := pool.QueryRow(ctx, lastSequenceQuery).Scan(&t.lastSequence); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("read last sequence: %w", err)
 	}
 	t.run(ctx)
 
@@ -52,81 +82,438 @@ func (t *PostgresTransactor) Close() error {
 		return nil
 	}
 	close(t.done) // release all goroutines
+	<-t.stopped
+	t.feed.CloseAll()
 
 	t.pool.Close()
 	return nil
 }
 
-func (t *PostgresTransactor) WritePut(ctx context.Context, key, value string) error {
-	return t.send(ctx, Event{Key: key, Value: value, EventType: EventPut})
+// Append hands the events to the writer, which inserts them in the order they
+// were appended. The wait returns once they are committed, at once if writes
+// are asynchronous.
+func (t *PostgresTransactor) Append(ctx context.Context, events []Event) (_ func() error, err error) {
+	ctx, span := tracer.Start(ctx, "PostgresTransactor.write", trace.WithAttributes(
+		attribute.Int("journal.events", len(events)),
+	))
+	defer func() {
+		if err != nil {
+			endSpan(span, err)
+		}
+	}()
+
+	if atomic.LoadUint32(&t.closed) == 1 {
+		return nil, ErrTransactorClosed
+	}
+	if err := t.health.check(); err != nil {
+		return nil, err
+	}
+
+	events = slices.Clone(events)
+	req := t.opts.newRequest(ctx, Event{EventType: EventBatch, batch: events})
+
+	t.appendMu.Lock()
+	defer t.appendMu.Unlock()
+
+	last := number(atomic.LoadUint64(&t.lastSequence), events)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case t.events <- req:
+		atomic.StoreUint64(&t.lastSequence, last)
+		metrics.SetJournalQueueDepth(len(t.events))
+	case <-t.done:
+		return nil, ErrTransactorClosed
+	}
+
+	return func() (err error) {
+		defer func() {
+			endSpan(span, err)
+		}()
+
+		if req.result == nil {
+			return nil
+		}
+		select {
+		case err = <-req.result:
+			return err
+		case <-t.stopped:
+			return ErrTransactorClosed
+		}
+	}, nil
+}
+
+// insertEventsQuery inserts one row per array element in a single statement
+const insertEventsQuery = `INSERT INTO transactions
+	(sequence, event_type, key, value, expires_at, namespace, content_type, headers, created_at, updated_at)
+	SELECT sequence, event_type, key, value, expires_at, namespace, content_type, headers::jsonb, created_at, updated_at
+	FROM unnest($1::bigint[], $2::smallint[], $3::text[], $4::bytea[], $5::timestamptz[], $6::text[],
+		$7::text[], $8::text[], $9::timestamptz[], $10::timestamptz[])
+	AS e(sequence, event_type, key, value, expires_at, namespace, content_type, headers, created_at, updated_at)`
+
+// selectEventsColumns are the columns queryEvents scans
+const selectEventsColumns = `sequence, event_type, key, value, expires_at, namespace,
+	content_type, headers, created_at, updated_at`
+
+const lastSequenceQuery = `SELECT GREATEST(
+	(SELECT COALESCE(MAX(sequence), 0) FROM transactions),
+	(SELECT COALESCE(MAX(sequence), 0) FROM snapshots))`
+
+func (t *PostgresTransactor) run(ctx context.Context) {
+	go func() {
+		defer close(t.stopped)
+
+		ticker := time.NewTicker(recoveryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case req := <-t.events:
+				batch := t.opts.gather(req, t.events)
+				metrics.SetJournalQueueDepth(len(t.events))
+				t.commit(batch)
+			case req := <-t.snapshots:
+				req.result <- t.compact(ctx, req.snapshot)
+			case <-ticker.C:
+				if t.health.degraded() {
+					t.recover(ctx)
+				}
+			case <-t.done:
+				t.drain()
+				return
+			}
+		}
+	}()
+}
+
+// drain commits the requests queued before Close
+func (t *PostgresTransactor) drain() {
+	for {
+		select {
+		case req := <-t.events:
+			t.commit(t.opts.gather(req, t.events))
+		default:
+			return
+		}
+	}
+}
+
+// commit inserts the events of all requests with one multi-row INSERT. A
+// connection or transaction failure degrades the journal, an asynchronous
+// writer has no caller left to tell. A row the database rejects fails only the
+// request it belongs to.
+func (t *PostgresTransactor) commit(batch []writeRequest) {
+	var events []Event
+	for _, req := range batch {
+		events = append(events, req.events()...)
+	}
+
+	ctx, span := startCommit("PostgresTransactor.commit", batch, len(events))
+	span.SetAttributes(attribute.String("db.system", "postgresql"))
+	start := time.Now()
+	err := t.insertEvents(ctx, events)
+	metrics.ObserveJournalWrite(time.Since(start), err)
+	endSpan(span, err)
+
+	if isDataError(err) && len(batch) > 1 {
+		// the statement failed whole, insert the requests one by one
+		for _, req := range batch {
+			t.commit([]writeRequest{req})
+		}
+		return
+	}
+	if err != nil && !isDataError(err) {
+		t.health.fail(err)
+	}
+
+	acknowledge(batch, err)
+	if err != nil {
+		return
+	}
+	t.feed.Publish(events...)
+}
+
+// isDataError reports whether the database rejected the rows themselves, a
+// data exception or a constraint violation, rather than the connection or
+// the transaction failing
+func isDataError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")
+}
+
+// recover heals the journal once the database answers again
+func (t *PostgresTransactor) recover(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, recoveryInterval)
+	defer cancel()
+
+	if err := t.pool.Ping(ctx); err != nil {
+		t.health.fail(fmt.Errorf("ping failure: %w", err))
+		return
+	}
+	t.health.recover()
+}
+
+// Health reports whether the journal accepts writes
+func (t *PostgresTransactor) Health() Health {
+	return t.health.get()
+}
+
+// Ping reports whether the writer goroutine still runs and the database
+// answers on a pooled connection
+func (t *PostgresTransactor) Ping(ctx context.Context) error {
+	if atomic.LoadUint32(&t.closed) == 1 {
+		return ErrTransactorClosed
+	}
+	select {
+	case <-t.stopped:
+		return ErrWriterStopped
+	default:
+	}
+
+	if err := t.pool.Ping(ctx); err != nil {
+		return fmt.Errorf("ping db: %w", err)
+	}
+	return nil
+}
+
+// insertEvents inserts the events with the sequences they were appended with.
+// The statement is atomic, so a batch is committed whole or not at all.
+func (t *PostgresTransactor) insertEvents(ctx context.Context, events []Event) error {
+	var (
+		sequences    = make([]int64, len(events))
+		types        = make([]int16, len(events))
+		keys         = make([]string, len(events))
+		values       = make([][]byte, len(events))
+		expiresAt    = make([]pgtype.Timestamptz, len(events))
+		spaces       = make([]string, len(events))
+		contentTypes = make([]string, len(events))
+		headers      = make([]string, len(events))
+		createdAt    = make([]pgtype.Timestamptz, len(events))
+		updatedAt    = make([]pgtype.Timestamptz, len(events))
+	)
+	for i, e := range events {
+		sequences[i] = int64(e.Sequence)
+		types[i] = int16(e.EventType)
+		keys[i] = e.Key
+		values[i] = []byte(e.Value)
+		expiresAt[i] = timestamptz(e.ExpiresAt)
+		spaces[i] = e.Namespace
+		contentTypes[i] = e.Metadata.ContentType
+		createdAt[i] = timestamptz(e.Metadata.CreatedAt)
+		updatedAt[i] = timestamptz(e.Metadata.UpdatedAt)
+
+		headers[i] = "{}"
+		if len(e.Metadata.Headers) > 0 {
+			encoded, _ := json.Marshal(e.Metadata.Headers)
+			headers[i] = string(encoded)
+		}
+	}
+
+	_, err := t.pool.Exec(ctx, insertEventsQuery,
+		sequences, types, keys, values, expiresAt, spaces, contentTypes, headers, createdAt, updatedAt)
+	if err != nil {
+		return fmt.Errorf("insert failure: %w", err)
+	}
+	return nil
+}
+
+// LastSequence returns the sequence of the last event handed to the journal
+func (t *PostgresTransactor) LastSequence() (uint64, error) {
+	return atomic.LoadUint64(&t.lastSequence), nil
 }
 
-func (t *PostgresTransactor) WriteDelete(ctx context.Context, key string) error {
-	return t.send(ctx, Event{Key: key, EventType: EventDelete})
+// Subscribe streams every event after it is committed
+func (t *PostgresTransactor) Subscribe(buffer int) (<-chan Event, func()) {
+	return t.feed.Subscribe(buffer)
 }
 
-func (t *PostgresTransactor) send(ctx context.Context, event Event) error {
+// WriteSnapshot stores the snapshot and deletes the journal rows it covers. It
+// blocks until the snapshot is committed.
+func (t *PostgresTransactor) WriteSnapshot(ctx context.Context, snapshot Snapshot) error {
 	if atomic.LoadUint32(&t.closed) == 1 {
 		return ErrTransactorClosed
 	}
 
+	req := snapshotRequest{snapshot: snapshot, result: make(chan error, 1)}
+
 	select {
 	case <-ctx.Done():
+		return ctx.Err()
+	case t.snapshots <- req:
+	case <-t.done:
 		return ErrTransactorClosed
-	case t.events <- event:
-		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-req.result:
+		return err
 	case <-t.done:
 		return ErrTransactorClosed
 	}
 }
 
-func (t *PostgresTransactor) run(ctx context.Context) {
-	go func() {
-		query := `INSERT INTO transactions
-			(event_type, key, value)
-			VALUES ($1, $2, $3)`
+// compact runs on the writer goroutine. The inserts queued before the snapshot
+// was requested are committed first, so no row up to its sequence comes later.
+func (t *PostgresTransactor) compact(ctx context.Context, snapshot Snapshot) error {
+	t.drain()
 
-		for event := range t.events {
-			_, err := t.pool.Exec(
-				context.TODO(),
-				query,
-				event.EventType, event.Key, event.Value)
+	data, err := json.Marshal(toSnapshotEntries(snapshot.Entries))
+	if err != nil {
+		return fmt.Errorf("snapshot encoding failure: %w", err)
+	}
 
-			if err != nil {
-				t.errors <- err
-			}
-		}
+	tx, err := t.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin snapshot: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
 	}()
+
+	sequence := snapshot.Sequence
+	queries := []struct {
+		sql  string
+		args []any
+	}{
+		{`INSERT INTO snapshots (sequence, entries) VALUES ($1, $2)
+			ON CONFLICT (sequence) DO UPDATE SET entries = EXCLUDED.entries, created_at = now()`,
+			[]any{sequence, data}},
+		{`DELETE FROM transactions WHERE sequence <= $1`, []any{sequence}},
+		{`DELETE FROM snapshots WHERE sequence < $1`, []any{sequence}},
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(ctx, q.sql, q.args...); err != nil {
+			return fmt.Errorf("snapshot query failure: %w", err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ReadSnapshot returns the latest snapshot, an empty one if none was written
+func (t *PostgresTransactor) ReadSnapshot() (Snapshot, error) {
+	var (
+		snapshot Snapshot
+		data     []byte
+	)
+
+	err := t.pool.QueryRow(context.TODO(),
+		"SELECT sequence, entries FROM snapshots ORDER BY sequence DESC LIMIT 1",
+	).Scan(&snapshot.Sequence, &data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Snapshot{}, nil
+	}
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("sql query error: %w", err)
+	}
+
+	var doc []snapshotEntry
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return Snapshot{}, fmt.Errorf("snapshot decoding failure: %w", err)
+	}
+	snapshot.Entries = fromSnapshotEntries(doc)
+
+	return snapshot, nil
 }
 
 func (t *PostgresTransactor) ReadEvents() (<-chan Event, <-chan error) {
+	query := `SELECT ` + selectEventsColumns + ` FROM transactions
+		WHERE sequence > (SELECT COALESCE(MAX(sequence), 0) FROM snapshots)
+		ORDER BY sequence`
+
+	start := time.Now()
+	done := func() {
+		metrics.ObserveJournalReplay(time.Since(start))
+	}
+	return t.queryEvents(context.TODO(), done, query)
+}
+
+// ReplayEvents yields the committed events with a sequence greater than after,
+// ErrCompacted is returned if after is older than the latest snapshot
+func (t *PostgresTransactor) ReplayEvents(ctx context.Context, after uint64) (<-chan Event, <-chan error) {
+	var snapshotSequence uint64
+
+	err := t.pool.QueryRow(ctx,
+		"SELECT COALESCE(MAX(sequence), 0) FROM snapshots",
+	).Scan(&snapshotSequence)
+	if err == nil && after < snapshotSequence {
+		err = ErrCompacted
+	}
+	if err != nil {
+		outEvent := make(chan Event)
+		outError := make(chan error, 1)
+		outError <- err
+		close(outEvent)
+		close(outError)
+		return outEvent, outError
+	}
+
+	query := `SELECT ` + selectEventsColumns + ` FROM transactions
+		WHERE sequence > $1
+		ORDER BY sequence`
+
+	return t.queryEvents(ctx, nil, query, after)
+}
+
+// queryEvents streams the events the query selects, done is called once they
+// were all sent unless it is nil
+func (t *PostgresTransactor) queryEvents(ctx context.Context, done func(), query string, args ...any) (<-chan Event, <-chan error) {
 	outEvent := make(chan Event)
 	outError := make(chan error, 1)
 
-	query := "SELECT sequence, event_type, key, value FROM transactions"
-
 	go func() {
 		defer close(outEvent)
 		defer close(outError)
+		if done != nil {
+			defer done()
+		}
 
-		rows, err := t.pool.Query(context.TODO(), query)
+		rows, err := t.pool.Query(ctx, query, args...)
 		if err != nil {
 			outError <- fmt.Errorf("sql query error: %w", err)
 			return
 		}
 		defer rows.Close()
 
-		var e Event
+		var (
+			e                               Event
+			expiresAt, createdAt, updatedAt *time.Time
+			value, headers                  []byte
+		)
 
 		for rows.Next() {
-			err = rows.Scan(&e.Sequence, &e.EventType, &e.Key, &e.Value)
+			err = rows.Scan(&e.Sequence, &e.EventType, &e.Key, &value, &expiresAt, &e.Namespace,
+				&e.Metadata.ContentType, &headers, &createdAt, &updatedAt)
 
 			if err != nil {
 				outError <- err
 				return
 			}
 
-			outEvent <- e
+			e.Value = string(value)
+			e.ExpiresAt = timeOrZero(expiresAt)
+			e.Metadata.CreatedAt = timeOrZero(createdAt)
+			e.Metadata.UpdatedAt = timeOrZero(updatedAt)
+
+			e.Metadata.Headers = nil
+			if err := json.Unmarshal(headers, &e.Metadata.Headers); err != nil {
+				outError <- fmt.Errorf("invalid headers of event %d: %w", e.Sequence, err)
+				return
+			}
+			if len(e.Metadata.Headers) == 0 {
+				e.Metadata.Headers = nil
+			}
+
+			select {
+			case outEvent <- e:
+			case <-ctx.Done():
+				return
+			}
 		}
 
 		err = rows.Err()
@@ -137,3 +524,14 @@ func (t *PostgresTransactor) ReadEvents() (<-chan Event, <-chan error) {
 
 	return outEvent, outError
 }
+
+func timestamptz(t time.Time) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: t, Valid: !t.IsZero()}
+}
+
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
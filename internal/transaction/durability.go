@@ -0,0 +1,134 @@
+package transaction
+
+import (
+	"cloud/internal/config"
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Durability selects when a write is acknowledged
+type Durability string
+
+const (
+	// DurabilityAsync acknowledges once the event is queued for the writer
+	DurabilityAsync Durability = "async"
+	// DurabilityGroupCommit acknowledges once the event is durable, concurrent
+	// writes share a single fsync or INSERT
+	DurabilityGroupCommit Durability = "group_commit"
+	// DurabilitySync acknowledges once the event is durable, every write is committed on its own
+	DurabilitySync Durability = "sync"
+
+	defaultGroupCommitSize = 256
+)
+
+// writeRequest carries an event to the writer goroutine
+type writeRequest struct {
+	event  Event
+	result chan error        // receives the outcome of the commit, nil in async mode
+	span   trace.SpanContext // span of the write, the commit links to it
+}
+
+// events returns the events of the request, unframing a batch
+func (r writeRequest) events() []Event {
+	if r.event.EventType == EventBatch {
+		return r.event.batch
+	}
+	return []Event{r.event}
+}
+
+type writerOptions struct {
+	durability Durability
+	groupSize  int
+	groupDelay time.Duration
+}
+
+func newWriterOptions(cfg config.JournalConfig) (writerOptions, error) {
+	opts := writerOptions{
+		durability: Durability(cfg.Durability),
+		groupSize:  cfg.GroupCommitSize,
+		groupDelay: cfg.GroupCommitDelay,
+	}
+
+	switch opts.durability {
+	case "":
+		opts.durability = DurabilityGroupCommit
+	case DurabilityAsync, DurabilityGroupCommit, DurabilitySync:
+	default:
+		return writerOptions{}, fmt.Errorf("unknown journal durability %q", cfg.Durability)
+	}
+
+	if opts.groupSize <= 0 {
+		opts.groupSize = defaultGroupCommitSize
+	}
+	return opts, nil
+}
+
+// newRequest prepares a request, waited on unless writes are asynchronous
+func (o writerOptions) newRequest(ctx context.Context, event Event) writeRequest {
+	req := writeRequest{event: event, span: trace.SpanContextFromContext(ctx)}
+	if o.durability != DurabilityAsync {
+		req.result = make(chan error, 1)
+	}
+	return req
+}
+
+// gather collects the requests to commit together with first. In sync mode it
+// is the first request alone, otherwise the queued requests are added until
+// groupSize, waiting up to groupDelay for more.
+func (o writerOptions) gather(first writeRequest, queue <-chan writeRequest) []writeRequest {
+	batch := []writeRequest{first}
+	if o.durability == DurabilitySync {
+		return batch
+	}
+
+	var timeout <-chan time.Time
+	if o.groupDelay > 0 {
+		timer := time.NewTimer(o.groupDelay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for len(batch) < o.groupSize {
+		if timeout == nil {
+			select {
+			case req := <-queue:
+				batch = append(batch, req)
+			default:
+				return batch
+			}
+			continue
+		}
+
+		select {
+		case req := <-queue:
+			batch = append(batch, req)
+		case <-timeout:
+			return batch
+		}
+	}
+	return batch
+}
+
+// number gives the events without a sequence the ones following last and
+// returns the sequence the journal continues after
+func number(last uint64, events []Event) uint64 {
+	for i := range events {
+		if events[i].Sequence == 0 {
+			events[i].Sequence = last + 1
+		}
+		last = max(last, events[i].Sequence)
+	}
+	return last
+}
+
+// acknowledge reports the outcome of a commit to the waiting writers
+func acknowledge(batch []writeRequest, err error) {
+	for _, req := range batch {
+		if req.result != nil {
+			req.result <- err
+		}
+	}
+}
@@ -10,4 +10,7 @@ var (
 	ErrTransactorClosed = errors.New("file transactor is closed")
 	ErrOutOfSequence    = errors.New("transaction numbers out of sequence")
 	ErrEmptyJournal     = errors.New("empty journal")
+	ErrCompacted        = errors.New("sequence was compacted into a snapshot")
+	ErrJournalDegraded  = errors.New("journal is degraded")
+	ErrWriterStopped    = errors.New("journal writer has stopped")
 )
@@ -1,15 +1,39 @@
 package transaction
 
+import "time"
+
 type EventType byte
 
 const (
 	EventDelete EventType = iota + 1
 	EventPut
+	EventExpire
+	EventBatch // frames the events of an atomic batch, never returned by ReadEvents
+	EventDrop  // removes every key of Namespace
+	EventIncr  // sets Key to the integer in Value, the result of an increment
 )
 
+// Metadata describes a value beyond its bytes, puts journal it with the value
+type Metadata struct {
+	ContentType string
+	Headers     map[string]string // user-defined headers by lower case name
+	CreatedAt   time.Time         // first write of the key, zero if unknown
+	UpdatedAt   time.Time         // last write of the key, zero if unknown
+}
+
 type Event struct {
 	Sequence  uint64
 	EventType EventType
+	Namespace string // empty for the default namespace
 	Key       string
 	Value     string
+	ExpiresAt time.Time // zero if the key never expires
+	Metadata  Metadata  // only set on puts and increments
+
+	batch []Event // events of an EventBatch on their way to the writer
+}
+
+// Expired reports whether the event carries an expiry that is already in the past.
+func (e Event) Expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
 }
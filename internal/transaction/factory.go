@@ -22,9 +22,9 @@ func NewTransactorFactory(cfg *config.Config) *TransactorFactory {
 func (f *TransactorFactory) Create(ctx context.Context, transactorType string) (Transactor, error) {
 	switch transactorType {
 	case TransactorTypeInMemory:
-		return NewFileTransactor(ctx)
+		return NewFileTransactor(ctx, f.cfg.Journal)
 	case TransactorTypePostgres:
-		return NewPostgresTransactor(ctx, f.cfg.Postgres)
+		return NewPostgresTransactor(ctx, f.cfg.Postgres, f.cfg.Journal)
 	default:
 		return nil, errors.New("unknown transactor type: " + transactorType)
 	}
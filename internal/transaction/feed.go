@@ -0,0 +1,65 @@
+package transaction
+
+import "sync"
+
+// Feed fans written events out to subscribers. A subscriber that cannot keep
+// up is dropped and its channel closed, it is expected to resume by replaying
+// the journal from the last sequence it saw.
+type Feed struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Subscribe registers a subscriber, the returned func cancels the subscription
+func (f *Feed) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	f.mu.Lock()
+	if f.subs == nil {
+		f.subs = make(map[chan Event]struct{})
+	}
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+
+	cancel := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if _, ok := f.subs[ch]; ok {
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// Publish never blocks the writer
+func (f *Feed) Publish(events ...Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for ch := range f.subs {
+		for _, e := range events {
+			select {
+			case ch <- e:
+				continue
+			default:
+			}
+
+			delete(f.subs, ch)
+			close(ch)
+			break
+		}
+	}
+}
+
+// CloseAll ends every subscription when the transactor shuts down
+func (f *Feed) CloseAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for ch := range f.subs {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,69 @@
+package transaction
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// recoveryInterval is how often a degraded writer probes its storage
+const recoveryInterval = time.Second
+
+// Health describes whether the journal accepts writes
+type Health struct {
+	Healthy   bool
+	LastError error     // the failure that degraded the journal, nil while healthy
+	Since     time.Time // when the journal entered its current state, zero if it never changed
+}
+
+// health tracks the writer state, the zero value is healthy
+type health struct {
+	mu    sync.RWMutex
+	err   error
+	since time.Time
+}
+
+func (h *health) get() Health {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return Health{Healthy: h.err == nil, LastError: h.err, Since: h.since}
+}
+
+// check returns ErrJournalDegraded wrapping the cause while unhealthy
+func (h *health) check() error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.err != nil {
+		return fmt.Errorf("%w: %v", ErrJournalDegraded, h.err)
+	}
+	return nil
+}
+
+func (h *health) degraded() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.err != nil
+}
+
+func (h *health) fail(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.err == nil {
+		h.since = time.Now()
+	}
+	h.err = err
+}
+
+func (h *health) recover() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.err != nil {
+		h.err = nil
+		h.since = time.Now()
+	}
+}
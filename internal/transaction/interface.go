@@ -5,10 +5,37 @@ import (
 )
 
 type Transactor interface {
-	WritePut(ctx context.Context, key, value string) error
-	WriteDelete(ctx context.Context, key string) error
+	// Append queues events as one atomic unit behind every unit appended before
+	// and returns a wait that blocks until it is durable, replay yields all or
+	// none of them. Events keep the sequence they carry, those without one get
+	// the next of the journal.
+	Append(ctx context.Context, events []Event) (wait func() error, err error)
 
+	// WriteSnapshot persists the full state as of snapshot.Sequence and compacts
+	// the journal up to it, the events after it are kept
+	WriteSnapshot(ctx context.Context, snapshot Snapshot) error
+	// ReadSnapshot must be called before ReadEvents, which then yields only the tail
+	ReadSnapshot() (Snapshot, error)
 	ReadEvents() (<-chan Event, <-chan error)
+	// ReplayEvents yields journal events after the sequence while the transactor keeps running
+	ReplayEvents(ctx context.Context, after uint64) (<-chan Event, <-chan error)
+	// LastSequence returns the sequence of the last journaled event
+	LastSequence() (uint64, error)
+	// Subscribe streams events once written, the channel is closed if the subscriber lags behind
+	Subscribe(buffer int) (<-chan Event, func())
+	// Health reports whether writes are accepted, a failed write degrades the
+	// journal and further writes fail with ErrJournalDegraded until it recovers
+	Health() Health
+	// Ping checks that the writer is running and the journal storage answers
+	Ping(ctx context.Context) error
 
 	Close() error
 }
+
+// Applier is a transactor that applies the committed events to the store
+// itself, as a replicated log does on every node. The store only hands it the
+// events of a write, the wait returned by Append blocks until they are applied.
+type Applier interface {
+	Transactor
+	AppliesCommitted()
+}
@@ -0,0 +1,342 @@
+package transaction
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"slices"
+	"time"
+)
+
+// The journal starts with journalMagic and a version byte, followed by records:
+//
+//	uint32 payload length | uint32 CRC-32C of the payload | payload
+//
+// A payload holds the events written together, a single event or a whole batch:
+//
+//	uvarint count, then per event:
+//	uvarint sequence | byte type | varint expires at (unix nanos, 0 = none) |
+//	uvarint key length | key | uvarint value length | value |
+//	uvarint namespace length | namespace | metadata
+//
+// The metadata of an event is:
+//
+//	uvarint content type length | content type | uvarint header count |
+//	per header: uvarint name length | name | uvarint value length | value |
+//	varint created at | varint updated at (unix nanos, 0 = unknown)
+//
+// Version 1 journals lack the namespace and version 2 journals the metadata,
+// they are upgraded when opened.
+const (
+	journalMagic     = "KVJL"
+	journalVersion   = 3
+	journalHeader    = len(journalMagic) + 1
+	recordHeaderSize = 8
+	maxRecordSize    = 1 << 30
+)
+
+var (
+	ErrUnknownJournalFormat = errors.New("unknown journal format")
+	// ErrTornRecord reports a damaged tail, the remains of a write cut short by a crash
+	ErrTornRecord = errors.New("journal ends with a torn record")
+	// ErrCorruptRecord reports a damaged record followed by intact data
+	ErrCorruptRecord = errors.New("journal record is corrupted")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func journalHeaderBytes() []byte {
+	return append([]byte(journalMagic), journalVersion)
+}
+
+// appendRecord encodes the events as one record
+func appendRecord(buf []byte, events []Event) []byte {
+	var payload []byte
+	payload = binary.AppendUvarint(payload, uint64(len(events)))
+	for _, e := range events {
+		payload = binary.AppendUvarint(payload, e.Sequence)
+		payload = append(payload, byte(e.EventType))
+		payload = binary.AppendVarint(payload, unixNanoOrZero(e.ExpiresAt))
+		payload = binary.AppendUvarint(payload, uint64(len(e.Key)))
+		payload = append(payload, e.Key...)
+		payload = binary.AppendUvarint(payload, uint64(len(e.Value)))
+		payload = append(payload, e.Value...)
+		payload = binary.AppendUvarint(payload, uint64(len(e.Namespace)))
+		payload = append(payload, e.Namespace...)
+		payload = appendMetadata(payload, e.Metadata)
+	}
+
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(payload, crcTable))
+	return append(buf, payload...)
+}
+
+// decodeRecord reads a record written by the given journal version
+func decodeRecord(payload []byte, version byte) ([]Event, error) {
+	r := bytes.NewReader(payload)
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(len(payload)) {
+		return nil, fmt.Errorf("%w: invalid event count", ErrCorruptRecord)
+	}
+
+	events := make([]Event, 0, count)
+	for range count {
+		var e Event
+
+		if e.Sequence, err = binary.ReadUvarint(r); err != nil {
+			return nil, fmt.Errorf("%w: invalid sequence", ErrCorruptRecord)
+		}
+		eventType, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid event type", ErrCorruptRecord)
+		}
+		e.EventType = EventType(eventType)
+		expiresAt, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid expiry", ErrCorruptRecord)
+		}
+		e.ExpiresAt = timeFromUnixNano(expiresAt)
+		if e.Key, err = readString(r); err != nil {
+			return nil, fmt.Errorf("%w: invalid key", ErrCorruptRecord)
+		}
+		if e.Value, err = readString(r); err != nil {
+			return nil, fmt.Errorf("%w: invalid value", ErrCorruptRecord)
+		}
+		if version >= 2 {
+			if e.Namespace, err = readString(r); err != nil {
+				return nil, fmt.Errorf("%w: invalid namespace", ErrCorruptRecord)
+			}
+		}
+		if version >= 3 {
+			if e.Metadata, err = readMetadata(r); err != nil {
+				return nil, fmt.Errorf("%w: invalid metadata", ErrCorruptRecord)
+			}
+		}
+
+		events = append(events, e)
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%w: trailing bytes", ErrCorruptRecord)
+	}
+	return events, nil
+}
+
+// appendMetadata encodes the headers sorted by name, so equal metadata is
+// always encoded alike
+func appendMetadata(buf []byte, meta Metadata) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(meta.ContentType)))
+	buf = append(buf, meta.ContentType...)
+
+	names := slices.Sorted(maps.Keys(meta.Headers))
+	buf = binary.AppendUvarint(buf, uint64(len(names)))
+	for _, name := range names {
+		buf = binary.AppendUvarint(buf, uint64(len(name)))
+		buf = append(buf, name...)
+		buf = binary.AppendUvarint(buf, uint64(len(meta.Headers[name])))
+		buf = append(buf, meta.Headers[name]...)
+	}
+
+	buf = binary.AppendVarint(buf, unixNanoOrZero(meta.CreatedAt))
+	return binary.AppendVarint(buf, unixNanoOrZero(meta.UpdatedAt))
+}
+
+func readMetadata(r *bytes.Reader) (Metadata, error) {
+	var (
+		meta Metadata
+		err  error
+	)
+	if meta.ContentType, err = readString(r); err != nil {
+		return Metadata{}, err
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return Metadata{}, err
+	}
+	if count > uint64(r.Len()) {
+		return Metadata{}, io.ErrUnexpectedEOF
+	}
+	if count > 0 {
+		meta.Headers = make(map[string]string, count)
+	}
+	for range count {
+		name, err := readString(r)
+		if err != nil {
+			return Metadata{}, err
+		}
+		if meta.Headers[name], err = readString(r); err != nil {
+			return Metadata{}, err
+		}
+	}
+
+	createdAt, err := binary.ReadVarint(r)
+	if err != nil {
+		return Metadata{}, err
+	}
+	updatedAt, err := binary.ReadVarint(r)
+	if err != nil {
+		return Metadata{}, err
+	}
+	meta.CreatedAt = timeFromUnixNano(createdAt)
+	meta.UpdatedAt = timeFromUnixNano(updatedAt)
+	return meta, nil
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > uint64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+// scanJournal calls emit for every event with a sequence greater than after,
+// until emit returns false. The events of a record are emitted only once the
+// whole record was read and its checksum verified. It returns the last
+// sequence read and the offset just past the last intact record, where a
+// damaged tail reported by ErrTornRecord can be cut.
+func scanJournal(r io.Reader, after uint64, emit func(Event) bool) (uint64, int64, error) {
+	var (
+		br     = bufio.NewReader(r)
+		last   = after
+		offset int64
+		header = make([]byte, recordHeaderSize)
+	)
+
+	magic := make([]byte, journalHeader)
+	n, err := io.ReadFull(br, magic)
+	switch {
+	case n == 0 && errors.Is(err, io.EOF):
+		return last, 0, nil
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return last, 0, fmt.Errorf("%w: incomplete journal header", ErrTornRecord)
+	case err != nil:
+		return last, 0, fmt.Errorf("transaction log read failure: %w", err)
+	case !bytes.HasPrefix(magic, []byte(journalMagic)) || magic[len(journalMagic)] == 0 ||
+		magic[len(journalMagic)] > journalVersion:
+		return last, 0, ErrUnknownJournalFormat
+	}
+	version := magic[len(journalMagic)]
+	offset = int64(journalHeader)
+
+	for {
+		_, err := io.ReadFull(br, header)
+		if errors.Is(err, io.EOF) {
+			return last, offset, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return last, offset, fmt.Errorf("%w: incomplete record header at offset %d", ErrTornRecord, offset)
+		}
+		if err != nil {
+			return last, offset, fmt.Errorf("transaction log read failure: %w", err)
+		}
+
+		size := binary.BigEndian.Uint32(header)
+		if size == 0 || size > maxRecordSize {
+			return last, offset, damaged(br, nil, offset, "invalid record length")
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return last, offset, fmt.Errorf("%w: incomplete record at offset %d", ErrTornRecord, offset)
+			}
+			return last, offset, fmt.Errorf("transaction log read failure: %w", err)
+		}
+
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+			return last, offset, damaged(br, payload, offset, "checksum mismatch")
+		}
+
+		events, err := decodeRecord(payload, version)
+		if err != nil {
+			return last, offset, fmt.Errorf("%w at offset %d", err, offset)
+		}
+		offset += int64(recordHeaderSize) + int64(size)
+
+		for _, e := range events {
+			if e.Sequence <= after {
+				continue // already part of the snapshot
+			}
+			if last >= e.Sequence {
+				return last, offset, ErrOutOfSequence
+			}
+			last = e.Sequence
+
+			if !emit(e) {
+				return last, offset, nil
+			}
+		}
+	}
+}
+
+// damaged tells a torn tail from a corrupted record. A crash tears the records
+// being written and leaves garbage or zeroed blocks after them, so the record
+// is corrupted only if an intact record follows it. payload holds what was
+// read of the damaged record after its header.
+func damaged(r io.Reader, payload []byte, offset int64, reason string) error {
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("transaction log read failure: %w", err)
+	}
+
+	if followedByRecord(append(payload, rest...), len(payload)) {
+		return fmt.Errorf("%w: %s at offset %d", ErrCorruptRecord, reason, offset)
+	}
+	return fmt.Errorf("%w: %s at offset %d", ErrTornRecord, reason, offset)
+}
+
+// followedByRecord reports whether an intact record starts anywhere in b. The
+// declared end of the damaged record at next is tried first, a corrupted
+// length means searching every offset.
+func followedByRecord(b []byte, next int) bool {
+	if intactRecordAt(b, next) {
+		return true
+	}
+	for i := range len(b) {
+		if intactRecordAt(b, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// intactRecordAt reports whether b holds a whole record at i whose checksum matches
+func intactRecordAt(b []byte, i int) bool {
+	if i+recordHeaderSize > len(b) {
+		return false
+	}
+	size := binary.BigEndian.Uint32(b[i:])
+	if size == 0 || size > maxRecordSize || int64(size) > int64(len(b)-i-recordHeaderSize) {
+		return false
+	}
+	payload := b[i+recordHeaderSize : i+recordHeaderSize+int(size)]
+	return crc32.Checksum(payload, crcTable) == binary.BigEndian.Uint32(b[i+4:])
+}
+
+func unixNanoOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func timeFromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
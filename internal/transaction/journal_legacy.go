@@ -0,0 +1,160 @@
+package transaction
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// upgradeJournal rewrites a journal written in an older format as records of
+// the current version: tab separated text rows, with or without an expiry
+// column, written before the binary format, or binary records without
+// namespaces or metadata. Batches of text rows cut short by a crash are
+// dropped, as is a torn tail of binary records.
+func upgradeJournal(name string) error {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read transaction log file: %w", err)
+	}
+	if len(data) == 0 || bytes.HasPrefix(data, journalHeaderBytes()) {
+		return nil
+	}
+
+	journal := journalHeaderBytes()
+	emit := func(e Event) bool {
+		journal = appendRecord(journal, []Event{e})
+		return true
+	}
+
+	if bytes.HasPrefix(data, []byte(journalMagic)) {
+		_, _, err = scanJournal(bytes.NewReader(data), 0, emit)
+		if errors.Is(err, ErrTornRecord) {
+			err = nil
+		}
+	} else {
+		_, err = scanLegacyJournal(bytes.NewReader(data), 0, emit)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot upgrade journal: %w", err)
+	}
+
+	tmp := name + ".tmp"
+	if err := writeFileSync(tmp, journal); err != nil {
+		return fmt.Errorf("cannot write upgraded journal: %w", err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return fmt.Errorf("cannot replace journal: %w", err)
+	}
+	return nil
+}
+
+func writeFileSync(name string, data []byte) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// scanLegacyJournal reads text rows, a batch is framed by an EventBatch row
+// carrying the event count in its key
+func scanLegacyJournal(r io.Reader, after uint64, emit func(Event) bool) (uint64, error) {
+	var (
+		scanner = bufio.NewScanner(r)
+		last    = after
+		pending []Event // events of the batch being read
+		expect  int     // events still missing from the batch
+	)
+
+	for scanner.Scan() {
+		e, err := parseJournalRow(scanner.Text())
+		if err != nil {
+			return last, err
+		}
+
+		if e.Sequence <= after {
+			continue
+		}
+
+		if last >= e.Sequence {
+			return last, ErrOutOfSequence
+		}
+		last = e.Sequence
+
+		if e.EventType == EventBatch {
+			expect, err = strconv.Atoi(e.Key)
+			if err != nil {
+				return last, fmt.Errorf("batch header decoding failure: %w", err)
+			}
+			pending = pending[:0]
+			continue
+		}
+
+		if expect > 0 {
+			pending = append(pending, e)
+			if expect--; expect > 0 {
+				continue
+			}
+			for _, pe := range pending {
+				if !emit(pe) {
+					return last, nil
+				}
+			}
+			continue
+		}
+
+		if !emit(e) {
+			return last, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return last, fmt.Errorf("transaction log read failure: %w", err)
+	}
+	return last, nil
+}
+
+// parseJournalRow reads a row with an expiry column, or a row of the first
+// format, written before keys could expire, that has no expiry column
+func parseJournalRow(line string) (Event, error) {
+	var (
+		e         Event
+		expiresAt int64
+	)
+
+	if strings.Count(line, "\t") == 3 {
+		_, _ = fmt.Sscanf(
+			line, "%d\t%d\t%s\t%s",
+			&e.Sequence, &e.EventType, &e.Key, &e.Value)
+	} else {
+		_, _ = fmt.Sscanf(
+			line, "%d\t%d\t%d\t%s\t%s",
+			&e.Sequence, &e.EventType, &expiresAt, &e.Key, &e.Value)
+		e.ExpiresAt = timeFromUnixNano(expiresAt)
+	}
+
+	uv, err := url.QueryUnescape(e.Value)
+	if err != nil {
+		return Event{}, fmt.Errorf("value decoding failure: %w", err)
+	}
+	e.Value = uv
+
+	return e, nil
+}
@@ -0,0 +1,35 @@
+package transaction
+
+import (
+	"cloud/internal/tracing"
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("cloud/internal/transaction")
+
+// startCommit starts the span of a commit. The writer commits requests of
+// many callers at once, so the span is a root linked to the span of each.
+func startCommit(op string, batch []writeRequest, events int) (context.Context, trace.Span) {
+	links := make([]trace.Link, 0, len(batch))
+	for _, req := range batch {
+		if req.span.IsValid() {
+			links = append(links, trace.Link{SpanContext: req.span})
+		}
+	}
+	return tracer.Start(context.Background(), op,
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.Int("journal.requests", len(batch)),
+			attribute.Int("journal.events", events),
+		),
+	)
+}
+
+// endSpan ends a transactor span, recording err if any
+func endSpan(span trace.Span, err error) {
+	tracing.End(span, err)
+}
@@ -1,36 +1,86 @@
 package transaction
 
 import (
-	"bufio"
+	"cloud/internal/config"
+	"cloud/internal/metrics"
 	"context"
+	"errors"
 	"fmt"
-	"net/url"
+	"io"
+	"math"
 	"os"
+	"slices"
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var _ Transactor = &FileTransactor{}
 
 type FileTransactor struct {
-	events       chan Event
-	errors       chan error
-	done         chan struct{}
-	lastSequence uint64
-	closed       uint32
-	file         *os.File
+	events           chan writeRequest
+	tasks            chan writerTask
+	done             chan struct{}
+	stopped          chan struct{} // closed once the writer has exited
+	opts             writerOptions
+	lastSequence     uint64 // atomic, read by LastSequence while the writer runs
+	snapshotSequence uint64 // journal rows up to this sequence are covered by the snapshot, atomic
+	closed           uint32
+	file             *os.File
+	size             int64 // end of the intact records, a failed write is cut back to it
+	feed             Feed
+	health           health
+
+	appendMu  sync.Mutex // queues the records in sequence order
+	compactMu sync.Mutex // one compaction at a time
 }
 
-func NewFileTransactor(ctx context.Context) (*FileTransactor, error) {
+// writerTask runs on the writer goroutine between two commits
+type writerTask struct {
+	fn     func() error
+	result chan error
+}
+
+func NewFileTransactor(ctx context.Context, cfg config.JournalConfig) (*FileTransactor, error) {
+	opts, err := newWriterOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := upgradeJournal(filename); err != nil {
+		return nil, err
+	}
+
 	file, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0755)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open transaction log file: %w", err)
 	}
 
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("cannot stat transaction log file: %w", err)
+	}
+	size := info.Size()
+	if size == 0 {
+		if _, err := file.Write(journalHeaderBytes()); err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("cannot write journal header: %w", err)
+		}
+		size = int64(journalHeader)
+	}
+
 	t := &FileTransactor{
-		events: make(chan Event, 128),
-		errors: make(chan error),
-		done:   make(chan struct{}),
-		file:   file,
+		events:  make(chan writeRequest, 128),
+		tasks:   make(chan writerTask),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		opts:    opts,
+		file:    file,
+		size:    size,
 	}
 	t.run(ctx)
 
@@ -42,6 +92,8 @@ func (t *FileTransactor) Close() error {
 		return ErrTransactorClosed
 	}
 	close(t.done)
+	<-t.stopped
+	t.feed.CloseAll()
 
 	if err := t.file.Sync(); err != nil {
 		return fmt.Errorf("sync error: %w", err)
@@ -49,45 +101,82 @@ func (t *FileTransactor) Close() error {
 	return t.file.Close()
 }
 
-func (t *FileTransactor) WritePut(ctx context.Context, key, value string) error {
-	return t.send(ctx, Event{Key: key, Value: value, EventType: EventPut})
-}
-
-func (t *FileTransactor) WriteDelete(ctx context.Context, key string) error {
-	return t.send(ctx, Event{Key: key, Value: "", EventType: EventDelete})
-}
+// Append hands the events to the writer as one record, records are written in
+// the order they were appended. The wait returns once the record is on disk,
+// at once if writes are asynchronous. A queued record is never abandoned, so
+// ctx only bounds the wait for room in the queue.
+func (t *FileTransactor) Append(ctx context.Context, events []Event) (_ func() error, err error) {
+	ctx, span := tracer.Start(ctx, "FileTransactor.write", trace.WithAttributes(
+		attribute.Int("journal.events", len(events)),
+	))
+	defer func() {
+		if err != nil {
+			endSpan(span, err)
+		}
+	}()
 
-func (t *FileTransactor) send(ctx context.Context, event Event) error {
 	if atomic.LoadUint32(&t.closed) == 1 {
-		return ErrTransactorClosed
+		return nil, ErrTransactorClosed
+	}
+	if err := t.health.check(); err != nil {
+		return nil, err
 	}
 
+	events = slices.Clone(events)
+	req := t.opts.newRequest(ctx, Event{EventType: EventBatch, batch: events})
+
+	t.appendMu.Lock()
+	defer t.appendMu.Unlock()
+
+	last := number(atomic.LoadUint64(&t.lastSequence), events)
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
-	case t.events <- event:
-		return nil
-	default:
-		return nil
+		return nil, ctx.Err()
+	case t.events <- req:
+		atomic.StoreUint64(&t.lastSequence, last)
+		metrics.SetJournalQueueDepth(len(t.events))
+	case <-t.done:
+		return nil, ErrTransactorClosed
 	}
+
+	return func() (err error) {
+		defer func() {
+			endSpan(span, err)
+		}()
+
+		if req.result == nil {
+			return nil
+		}
+		select {
+		case err = <-req.result:
+			return err
+		case <-t.stopped:
+			return ErrTransactorClosed
+		}
+	}, nil
 }
 
 func (t *FileTransactor) run(ctx context.Context) {
 	go func() {
-		for {
-			select {
-			case event := <-t.events:
-				t.lastSequence++
+		defer close(t.stopped)
 
-				journalRow := fmt.Sprintf(
-					"%d\t%d\t%s\t%s\n",
-					t.lastSequence, event.EventType, event.Key, event.Value)
+		ticker := time.NewTicker(recoveryInterval)
+		defer ticker.Stop()
 
-				_, err := t.file.WriteString(journalRow)
-				if err != nil {
-					t.errors <- err
+		for {
+			select {
+			case req := <-t.events:
+				batch := t.opts.gather(req, t.events)
+				metrics.SetJournalQueueDepth(len(t.events))
+				t.commit(batch)
+			case task := <-t.tasks:
+				task.result <- task.fn()
+			case <-ticker.C:
+				if t.health.degraded() {
+					t.recover()
 				}
 			case <-t.done:
+				t.drain()
 				return
 			case <-ctx.Done():
 				return
@@ -96,14 +185,246 @@ func (t *FileTransactor) run(ctx context.Context) {
 	}()
 }
 
+// drain commits the requests queued before Close
+func (t *FileTransactor) drain() {
+	for {
+		select {
+		case req := <-t.events:
+			t.commit(t.opts.gather(req, t.events))
+		default:
+			return
+		}
+	}
+}
+
+// commit writes the requests with a single write, each one as a record so a
+// batch is either read back whole or not at all, and fsyncs unless writes are
+// asynchronous. A failure degrades the journal, an asynchronous writer has no
+// caller left to tell.
+func (t *FileTransactor) commit(batch []writeRequest) {
+	var (
+		journal []byte
+		written []Event
+	)
+	for _, req := range batch {
+		events := req.events()
+		journal = appendRecord(journal, events)
+		written = append(written, events...)
+	}
+
+	_, span := startCommit("FileTransactor.commit", batch, len(written))
+	start := time.Now()
+	_, err := t.file.Write(journal)
+	if err == nil && t.opts.durability != DurabilityAsync {
+		if err = t.file.Sync(); err != nil {
+			err = fmt.Errorf("sync error: %w", err)
+		}
+	}
+	metrics.ObserveJournalWrite(time.Since(start), err)
+	endSpan(span, err)
+	if err != nil {
+		// writes are refused until recover cuts the partial record off,
+		// it would hide every record written after it
+		t.health.fail(err)
+		acknowledge(batch, err)
+		return
+	}
+
+	t.size += int64(len(journal))
+	acknowledge(batch, nil)
+	t.feed.Publish(written...)
+}
+
+// recover heals the journal once it is back to its intact records on disk
+func (t *FileTransactor) recover() {
+	if err := t.file.Truncate(t.size); err != nil {
+		t.health.fail(fmt.Errorf("cannot truncate journal: %w", err))
+		return
+	}
+	if err := t.file.Sync(); err != nil {
+		t.health.fail(fmt.Errorf("sync error: %w", err))
+		return
+	}
+	t.health.recover()
+}
+
+// Health reports whether the journal accepts writes
+func (t *FileTransactor) Health() Health {
+	return t.health.get()
+}
+
+// Ping reports whether the writer goroutine still runs
+func (t *FileTransactor) Ping(context.Context) error {
+	if atomic.LoadUint32(&t.closed) == 1 {
+		return ErrTransactorClosed
+	}
+	select {
+	case <-t.stopped:
+		return ErrWriterStopped
+	default:
+		return nil
+	}
+}
+
+// LastSequence returns the sequence of the last event handed to the journal
+func (t *FileTransactor) LastSequence() (uint64, error) {
+	return atomic.LoadUint64(&t.lastSequence), nil
+}
+
+// Subscribe streams every event after it is written to the journal
+func (t *FileTransactor) Subscribe(buffer int) (<-chan Event, func()) {
+	return t.feed.Subscribe(buffer)
+}
+
+// WriteSnapshot persists the snapshot and drops the journal records it covers.
+// It blocks until the snapshot is on disk. Compaction runs on the calling
+// goroutine, the writer keeps committing and only pauses to swap journals.
+func (t *FileTransactor) WriteSnapshot(ctx context.Context, snapshot Snapshot) error {
+	if atomic.LoadUint32(&t.closed) == 1 {
+		return ErrTransactorClosed
+	}
+
+	t.compactMu.Lock()
+	defer t.compactMu.Unlock()
+
+	return t.compact(ctx, snapshot)
+}
+
+// onWriter runs fn on the writer goroutine, ctx bounds the wait for the writer
+// to take it
+func (t *FileTransactor) onWriter(ctx context.Context, fn func() error) error {
+	task := writerTask{fn: fn, result: make(chan error, 1)}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case t.tasks <- task:
+	case <-t.done:
+		return ErrTransactorClosed
+	}
+	return <-task.result
+}
+
+// compact writes the snapshot and a journal holding the events after it as a
+// single record. The journal is read up to the end the writer reported, the
+// records committed meanwhile are moved over by swapJournal.
+func (t *FileTransactor) compact(ctx context.Context, snapshot Snapshot) error {
+	var (
+		file *os.File
+		end  int64
+	)
+	err := t.onWriter(ctx, func() error {
+		file, end = t.file, t.size
+		return t.health.check()
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := EncodeSnapshot(snapshot)
+	if err != nil {
+		return fmt.Errorf("snapshot encoding failure: %w", err)
+	}
+
+	tmp := snapshotFilename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("cannot write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, snapshotFilename); err != nil {
+		return fmt.Errorf("cannot replace snapshot: %w", err)
+	}
+	atomic.StoreUint64(&t.snapshotSequence, snapshot.Sequence)
+
+	// the writer only appends after end, the file stays open until the swap
+	var tail []Event
+	_, _, err = scanJournal(io.NewSectionReader(file, 0, end), snapshot.Sequence, func(e Event) bool {
+		tail = append(tail, e)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("cannot read journal: %w", err)
+	}
+
+	journal := journalHeaderBytes()
+	if len(tail) > 0 {
+		journal = appendRecord(journal, tail)
+	}
+	tmp = filename + ".tmp"
+	if err := os.WriteFile(tmp, journal, 0755); err != nil {
+		return fmt.Errorf("cannot write journal: %w", err)
+	}
+
+	return t.onWriter(ctx, func() error {
+		return t.swapJournal(tmp, end)
+	})
+}
+
+// swapJournal runs on the writer goroutine. It appends the records committed
+// after scanned to the compacted journal at tmp and replaces the journal with
+// it, the old journal is kept on any failure.
+func (t *FileTransactor) swapJournal(tmp string, scanned int64) error {
+	if err := t.health.check(); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_APPEND, 0755)
+	if err != nil {
+		return fmt.Errorf("cannot open compacted journal: %w", err)
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err == nil {
+		var n int64
+		n, err = io.Copy(file, io.NewSectionReader(t.file, scanned, t.size-scanned))
+		size += n
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("cannot replace journal: %w", err)
+	}
+
+	_ = t.file.Close()
+	t.file = file
+	t.size = size
+	return nil
+}
+
+// ReadSnapshot returns the latest snapshot, an empty one if none was written.
+// It must be called before ReadEvents so replay skips covered rows.
+func (t *FileTransactor) ReadSnapshot() (Snapshot, error) {
+	data, err := os.ReadFile(snapshotFilename)
+	if errors.Is(err, os.ErrNotExist) {
+		return Snapshot{}, nil
+	}
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("cannot read snapshot: %w", err)
+	}
+
+	snapshot, err := DecodeSnapshot(data)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	atomic.StoreUint64(&t.snapshotSequence, snapshot.Sequence)
+	if atomic.LoadUint64(&t.lastSequence) < snapshot.Sequence {
+		atomic.StoreUint64(&t.lastSequence, snapshot.Sequence)
+	}
+	return snapshot, nil
+}
+
+// ReadEvents replays the journal after the snapshot. A torn tail left by a
+// crash is cut off, so new records are appended after the last intact one,
+// and reported with ErrTornRecord once the intact events were read.
 func (t *FileTransactor) ReadEvents() (<-chan Event, <-chan error) {
-	scanner := bufio.NewScanner(t.file)
 	outEvent := make(chan Event)
 	outError := make(chan error, 1)
 
 	go func() {
-		var e Event
-
 		defer close(outEvent)
 		defer close(outError)
 
@@ -112,32 +433,82 @@ func (t *FileTransactor) ReadEvents() (<-chan Event, <-chan error) {
 			return
 		}
 
-		for scanner.Scan() {
-			line := scanner.Text()
+		start := time.Now()
+		defer func() {
+			metrics.ObserveJournalReplay(time.Since(start))
+		}()
 
-			_, _ = fmt.Sscanf(
-				line, "%d\t%d\t%s\t%s",
-				&e.Sequence, &e.EventType, &e.Key, &e.Value)
+		journal := io.NewSectionReader(t.file, 0, math.MaxInt64)
+		last, offset, err := scanJournal(journal, atomic.LoadUint64(&t.snapshotSequence), func(e Event) bool {
+			outEvent <- e
+			return true
+		})
+		atomic.StoreUint64(&t.lastSequence, max(atomic.LoadUint64(&t.lastSequence), last))
 
-			if t.lastSequence >= e.Sequence {
-				outError <- fmt.Errorf("transaction numbers out of sequence")
-				return
+		if errors.Is(err, ErrTornRecord) {
+			if terr := t.truncateTail(offset); terr != nil {
+				err = terr
 			}
+		}
+		if err != nil {
+			outError <- err
+		}
+	}()
 
-			uv, err := url.QueryUnescape(e.Value)
-			if err != nil {
-				outError <- fmt.Errorf("value decoding failure: %w", err)
-				return
-			}
+	return outEvent, outError
+}
 
-			e.Value = uv
-			t.lastSequence = e.Sequence
+// truncateTail drops everything after offset, an empty journal gets its header back
+func (t *FileTransactor) truncateTail(offset int64) error {
+	if err := t.file.Truncate(offset); err != nil {
+		return fmt.Errorf("cannot truncate torn journal tail: %w", err)
+	}
+	t.size = offset
+	if offset == 0 {
+		if _, err := t.file.Write(journalHeaderBytes()); err != nil {
+			return fmt.Errorf("cannot write journal header: %w", err)
+		}
+		t.size = int64(journalHeader)
+	}
+	return t.file.Sync()
+}
 
-			outEvent <- e
+// ReplayEvents reads the journal through its own handle and yields the events
+// with a sequence greater than after. It is safe to call while the transactor
+// is writing, ErrCompacted is returned if after is older than the snapshot.
+func (t *FileTransactor) ReplayEvents(ctx context.Context, after uint64) (<-chan Event, <-chan error) {
+	outEvent := make(chan Event)
+	outError := make(chan error, 1)
+
+	go func() {
+		defer close(outEvent)
+		defer close(outError)
+
+		if after < atomic.LoadUint64(&t.snapshotSequence) {
+			outError <- ErrCompacted
+			return
 		}
 
-		if err := scanner.Err(); err != nil {
-			outError <- fmt.Errorf("transaction log read failure: %w", err)
+		file, err := os.Open(filename)
+		if err != nil {
+			outError <- fmt.Errorf("cannot open transaction log file: %w", err)
+			return
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+
+		_, _, err = scanJournal(file, after, func(e Event) bool {
+			select {
+			case outEvent <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		// a torn tail is a record the writer is appending right now
+		if err != nil && !errors.Is(err, ErrTornRecord) {
+			outError <- err
 		}
 	}()
 
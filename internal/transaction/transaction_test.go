@@ -1,12 +1,20 @@
 package transaction
 
 import (
+	"bytes"
+	"cloud/internal/config"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"maps"
 	"os"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 func fileExists(filename string) bool {
@@ -17,9 +25,23 @@ func fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
+// write appends the events and waits until they are journaled
+func write(ctx context.Context, tr Transactor, events []Event) error {
+	wait, err := tr.Append(ctx, events)
+	if err != nil {
+		return err
+	}
+	return wait()
+}
+
+// put journals a put of the key and waits for it
+func put(ctx context.Context, tr Transactor, key, value string, meta Metadata) error {
+	return write(ctx, tr, []Event{{EventType: EventPut, Key: key, Value: value, Metadata: meta}})
+}
+
 func TestCreateTransactor(t *testing.T) {
 	ctx := context.Background()
-	tr, err := NewFileTransactor(ctx)
+	tr, err := NewFileTransactor(ctx, config.JournalConfig{})
 	if err != nil {
 		t.Fatalf("cannot create transactor: %v", err)
 	}
@@ -41,7 +63,7 @@ func TestCreateTransactor(t *testing.T) {
 func TestConcurrentWritesAndRead(t *testing.T) {
 	ctx := context.Background()
 
-	transactor, err := NewFileTransactor(ctx)
+	transactor, err := NewFileTransactor(ctx, config.JournalConfig{})
 	if err != nil {
 		t.Fatalf("failed to create transactor: %v", err)
 	}
@@ -66,14 +88,14 @@ func TestConcurrentWritesAndRead(t *testing.T) {
 			key := fmt.Sprintf("worker:%d", id)
 			val := fmt.Sprintf("value-%d", id)
 
-			if err := transactor.WritePut(ctx, key, val); err != nil {
+			if err := put(ctx, transactor, key, val, Metadata{}); err != nil {
 				t.Errorf("worker %d error: %v", id, err)
 			}
 		}(i)
 	}
 	wg.Wait()
 
-	transactor1, err := NewFileTransactor(ctx)
+	transactor1, err := NewFileTransactor(ctx, config.JournalConfig{})
 	if err != nil {
 		t.Fatalf("failed to create transactor1: %v", err)
 	}
@@ -103,7 +125,7 @@ func TestConcurrentWritesAndRead(t *testing.T) {
 
 func TestSendClosedTransactor(t *testing.T) {
 	ctx := context.Background()
-	tr, err := NewFileTransactor(ctx)
+	tr, err := NewFileTransactor(ctx, config.JournalConfig{})
 	if err != nil {
 		t.Fatalf("cannot create transactor: %v", err)
 	}
@@ -118,18 +140,18 @@ func TestSendClosedTransactor(t *testing.T) {
 	const key = "key"
 	const value = "value"
 
-	if err := tr.WritePut(ctx, key, value); !errors.Is(err, ErrTransactorClosed) {
+	if err := put(ctx, tr, key, value, Metadata{}); !errors.Is(err, ErrTransactorClosed) {
 		t.Fatal("transactor is not closed")
 	}
 
-	if err := tr.WriteDelete(ctx, key); !errors.Is(err, ErrTransactorClosed) {
+	if err := write(ctx, tr, []Event{{EventType: EventDelete, Key: key}}); !errors.Is(err, ErrTransactorClosed) {
 		t.Fatal("transactor is not closed")
 	}
 }
 
 func TestClosedTransactor(t *testing.T) {
 	ctx := context.Background()
-	tr, err := NewFileTransactor(ctx)
+	tr, err := NewFileTransactor(ctx, config.JournalConfig{})
 	if err != nil {
 		t.Fatalf("cannot create transactor: %v", err)
 	}
@@ -147,3 +169,654 @@ func TestClosedTransactor(t *testing.T) {
 		t.Fatalf("got: %v, but expected: %v", err, ErrTransactorClosed)
 	}
 }
+
+func TestSnapshotCompaction(t *testing.T) {
+	ctx := context.Background()
+
+	tr, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	defer func() {
+		os.Remove(filename)
+		os.Remove(snapshotFilename)
+	}()
+
+	for i := 0; i < 3; i++ {
+		if err := put(ctx, tr, "hot", fmt.Sprintf("value-%d", i), Metadata{}); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+
+	// the snapshot is older than the last put, which must survive compaction
+	entries := []Event{{EventType: EventPut, Key: "hot", Value: "value-1"}}
+	if err := tr.WriteSnapshot(ctx, Snapshot{Sequence: 2, Entries: entries}); err != nil {
+		t.Fatalf("snapshot error: %v", err)
+	}
+	if err := put(ctx, tr, "cold", "value", Metadata{}); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	tr1, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	defer tr1.Close()
+
+	snapshot, err := tr1.ReadSnapshot()
+	if err != nil {
+		t.Fatalf("read snapshot error: %v", err)
+	}
+
+	state := make(map[string]string)
+	for _, e := range snapshot.Entries {
+		state[e.Key] = e.Value
+	}
+
+	eventsCh, errCh := tr1.ReadEvents()
+	for e := range eventsCh {
+		if e.Sequence <= snapshot.Sequence {
+			t.Errorf("event %d is covered by snapshot %d", e.Sequence, snapshot.Sequence)
+		}
+		state[e.Key] = e.Value
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+
+	if state["hot"] != "value-2" {
+		t.Errorf("got %q, want %q", state["hot"], "value-2")
+	}
+}
+
+func TestWritesDuringCompaction(t *testing.T) {
+	ctx := context.Background()
+
+	tr, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	defer func() {
+		os.Remove(filename)
+		os.Remove(snapshotFilename)
+	}()
+
+	if err := put(ctx, tr, "base", "value", Metadata{}); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	// a large snapshot keeps the compaction busy while the puts are committed
+	entries := make([]Event, 50000)
+	for i := range entries {
+		entries[i] = Event{EventType: EventPut, Key: fmt.Sprintf("entry-%d", i), Value: "value"}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := tr.WriteSnapshot(ctx, Snapshot{Sequence: 1, Entries: entries}); err != nil {
+			t.Errorf("snapshot error: %v", err)
+		}
+	}()
+	for i := range 200 {
+		if err := put(ctx, tr, fmt.Sprintf("key-%d", i), "value", Metadata{}); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	wg.Wait()
+	if err := tr.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	tr1, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	defer tr1.Close()
+
+	if _, err := tr1.ReadSnapshot(); err != nil {
+		t.Fatalf("read snapshot error: %v", err)
+	}
+	keys := make(map[string]bool)
+	eventsCh, errCh := tr1.ReadEvents()
+	for e := range eventsCh {
+		keys[e.Key] = true
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	for i := range 200 {
+		if key := fmt.Sprintf("key-%d", i); !keys[key] {
+			t.Errorf("%s was lost by compaction", key)
+		}
+	}
+}
+
+func TestTornBatchIsDropped(t *testing.T) {
+	ctx := context.Background()
+
+	tr, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	tr.Close()
+	defer os.Remove(filename)
+
+	journal := "1\t2\t0\tsolo\tvalue\n" +
+		"2\t4\t0\t2\t\n" +
+		"3\t2\t0\tfirst\tvalue\n" +
+		"4\t2\t0\tsecond\tvalue\n" +
+		"5\t4\t0\t2\t\n" +
+		"6\t2\t0\ttorn\tvalue\n"
+	if err := os.WriteFile(filename, []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr1, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	defer tr1.Close()
+
+	var keys []string
+	eventsCh, errCh := tr1.ReadEvents()
+	for e := range eventsCh {
+		keys = append(keys, e.Key)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+
+	if fmt.Sprint(keys) != "[solo first second]" {
+		t.Errorf("got keys %v, want [solo first second]", keys)
+	}
+}
+
+func TestBaselineJournalIsUpgraded(t *testing.T) {
+	ctx := context.Background()
+
+	tr, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	tr.Close()
+	defer os.Remove(filename)
+
+	// rows of the first format: sequence, type, key and value, without expiry
+	journal := "1\t2\tkey1\tvalue1\n" +
+		"2\t2\tkey2\thello%20world\n" +
+		"3\t1\tkey1\t\n"
+	if err := os.WriteFile(filename, []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr1, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+
+	var got []Event
+	eventsCh, errCh := tr1.ReadEvents()
+	for e := range eventsCh {
+		got = append(got, e)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+
+	want := []Event{
+		{Sequence: 1, EventType: EventPut, Key: "key1", Value: "value1"},
+		{Sequence: 2, EventType: EventPut, Key: "key2", Value: "hello world"},
+		{Sequence: 3, EventType: EventDelete, Key: "key1"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d", len(got), len(want))
+	}
+	for i, e := range got {
+		w := want[i]
+		if e.Sequence != w.Sequence || e.EventType != w.EventType || e.Key != w.Key || e.Value != w.Value || !e.ExpiresAt.IsZero() {
+			t.Errorf("event %d: got %+v, want %+v", i, e, w)
+		}
+	}
+
+	if err := put(ctx, tr1, "key3", "value3", Metadata{}); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if seq, _ := tr1.LastSequence(); seq != 4 {
+		t.Errorf("got last sequence %d, want 4", seq)
+	}
+	tr1.Close()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, journalHeaderBytes()) {
+		t.Error("journal was not rewritten in the current format")
+	}
+}
+
+func TestBinarySafeValues(t *testing.T) {
+	ctx := context.Background()
+
+	tr, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	defer os.Remove(filename)
+
+	want := []Event{
+		{EventType: EventPut, Key: "key with spaces", Value: "tab\there\nand newline"},
+		{EventType: EventPut, Key: "escaped", Value: "100%25 literal %"},
+		{EventType: EventPut, Key: "empty", Value: ""},
+		{EventType: EventDelete, Key: "key with spaces"},
+	}
+	for _, e := range want[:3] {
+		if err := put(ctx, tr, e.Key, e.Value, Metadata{}); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	if err := write(ctx, tr, []Event{{EventType: EventDelete, Key: want[3].Key}}); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	tr.Close()
+
+	tr1, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	defer tr1.Close()
+
+	var got []Event
+	eventsCh, errCh := tr1.ReadEvents()
+	for e := range eventsCh {
+		got = append(got, e)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Key != want[i].Key || got[i].Value != want[i].Value || got[i].EventType != want[i].EventType {
+			t.Errorf("event %d: got %q=%q, want %q=%q", i, got[i].Key, got[i].Value, want[i].Key, want[i].Value)
+		}
+	}
+
+	// compaction keeps a value that is not valid UTF-8
+	binary := Event{Sequence: 4, EventType: EventPut, Key: "binary", Value: "\xff\x00"}
+	if err := tr1.WriteSnapshot(ctx, Snapshot{Sequence: 4, Entries: []Event{binary}}); err != nil {
+		t.Fatalf("snapshot error: %v", err)
+	}
+	tr1.Close()
+	defer os.Remove(snapshotFilename)
+
+	tr2, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	defer tr2.Close()
+
+	snapshot, err := tr2.ReadSnapshot()
+	if err != nil {
+		t.Fatalf("read snapshot error: %v", err)
+	}
+	if len(snapshot.Entries) != 1 || snapshot.Entries[0].Value != binary.Value {
+		t.Errorf("got snapshot entries %+v, want %q", snapshot.Entries, binary.Value)
+	}
+}
+
+func TestDamagedJournal(t *testing.T) {
+	ctx := context.Background()
+	defer os.Remove(filename)
+
+	intact := appendRecord(journalHeaderBytes(), []Event{{Sequence: 1, EventType: EventPut, Key: "a", Value: "1"}})
+	second := appendRecord(nil, []Event{
+		{Sequence: 2, EventType: EventPut, Key: "b", Value: "2"},
+		{Sequence: 3, EventType: EventPut, Key: "c", Value: "3"},
+	})
+	third := appendRecord(nil, []Event{{Sequence: 4, EventType: EventPut, Key: "d", Value: "4"}})
+
+	flipped := append([]byte(nil), second...)
+	flipped[len(flipped)-1] ^= 0xff
+	garbage := bytes.Repeat([]byte{0xab}, 64)
+	// a record header whose length runs past the end of the journal
+	badLength := binary.BigEndian.AppendUint32(nil, maxRecordSize+1)
+	badLength = binary.BigEndian.AppendUint32(badLength, 0)
+
+	tests := []struct {
+		name    string
+		journal []byte
+		keys    string
+		err     error
+		size    int // journal size after reading
+	}{
+		{"Torn Record", concat(intact, second[:len(second)-3]), "[a]", ErrTornRecord, len(intact)},
+		{"Torn Header", concat(intact, second[:5]), "[a]", ErrTornRecord, len(intact)},
+		{"Checksum Mismatch At Tail", concat(intact, flipped), "[a]", ErrTornRecord, len(intact)},
+		{"Zeroed Tail", concat(intact, make([]byte, 64)), "[a]", ErrTornRecord, len(intact)},
+		{"Garbage Tail", concat(intact, flipped, garbage), "[a]", ErrTornRecord, len(intact)},
+		{"Bad Length At Tail", concat(intact, badLength, garbage), "[a]", ErrTornRecord, len(intact)},
+		{"Corrupted Record", concat(intact, flipped, third), "[a]", ErrCorruptRecord, len(intact) + len(flipped) + len(third)},
+		{"Corrupted Length", concat(intact, badLength, garbage, third), "[a]", ErrCorruptRecord, len(intact) + len(badLength) + len(garbage) + len(third)},
+		{"Intact", concat(intact, second, third), "[a b c d]", nil, len(intact) + len(second) + len(third)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(filename, tt.journal, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			tr, err := NewFileTransactor(ctx, config.JournalConfig{})
+			if err != nil {
+				t.Fatalf("cannot create transactor: %v", err)
+			}
+			defer tr.Close()
+
+			var keys []string
+			eventsCh, errCh := tr.ReadEvents()
+			for e := range eventsCh {
+				keys = append(keys, e.Key)
+			}
+			if err := <-errCh; !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+
+			if fmt.Sprint(keys) != tt.keys {
+				t.Errorf("got keys %v, want %s", keys, tt.keys)
+			}
+			if info, _ := os.Stat(filename); info.Size() != int64(tt.size) {
+				t.Errorf("got journal size %d, want %d", info.Size(), tt.size)
+			}
+		})
+	}
+}
+
+func TestJournalNamespaces(t *testing.T) {
+	ctx := context.Background()
+	defer os.Remove(filename)
+
+	// a version 1 journal, written before events carried a namespace
+	var payload []byte
+	payload = binary.AppendUvarint(payload, 1)
+	payload = binary.AppendUvarint(payload, 1)
+	payload = append(payload, byte(EventPut))
+	payload = binary.AppendVarint(payload, 0)
+	payload = binary.AppendUvarint(payload, 1)
+	payload = append(payload, "a"...)
+	payload = binary.AppendUvarint(payload, 1)
+	payload = append(payload, "1"...)
+
+	journal := append([]byte(journalMagic), 1)
+	journal = binary.BigEndian.AppendUint32(journal, uint32(len(payload)))
+	journal = binary.BigEndian.AppendUint32(journal, crc32.Checksum(payload, crcTable))
+	journal = append(journal, payload...)
+	if err := os.WriteFile(filename, journal, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	eventsCh, errCh := tr.ReadEvents() // finds the end of the journal
+	for range eventsCh {
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	err = write(ctx, tr, []Event{
+		{EventType: EventPut, Namespace: "team", Key: "b", Value: "2"},
+		{EventType: EventDrop, Namespace: "other"},
+	})
+	if err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	tr.Close()
+
+	data, _ := os.ReadFile(filename)
+	if !bytes.HasPrefix(data, journalHeaderBytes()) {
+		t.Errorf("journal was not upgraded, header %q", data[:journalHeader])
+	}
+
+	tr, err = NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	defer tr.Close()
+
+	var got []string
+	eventsCh, errCh = tr.ReadEvents()
+	for e := range eventsCh {
+		got = append(got, fmt.Sprintf("%d %s/%s", e.EventType, e.Namespace, e.Key))
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	want := fmt.Sprintf("[%d /a %d team/b %d other/]", EventPut, EventPut, EventDrop)
+	if fmt.Sprint(got) != want {
+		t.Errorf("got events %v, want %s", got, want)
+	}
+}
+
+func TestJournalMetadata(t *testing.T) {
+	ctx := context.Background()
+	defer func() {
+		os.Remove(filename)
+		os.Remove(snapshotFilename)
+	}()
+
+	created := time.Unix(1700000000, 123)
+	meta := Metadata{
+		ContentType: "application/json",
+		Headers:     map[string]string{"owner": "ops", "build": "42"},
+		CreatedAt:   created,
+		UpdatedAt:   created.Add(time.Minute),
+	}
+	sameMetadata := func(got Metadata) bool {
+		return got.ContentType == meta.ContentType && maps.Equal(got.Headers, meta.Headers) &&
+			got.CreatedAt.Equal(meta.CreatedAt) && got.UpdatedAt.Equal(meta.UpdatedAt)
+	}
+
+	tr, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	if err := tr.WriteSnapshot(ctx, Snapshot{Entries: []Event{{EventType: EventPut, Key: "snapshotted", Value: "{}", Metadata: meta}}}); err != nil {
+		t.Fatalf("snapshot error: %v", err)
+	}
+	if err := put(ctx, tr, "journaled", "{}", meta); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if err := put(ctx, tr, "plain", "value", Metadata{}); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	tr.Close()
+
+	tr, err = NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	defer tr.Close()
+
+	snapshot, err := tr.ReadSnapshot()
+	if err != nil {
+		t.Fatalf("read snapshot error: %v", err)
+	}
+	if len(snapshot.Entries) != 1 || !sameMetadata(snapshot.Entries[0].Metadata) {
+		t.Errorf("got snapshot entries %+v", snapshot.Entries)
+	}
+
+	var keys []string
+	eventsCh, errCh := tr.ReadEvents()
+	for e := range eventsCh {
+		keys = append(keys, e.Key)
+		switch {
+		case e.Key == "journaled" && !sameMetadata(e.Metadata):
+			t.Errorf("got metadata %+v, want %+v", e.Metadata, meta)
+		case e.Key == "plain" && (e.Metadata.ContentType != "" || e.Metadata.Headers != nil || !e.Metadata.CreatedAt.IsZero()):
+			t.Errorf("got metadata %+v for a plain put", e.Metadata)
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if fmt.Sprint(keys) != "[journaled plain]" {
+		t.Errorf("got events %v", keys)
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func TestDurabilityModes(t *testing.T) {
+	ctx := context.Background()
+
+	for _, durability := range []Durability{DurabilitySync, DurabilityGroupCommit, DurabilityAsync} {
+		t.Run(string(durability), func(t *testing.T) {
+			defer os.Remove(filename)
+
+			tr, err := NewFileTransactor(ctx, config.JournalConfig{Durability: string(durability)})
+			if err != nil {
+				t.Fatalf("cannot create transactor: %v", err)
+			}
+
+			const writers, writes = 8, 25
+
+			wg := &sync.WaitGroup{}
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(id int) {
+					defer wg.Done()
+					for j := 0; j < writes; j++ {
+						if err := put(ctx, tr, fmt.Sprintf("key-%d-%d", id, j), "value", Metadata{}); err != nil {
+							t.Errorf("write error: %v", err)
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			if durability != DurabilityAsync {
+				// every acknowledged write is already on disk
+				var n int
+				eventsCh, errCh := tr.ReplayEvents(ctx, 0)
+				for range eventsCh {
+					n++
+				}
+				if err := <-errCh; err != nil {
+					t.Fatalf("replay error: %v", err)
+				}
+				if n != writers*writes {
+					t.Errorf("got %d events on disk, want %d", n, writers*writes)
+				}
+			}
+
+			// queued writes are committed before Close returns
+			if err := tr.Close(); err != nil {
+				t.Fatalf("close error: %v", err)
+			}
+
+			tr1, err := NewFileTransactor(ctx, config.JournalConfig{Durability: string(durability)})
+			if err != nil {
+				t.Fatalf("cannot create transactor: %v", err)
+			}
+			defer tr1.Close()
+
+			var n int
+			eventsCh, errCh := tr1.ReadEvents()
+			for range eventsCh {
+				n++
+			}
+			if err := <-errCh; err != nil {
+				t.Fatalf("read error: %v", err)
+			}
+			if n != writers*writes {
+				t.Errorf("got %d events after reopen, want %d", n, writers*writes)
+			}
+		})
+	}
+
+	if _, err := NewFileTransactor(ctx, config.JournalConfig{Durability: "eventually"}); err == nil {
+		t.Error("expected an error for an unknown durability")
+	}
+}
+
+func TestFailedWriteDegradesJournal(t *testing.T) {
+	ctx := context.Background()
+	defer os.Remove(filename)
+
+	tr, err := NewFileTransactor(ctx, config.JournalConfig{})
+	if err != nil {
+		t.Fatalf("cannot create transactor: %v", err)
+	}
+	if !tr.Health().Healthy {
+		t.Fatal("new journal is not healthy")
+	}
+
+	// a read-only handle fails every write like a full disk would
+	writable := tr.file
+	defer writable.Close()
+	if tr.file, err = os.Open(filename); err != nil {
+		t.Fatalf("cannot open journal: %v", err)
+	}
+	defer tr.Close()
+
+	if err := put(ctx, tr, "key", "value", Metadata{}); err == nil {
+		t.Fatal("expected write error, got nil")
+	}
+
+	health := tr.Health()
+	if health.Healthy || health.LastError == nil || health.Since.IsZero() {
+		t.Errorf("journal is not degraded: %+v", health)
+	}
+	if err := put(ctx, tr, "key", "value", Metadata{}); !errors.Is(err, ErrJournalDegraded) {
+		t.Errorf("expected error %v, got %v", ErrJournalDegraded, err)
+	}
+}
+
+func TestIsDataError(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"Invalid Encoding", fmt.Errorf("insert failure: %w", &pgconn.PgError{Code: "22021"}), true},
+		{"Duplicate Sequence", &pgconn.PgError{Code: "23505"}, true},
+		{"Admin Shutdown", &pgconn.PgError{Code: "57P01"}, false},
+		{"Connection", errors.New("connection reset by peer"), false},
+		{"None", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isDataError(tc.err); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPostgresAppendCanceled(t *testing.T) {
+	// a full queue without a writer, the append can only give up
+	tr := &PostgresTransactor{events: make(chan writeRequest), done: make(chan struct{})}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := tr.Append(ctx, []Event{{EventType: EventPut, Key: "key", Value: "value"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
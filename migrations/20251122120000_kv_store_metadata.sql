@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS headers JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE transactions
+    DROP COLUMN IF EXISTS content_type,
+    DROP COLUMN IF EXISTS headers,
+    DROP COLUMN IF EXISTS created_at,
+    DROP COLUMN IF EXISTS updated_at;
+-- +goose StatementEnd
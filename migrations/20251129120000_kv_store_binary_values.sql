@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE transactions ALTER COLUMN value TYPE BYTEA USING convert_to(value, 'UTF8');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE transactions ALTER COLUMN value TYPE TEXT USING convert_from(value, 'UTF8');
+-- +goose StatementEnd